@@ -0,0 +1,598 @@
+// Package api serves TorontoBot over an HTTP JSON API, along with a small web UI for asking questions
+// in the browser.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/viz"
+)
+
+const (
+	// maxQuestionLen keeps questions to a sensible length before they're sent to the LLM.
+	maxQuestionLen = 500
+	// maxCachedCharts is how many chart selections are kept to avoid asking the LLM again.
+	maxCachedCharts = 1000
+	chartWidth      = 800
+	chartHeight     = 600
+)
+
+// Server handles API requests. Questions go through the same pipeline, storage and rate limiting as
+// Discord.
+type Server struct {
+	db       *sql.DB
+	bot      *bot.TorontoBot
+	keys     map[string]string
+	insecure bool
+
+	mu     sync.Mutex
+	charts map[int64]*bot.ChartSelectResponse
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithInsecure opens the API to anyone, rate limited by IP address, when no API keys are given.
+// Without it, an API with no keys rejects every request.
+func WithInsecure() Option {
+	return func(s *Server) {
+		s.insecure = true
+	}
+}
+
+// NewServer returns an API server. apiKeys maps each accepted API key to a name identifying its
+// holder, which is recorded against their questions.
+func NewServer(db *sql.DB, tb *bot.TorontoBot, apiKeys map[string]string, options ...Option) *Server {
+	s := &Server{
+		db:     db,
+		bot:    tb,
+		keys:   apiKeys,
+		charts: map[int64]*bot.ChartSelectResponse{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Handler returns the API's routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ask", s.authenticated(s.handleAsk))
+	mux.HandleFunc("/datasets", s.authenticated(s.handleDatasets))
+	mux.HandleFunc("/queries/", s.authenticated(s.queryHandler("/queries/", false)))
+	s.handleWeb(mux)
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, &errorResponse{Error: msg})
+}
+
+// httpError is an error message to be sent to the client with an HTTP status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+type contextKey string
+
+// userKey holds the rate limiting key of the client making a request.
+const userKey contextKey = "user"
+
+// authenticated requires a valid API key, passed as a bearer token or in the X-API-Key header,
+// unless the server is insecure and has no keys.
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user string
+		if len(s.keys) == 0 && s.insecure {
+			user = "api:" + clientIP(r)
+		} else {
+			key := r.Header.Get("X-API-Key")
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				key = strings.TrimPrefix(auth, "Bearer ")
+			}
+			name, ok := s.lookupKey(key)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "missing or invalid API key")
+				return
+			}
+			user = "api:" + name
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	}
+}
+
+// Authenticated requires a valid API key for h, as for the API's own routes, so that other handlers
+// can be served alongside the API.
+func (s *Server) Authenticated(h http.Handler) http.Handler {
+	return s.authenticated(h.ServeHTTP)
+}
+
+// lookupKey returns the name of the holder of an API key, comparing in constant time.
+func (s *Server) lookupKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for k, name := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// public allows anyone, rate limiting them by IP address.
+func public(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := "web:" + clientIP(r)
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequestUser returns the rate limiting key of the client making a request passed through
+// Authenticated.
+func RequestUser(r *http.Request) string {
+	return requestUser(r)
+}
+
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey).(string)
+	return user
+}
+
+type askRequest struct {
+	Question string `json:"question"`
+}
+
+type chartSpec struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	IsCurrency bool         `json:"is_currency"`
+	Data       []*chartData `json:"data"`
+}
+
+type chartData struct {
+	Name  string  `json:"name,omitempty"`
+	Date  int     `json:"date,omitempty"`
+	Value float64 `json:"value"`
+}
+
+type answerResponse struct {
+	ID            int64           `json:"id,omitempty"`
+	Question      string          `json:"question"`
+	Table         string          `json:"table"`
+	Schema        string          `json:"schema,omitempty"`
+	Applicability string          `json:"applicability,omitempty"`
+	MissingData   string          `json:"missing_data,omitempty"`
+	SQL           string          `json:"sql,omitempty"`
+	IsCurrency    bool            `json:"is_currency"`
+	Columns       []string        `json:"columns,omitempty"`
+	Rows          [][]interface{} `json:"rows"`
+	Chart         *chartSpec      `json:"chart,omitempty"`
+	// ChartJS draws the chart in the web UI.
+	ChartJS   string     `json:"chart_js,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// LastRefreshed is when the table's data was last ingested, if known.
+	LastRefreshed *time.Time        `json:"last_refreshed,omitempty"`
+	Links         map[string]string `json:"links,omitempty"`
+}
+
+func (s *Server) handleAsk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req askRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" || len(req.Question) > maxQuestionLen {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("question must be 1 to %d characters", maxQuestionLen))
+		return
+	}
+
+	ctx := r.Context()
+	user := requestUser(r)
+	log.Printf("Received API question from %s: %s\n", user, req.Question)
+	answer, err := s.bot.Ask(ctx, &bot.AskRequest{
+		Question: req.Question,
+		User:     user,
+	})
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case answer == nil:
+		log.Println("Error answering API question:", err)
+		writeError(w, http.StatusBadGateway, "could not answer the question")
+		return
+	}
+
+	resp, query, herr := s.storeAnswer(req.Question, user, false, answer, err)
+	if herr != nil {
+		writeError(w, herr.status, herr.msg)
+		return
+	}
+	if query != nil {
+		resp.Links = queryLinks("/queries/", query.ID)
+		// A chart suggestion is a nice-to-have, so the answer doesn't fail without one.
+		if chart, err := s.chart(ctx, query, user); err != nil {
+			log.Println("Error selecting chart:", err)
+		} else {
+			resp.Chart = newChartSpec(chart)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// storeAnswer stores an answered question and loads the raw rows of its results, taking the error
+// returned by Ask with the answer. When the data can't answer the question or there were no results,
+// nothing is stored and the returned query is nil.
+func (s *Server) storeAnswer(question, user string, isPublic bool, answer *bot.Answer, err error) (*answerResponse, *uq.UserQuery, *httpError) {
+	resp := &answerResponse{
+		Question:      question,
+		Table:         answer.Table.Name,
+		Schema:        answer.SQLResponse.Schema,
+		Applicability: answer.SQLResponse.Applicability,
+		MissingData:   answer.SQLResponse.MissingData,
+		SQL:           answer.SQLResponse.SQL,
+		IsCurrency:    answer.SQLResponse.IsCurrency,
+		Rows:          [][]interface{}{},
+		LastRefreshed: timeOrNil(answer.LastRefreshed),
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows), resp.MissingData != "":
+		return resp, nil, nil
+	case err != nil:
+		log.Println("Error running query:", err)
+		return nil, nil, &httpError{http.StatusUnprocessableEntity, "the query written for this question failed"}
+	}
+
+	// The rendered results are formatted for people, so run the query again for raw values, which are
+	// stored to serve later.
+	if resp.Columns, resp.Rows, err = s.bot.LoadRows(answer.SQLResponse.SQL); err != nil {
+		log.Println("Error loading rows:", err)
+		return nil, nil, &httpError{http.StatusInternalServerError, "could not load rows"}
+	}
+
+	// Store query for subsequent charting and export,
+	query := &uq.UserQuery{
+		UserID:      user,
+		ChannelID:   "api",
+		Question:    question,
+		TableName:   answer.Table.Name,
+		SQLResponse: answer.SQLResponse,
+		Results:     answer.Results,
+		Columns:     resp.Columns,
+		Rows:        resp.Rows,
+		IsPublic:    isPublic,
+	}
+	if resp.ID, err = uq.StoreUserQuery(s.db, query); err != nil {
+		log.Println("Error storing query:", err)
+		return nil, nil, &httpError{http.StatusInternalServerError, "could not save query"}
+	}
+	query.ID = resp.ID
+	return resp, query, nil
+}
+
+func (s *Server) handleDatasets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	type dataset struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Schema      string   `json:"schema"`
+		Source      string   `json:"source,omitempty"`
+		Examples    []string `json:"examples,omitempty"`
+		// LastRefreshed is when the dataset was last ingested, if known.
+		LastRefreshed *time.Time `json:"last_refreshed,omitempty"`
+	}
+	datasets := []*dataset{}
+	for _, table := range s.bot.Tables() {
+		ds := &dataset{
+			Name:        table.Name,
+			Description: table.Desc,
+			Schema:      table.Schema,
+			Source:      table.Source,
+			Examples:    table.Examples,
+		}
+		refreshed, err := s.bot.LastRefreshed(table.Name)
+		if err != nil {
+			log.Println("Error getting last refresh:", err)
+		}
+		ds.LastRefreshed = timeOrNil(refreshed)
+		datasets = append(datasets, ds)
+	}
+	writeJSON(w, http.StatusOK, datasets)
+}
+
+// queryHandler serves stored queries at prefix followed by their ID, with their chart and data
+// beneath. If publicOnly is set, only public queries are served, and otherwise only those asked by
+// the same client.
+func (s *Server) queryHandler(prefix string, publicOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.handleQuery(w, r, prefix, publicOnly)
+	}
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request, prefix string, publicOnly bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	query, err := uq.GetUserQuery(s.db, parts[0])
+	if err != nil {
+		log.Println("Error getting query:", err)
+		writeError(w, http.StatusInternalServerError, "could not load query")
+		return
+	}
+	if query == nil || (publicOnly && !query.IsPublic) || (!publicOnly && query.UserID != requestUser(r)) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("query %d not found", id))
+		return
+	}
+
+	resource := ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	}
+	switch resource {
+	case "":
+		s.writeQuery(w, query, queryLinks(prefix, query.ID))
+	case "data.csv":
+		s.writeCSV(w, query)
+	case "chart.png", "chart.svg":
+		s.writeChart(w, r, query, strings.TrimPrefix(resource, "chart."))
+	case "chart.js":
+		s.writeChartJS(w, r, query)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) writeQuery(w http.ResponseWriter, query *uq.UserQuery, links map[string]string) {
+	resp := &answerResponse{
+		ID:            query.ID,
+		Question:      query.Question,
+		Table:         query.TableName,
+		Schema:        query.SQLResponse.Schema,
+		Applicability: query.SQLResponse.Applicability,
+		SQL:           query.SQLResponse.SQL,
+		IsCurrency:    query.SQLResponse.IsCurrency,
+		Columns:       query.Columns,
+		Rows:          query.Rows,
+		CreatedAt:     &query.CreatedAt,
+		Links:         links,
+	}
+	if resp.Rows == nil {
+		resp.Rows = [][]interface{}{}
+	}
+	s.mu.Lock()
+	if chart, ok := s.charts[query.ID]; ok {
+		resp.Chart = newChartSpec(chart)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) writeCSV(w http.ResponseWriter, query *uq.UserQuery) {
+	columns, rows := query.Columns, query.Rows
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="query-%d.csv"`, query.ID))
+	cw := csv.NewWriter(w)
+	record := make([]string, len(columns))
+	if err := cw.Write(columns); err != nil {
+		log.Println("Error writing CSV:", err)
+		return
+	}
+	for _, row := range rows {
+		for i, v := range row {
+			if v == nil {
+				record[i] = ""
+			} else {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			log.Println("Error writing CSV:", err)
+			return
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Println("Error writing CSV:", err)
+	}
+}
+
+// selectChart writes an error response and returns nil if a chart can't be selected for query.
+func (s *Server) selectChart(w http.ResponseWriter, r *http.Request, query *uq.UserQuery) *bot.ChartSelectResponse {
+	chart, err := s.chart(r.Context(), query, requestUser(r))
+	if err != nil {
+		if errors.Is(err, bot.ErrRateLimited) {
+			writeError(w, http.StatusTooManyRequests, err.Error())
+			return nil
+		}
+		log.Println("Error selecting chart:", err)
+		writeError(w, http.StatusBadGateway, "could not select a chart")
+		return nil
+	}
+	return chart
+}
+
+// writeChartError writes the response for an error rendering a chart.
+func writeChartError(w http.ResponseWriter, err error) {
+	var unsupported *bot.UnsupportedChartError
+	if errors.As(err, &unsupported) {
+		writeError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	log.Println("Error generating chart:", err)
+	writeError(w, http.StatusInternalServerError, "could not draw chart")
+}
+
+func (s *Server) writeChart(w http.ResponseWriter, r *http.Request, query *uq.UserQuery, format string) {
+	ctx := r.Context()
+	chart := s.selectChart(w, r, query)
+	if chart == nil {
+		return
+	}
+	chartHTML, err := chart.HTML(false, chartOptions()...)
+	if err != nil {
+		writeChartError(w, err)
+		return
+	}
+
+	switch format {
+	case "png":
+		png, err := viz.ScreenshotHTML(ctx, chartHTML, viz.WithWidth(chartWidth), viz.WithHeight(chartHeight), viz.WithWaitForSelector("svg"))
+		if err != nil {
+			log.Println("Error generating PNG:", err)
+			writeError(w, http.StatusInternalServerError, "could not draw chart")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	case "svg":
+		svg, err := viz.RenderSVG(ctx, chartHTML)
+		if err != nil {
+			log.Println("Error generating SVG:", err)
+			writeError(w, http.StatusInternalServerError, "could not draw chart")
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(svg))
+	}
+}
+
+// writeChartJS writes a script that draws the query's chart into the element with ID "chart". It
+// depends on d3 being loaded on the page.
+func (s *Server) writeChartJS(w http.ResponseWriter, r *http.Request, query *uq.UserQuery) {
+	chart := s.selectChart(w, r, query)
+	if chart == nil {
+		return
+	}
+	js, err := chart.JS("#chart", chartOptions()...)
+	if err != nil {
+		writeChartError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Write([]byte(js))
+}
+
+func chartOptions() []viz.ChartOption {
+	return []viz.ChartOption{viz.WithFixedWidth(chartWidth), viz.WithFixedHeight(chartHeight)}
+}
+
+// chart returns the chart selected for a query, asking the LLM on behalf of user if it hasn't been
+// selected before.
+func (s *Server) chart(ctx context.Context, query *uq.UserQuery, user string) (*bot.ChartSelectResponse, error) {
+	s.mu.Lock()
+	chart, ok := s.charts[query.ID]
+	s.mu.Unlock()
+	if ok {
+		return chart, nil
+	}
+
+	if !s.bot.Allow(user) {
+		return nil, bot.ErrRateLimited
+	}
+	chart, err := s.bot.SelectChart(ctx, query.Question, query.Results)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.charts) >= maxCachedCharts {
+		s.charts = map[int64]*bot.ChartSelectResponse{}
+	}
+	s.charts[query.ID] = chart
+	return chart, nil
+}
+
+func newChartSpec(chart *bot.ChartSelectResponse) *chartSpec {
+	spec := &chartSpec{
+		Type:       chart.Chart,
+		Title:      chart.Title,
+		IsCurrency: chart.ValueIsCurrency,
+		Data:       []*chartData{},
+	}
+	for _, d := range chart.Data {
+		spec.Data = append(spec.Data, &chartData{Name: d.Name, Date: d.Date, Value: d.Value})
+	}
+	return spec
+}
+
+// queryLinks returns the paths of a query's resources beneath prefix.
+func queryLinks(prefix string, id int64) map[string]string {
+	base := fmt.Sprintf("%s%d", prefix, id)
+	return map[string]string{
+		"self":      base,
+		"chart_js":  base + "/chart.js",
+		"chart_png": base + "/chart.png",
+		"chart_svg": base + "/chart.svg",
+		"data_csv":  base + "/data.csv",
+	}
+}
+
+// timeOrNil returns a pointer to t, or nil if it's the zero time, so it's omitted from JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+// storeQuery stores an answer to a question asked by user, returning its ID.
+func storeQuery(t *testing.T, db *sql.DB, user string) int64 {
+	t.Helper()
+	id, err := uq.StoreUserQuery(db, &uq.UserQuery{
+		UserID:      user,
+		ChannelID:   "api",
+		Question:    "How many tickets were issued each year?",
+		TableName:   "ase_tickets",
+		SQLResponse: &bot.SQLResponse{SQL: "SELECT year, SUM(ticket_count) FROM ase_tickets GROUP BY year"},
+		Results:     "rendered",
+		Columns:     []string{"year", "tickets"},
+		Rows:        [][]interface{}{{int64(2022), int64(1500000)}, {int64(2023), 2.5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func get(t *testing.T, h http.Handler, path, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestQueryOwner(t *testing.T) {
+	db := testutil.DB(t)
+	s := NewServer(db, nil, map[string]string{"alice-key": "alice", "bob-key": "bob"})
+	h := s.Handler()
+	alices := storeQuery(t, db, "api:alice")
+	discord := storeQuery(t, db, "1234567890")
+	path := func(id int64, resource string) string {
+		return "/queries/" + strconv.FormatInt(id, 10) + resource
+	}
+
+	w := get(t, h, path(alices, ""), "alice-key")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET own query = %d %s", w.Code, w.Body)
+	}
+	var resp answerResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// The stored rows are served, as they were.
+	if got, _ := json.Marshal(resp.Rows); string(got) != `[[2022,1500000],[2023,2.5]]` {
+		t.Errorf("rows = %s", got)
+	}
+
+	w = get(t, h, path(alices, "/data.csv"), "alice-key")
+	if want := "year,tickets\n2022,1500000\n2023,2.5\n"; w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("GET own query CSV = %d %q, want %q", w.Code, w.Body, want)
+	}
+
+	for _, test := range []struct {
+		path, key string
+		want      int
+	}{
+		{path(alices, ""), "bob-key", http.StatusNotFound},
+		{path(alices, "/data.csv"), "bob-key", http.StatusNotFound},
+		{path(discord, ""), "alice-key", http.StatusNotFound},
+		{path(alices, ""), "", http.StatusUnauthorized},
+		{path(alices, ""), "wrong", http.StatusUnauthorized},
+	} {
+		if w := get(t, h, test.path, test.key); w.Code != test.want {
+			t.Errorf("GET %s with key %q = %d, want %d", test.path, test.key, w.Code, test.want)
+		}
+	}
+}
+
+func TestNoKeys(t *testing.T) {
+	db := testutil.DB(t)
+	id := storeQuery(t, db, "api:192.0.2.1")
+	path := "/queries/" + strconv.FormatInt(id, 10)
+
+	// Without keys, every request is rejected.
+	h := NewServer(db, nil, nil).Handler()
+	for _, p := range []string{path, "/datasets"} {
+		if w := get(t, h, p, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without keys = %d, want %d", p, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// An insecure server lets anyone in, by their IP address.
+	h = NewServer(db, nil, nil, WithInsecure()).Handler()
+	if w := get(t, h, path, ""); w.Code != http.StatusOK {
+		t.Errorf("GET %s from the asker's address on an insecure server = %d %s", path, w.Code, w.Body)
+	}
+}
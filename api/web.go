@@ -0,0 +1,197 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+// recentLimit is how many recent public questions the web UI lists.
+const recentLimit = 20
+
+//go:embed web
+var webFiles embed.FS
+
+// permalinkPath matches the paths of the web UI's permalinks to answers.
+var permalinkPath = regexp.MustCompile(`^/q/[0-9]+$`)
+
+// handleWeb adds the routes of the web UI. Its endpoints under /web/ are open to anyone, rate limited
+// by IP address, and only serve public queries.
+func (s *Server) handleWeb(mux *http.ServeMux) {
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.HandleFunc("/", serveIndex(static))
+	mux.Handle("/static/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/web/ask", public(s.handleWebAsk))
+	mux.HandleFunc("/web/recent", public(s.handleRecent))
+	mux.HandleFunc("/web/queries/", public(s.queryHandler("/web/queries/", true)))
+}
+
+// serveIndex serves the web UI's page at / and at permalinks to answers.
+func serveIndex(static fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && !permalinkPath.MatchString(r.URL.Path) {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		index, err := fs.ReadFile(static, "index.html")
+		if err != nil {
+			log.Println("Error reading index.html:", err)
+			writeError(w, http.StatusInternalServerError, "could not load page")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(index)
+	}
+}
+
+// eventStream writes server-sent events.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (e *eventStream) send(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+	fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, data)
+	e.flusher.Flush()
+}
+
+func (e *eventStream) progress(msg string) {
+	e.send("progress", map[string]string{"message": msg})
+}
+
+func (e *eventStream) fail(msg string) {
+	e.send("error", &errorResponse{Error: msg})
+}
+
+// handleWebAsk answers the question in the q parameter, streaming progress as server-sent events
+// until an "answer" or "error" event. The answer is public if the public parameter is set.
+func (s *Server) handleWebAsk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	question := strings.TrimSpace(r.URL.Query().Get("q"))
+	if question == "" || len(question) > maxQuestionLen {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("question must be 1 to %d characters", maxQuestionLen))
+		return
+	}
+	isPublic := r.URL.Query().Get("public") != ""
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	events := &eventStream{w: w, flusher: flusher}
+
+	ctx := r.Context()
+	user := requestUser(r)
+	log.Printf("Received web question from %s: %s\n", user, question)
+	events.progress("Choosing a dataset...")
+	answer, err := s.bot.Ask(ctx, &bot.AskRequest{
+		Question: question,
+		User:     user,
+		OnTable: func(table *bot.DataTable) {
+			events.progress(fmt.Sprintf("Writing SQL for %s...", table.Name))
+		},
+		OnAnalysis: func(table *bot.DataTable, sqlAnalysis *bot.SQLResponse) {
+			events.send("analysis", &answerResponse{
+				Question:      question,
+				Table:         table.Name,
+				Schema:        sqlAnalysis.Schema,
+				Applicability: sqlAnalysis.Applicability,
+				SQL:           sqlAnalysis.SQL,
+				IsCurrency:    sqlAnalysis.IsCurrency,
+				Rows:          [][]interface{}{},
+			})
+			events.progress("Running the query...")
+		},
+	})
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		events.fail(err.Error())
+		return
+	case answer == nil:
+		log.Println("Error answering web question:", err)
+		events.fail("Sorry, I couldn't answer that. Please try rephrasing your question.")
+		return
+	}
+
+	resp, query, herr := s.storeAnswer(question, user, isPublic, answer, err)
+	if herr != nil {
+		events.fail(herr.msg)
+		return
+	}
+	if query != nil && isPublic {
+		resp.Links = queryLinks("/web/queries/", query.ID)
+		resp.Links["permalink"] = fmt.Sprintf("/q/%d", query.ID)
+	}
+	if query != nil {
+		events.progress("Choosing a chart...")
+		// A chart is a nice-to-have, so the answer doesn't fail without one.
+		if chart, err := s.chart(ctx, query, user); err != nil {
+			log.Println("Error selecting chart:", err)
+		} else {
+			resp.Chart = newChartSpec(chart)
+			if js, err := chart.JS("#chart", chartOptions()...); err == nil {
+				resp.ChartJS = js
+			}
+		}
+	}
+	events.send("answer", resp)
+}
+
+type recentQuery struct {
+	ID        int64     `json:"id"`
+	Question  string    `json:"question"`
+	Table     string    `json:"table"`
+	CreatedAt time.Time `json:"created_at"`
+	Permalink string    `json:"permalink"`
+}
+
+// handleRecent lists the most recent public questions.
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	queries, err := uq.RecentPublicQueries(s.db, recentLimit)
+	if err != nil {
+		log.Println("Error listing recent queries:", err)
+		writeError(w, http.StatusInternalServerError, "could not list recent questions")
+		return
+	}
+	recent := []*recentQuery{}
+	for _, q := range queries {
+		recent = append(recent, &recentQuery{
+			ID:        q.ID,
+			Question:  q.Question,
+			Table:     q.TableName,
+			CreatedAt: q.CreatedAt,
+			Permalink: fmt.Sprintf("/q/%d", q.ID),
+		})
+	}
+	writeJSON(w, http.StatusOK, recent)
+}
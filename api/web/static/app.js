@@ -0,0 +1,215 @@
+// TorontoBot web UI: asks questions over server-sent events and draws answers with the chart
+// scripts served by the API.
+
+const $ = (id) => document.getElementById(id);
+
+if (window.matchMedia("(prefers-color-scheme: dark)").matches) {
+  document.documentElement.classList.add("dark");
+}
+
+let source = null;
+
+$("ask").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const question = $("question").value.trim();
+  if (question) ask(question, $("public").checked);
+});
+
+$("copy-link").addEventListener("click", () => {
+  const url = new URL($("copy-link").dataset.permalink, window.location.origin);
+  navigator.clipboard.writeText(url.toString()).then(() => {
+    $("copy-link").textContent = "Copied!";
+    setTimeout(() => ($("copy-link").textContent = "Copy link"), 2000);
+  });
+});
+
+window.addEventListener("popstate", route);
+
+function ask(question, isPublic) {
+  if (source) source.close();
+  reset();
+  setBusy(true);
+
+  const params = new URLSearchParams({ q: question });
+  if (isPublic) params.set("public", "1");
+  source = new EventSource("/web/ask?" + params.toString());
+
+  source.addEventListener("progress", (e) => {
+    const li = document.createElement("li");
+    li.textContent = JSON.parse(e.data).message;
+    $("progress").appendChild(li);
+  });
+  source.addEventListener("analysis", (e) => {
+    showDetails(JSON.parse(e.data));
+  });
+  source.addEventListener("answer", (e) => {
+    finish();
+    const answer = JSON.parse(e.data);
+    showAnswer(answer);
+    if (answer.links && answer.links.permalink) {
+      history.pushState(null, "", answer.links.permalink);
+      loadRecent();
+    }
+  });
+  // The server sends "error" events with a message; the browser sends them without one when the
+  // connection fails.
+  source.addEventListener("error", (e) => {
+    finish();
+    showError(e.data ? JSON.parse(e.data).error : "Sorry, something went wrong. Please try again.");
+  });
+}
+
+function finish() {
+  if (source) source.close();
+  source = null;
+  setBusy(false);
+}
+
+function setBusy(busy) {
+  document.querySelector("#ask button").disabled = busy;
+}
+
+function reset() {
+  $("progress").replaceChildren();
+  $("error").hidden = true;
+  $("answer").hidden = true;
+  $("missing-data").hidden = true;
+  $("chart").replaceChildren();
+  document.querySelector("#results thead").replaceChildren();
+  document.querySelector("#results tbody").replaceChildren();
+}
+
+function showError(msg) {
+  $("error").textContent = msg;
+  $("error").hidden = false;
+}
+
+function showDetails(answer) {
+  $("answer-question").textContent = answer.question;
+  $("answer-table").textContent = answer.table;
+  $("answer-schema").textContent = answer.schema || "";
+  $("answer-applicability").textContent = answer.applicability || "";
+  $("answer-sql").textContent = answer.sql || "";
+}
+
+function showAnswer(answer) {
+  showDetails(answer);
+  $("answer").hidden = false;
+
+  const links = answer.links || {};
+  $("copy-link").hidden = !links.permalink;
+  $("copy-link").dataset.permalink = links.permalink || "";
+  $("csv-link").hidden = !links.data_csv;
+  $("csv-link").href = links.data_csv || "#";
+
+  if (answer.missing_data) {
+    $("missing-data").textContent = answer.missing_data;
+    $("missing-data").hidden = false;
+    return;
+  }
+  if (answer.rows.length === 0) {
+    $("missing-data").textContent = "No results.";
+    $("missing-data").hidden = false;
+    return;
+  }
+  showTable(answer.columns || [], answer.rows);
+
+  if (answer.chart_js) {
+    drawChart(answer.chart_js);
+  } else if (links.chart_js) {
+    fetch(links.chart_js)
+      .then((resp) => (resp.ok ? resp.text() : null))
+      .then((js) => js && drawChart(js));
+  }
+}
+
+function showTable(columns, rows) {
+  const headRow = document.createElement("tr");
+  for (const column of columns) {
+    const th = document.createElement("th");
+    th.textContent = column;
+    headRow.appendChild(th);
+  }
+  document.querySelector("#results thead").appendChild(headRow);
+
+  const tbody = document.querySelector("#results tbody");
+  for (const row of rows) {
+    const tr = document.createElement("tr");
+    for (const value of row) {
+      const td = document.createElement("td");
+      if (typeof value === "number") {
+        td.className = "number";
+        td.textContent = value.toLocaleString();
+      } else {
+        td.textContent = value === null ? "" : value;
+      }
+      tr.appendChild(td);
+    }
+    tbody.appendChild(tr);
+  }
+}
+
+// drawChart runs a chart script from the API, which draws into #chart. The scripts declare
+// top-level constants, so each is run in its own block.
+function drawChart(js) {
+  $("chart").replaceChildren();
+  const script = document.createElement("script");
+  script.textContent = "{\n" + js + "\n}";
+  document.body.appendChild(script);
+  script.remove();
+
+  // Scale the chart down to fit narrow screens.
+  const svg = $("chart").querySelector("svg");
+  if (svg) {
+    svg.setAttribute("viewBox", `0 0 ${svg.getAttribute("width")} ${svg.getAttribute("height")}`);
+  }
+}
+
+function loadRecent() {
+  fetch("/web/recent")
+    .then((resp) => resp.json())
+    .then((recent) => {
+      const list = $("recent-list");
+      list.replaceChildren();
+      for (const query of recent) {
+        const li = document.createElement("li");
+        const a = document.createElement("a");
+        a.href = query.permalink;
+        a.textContent = query.question;
+        a.addEventListener("click", (e) => {
+          e.preventDefault();
+          history.pushState(null, "", query.permalink);
+          route();
+        });
+        const table = document.createElement("span");
+        table.className = "table";
+        table.textContent = " " + query.table;
+        li.append(a, table);
+        list.appendChild(li);
+      }
+      $("recent").hidden = recent.length === 0;
+    });
+}
+
+// route shows the answer a permalink points to, if any.
+function route() {
+  if (source) finish();
+  reset();
+  const match = window.location.pathname.match(/^\/q\/(\d+)$/);
+  if (!match) return;
+
+  fetch("/web/queries/" + match[1])
+    .then((resp) => resp.json().then((body) => ({ ok: resp.ok, body })))
+    .then(({ ok, body }) => {
+      if (!ok) {
+        showError(body.error);
+        return;
+      }
+      body.links.permalink = window.location.pathname;
+      $("question").value = body.question;
+      showAnswer(body);
+    });
+}
+
+route();
+loadRecent();
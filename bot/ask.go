@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/geomodulus/torontobot/db/reader"
+)
+
+// ErrRateLimited is returned when a user has asked too many questions recently.
+var ErrRateLimited = errors.New("too many questions, please wait a little before asking again")
+
+// AskRequest is a question for Ask to answer.
+type AskRequest struct {
+	Question string
+	// User identifies who is asking for rate limiting, e.g. "discord:<user-id>". Requests without a
+	// user aren't rate limited.
+	User string
+	// Table and History are set for follow-up questions, which skip table selection and build on
+	// earlier SQL.
+	Table   *DataTable
+	History []*PriorExchange
+	// OnTable, if set, is called once the table to query has been selected.
+	OnTable func(table *DataTable)
+	// OnAnalysis, if set, is called with the SQL analysis just before the query is run, so that
+	// frontends can show progress.
+	OnAnalysis func(table *DataTable, sqlAnalysis *SQLResponse)
+}
+
+// Answer is the result of answering a question.
+type Answer struct {
+	Table       *DataTable
+	SQLResponse *SQLResponse
+	// Results is the rendered results table, empty when the question couldn't be answered from the
+	// data, as explained by SQLResponse.MissingData.
+	Results string
+	// LastRefreshed is when the table's data was last ingested, or the zero time if it's not known.
+	LastRefreshed time.Time
+}
+
+// Ask answers a question: it selects a table, generates SQL and runs it. It is the pipeline shared by
+// every frontend. If running the query fails, Ask returns the answer so far along with the error,
+// which is sql.ErrNoRows when there were no results.
+func (b *TorontoBot) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	if !b.Allow(req.User) {
+		return nil, ErrRateLimited
+	}
+
+	table := req.Table
+	if table == nil {
+		var err error
+		if table, err = b.SelectTable(ctx, req.Question); err != nil {
+			return nil, fmt.Errorf("selecting table: %w", err)
+		}
+	}
+	if req.OnTable != nil {
+		req.OnTable(table)
+	}
+	sqlAnalysis, err := b.FollowUpSQLAnalysis(ctx, table, req.History, req.Question)
+	if err != nil {
+		return nil, fmt.Errorf("analyzing SQL query: %w", err)
+	}
+
+	answer := &Answer{Table: table, SQLResponse: sqlAnalysis}
+	if answer.LastRefreshed, err = b.LastRefreshed(table.Name); err != nil {
+		log.Println("Error getting last refresh:", err)
+	}
+	if sqlAnalysis.MissingData != "" {
+		return answer, nil
+	}
+	if req.OnAnalysis != nil {
+		req.OnAnalysis(table, sqlAnalysis)
+	}
+	answer.Results, err = b.LoadResults(sqlAnalysis.SQL, sqlAnalysis.IsCurrency)
+	return answer, err
+}
+
+// Allow reports whether user may make another request that calls the LLM, counting it if so.
+func (b *TorontoBot) Allow(user string) bool {
+	if user == "" || b.Limiter == nil {
+		return true
+	}
+	return b.Limiter.Allow(user)
+}
+
+// LoadRows runs a read-only query, returning its column names and raw row values.
+func (b *TorontoBot) LoadRows(sqlQuery string) ([]string, [][]interface{}, error) {
+	return b.LoadRowsContext(context.Background(), sqlQuery, 0)
+}
+
+// LoadRowsContext is LoadRows with a context bounding a local query, returning at most limit rows,
+// or all of them if limit is 0.
+func (b *TorontoBot) LoadRowsContext(ctx context.Context, sqlQuery string, limit int) ([]string, [][]interface{}, error) {
+	sqlQuery = sanitizeQuery(sqlQuery)
+	if err := ValidateReadOnly(sqlQuery); err != nil {
+		return nil, nil, err
+	}
+	pgQuery, err := b.datastoreQuery(sqlQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pgQuery != "" {
+		columns, _, rows, err := b.Datastore.query(pgQuery)
+		if limit > 0 && len(rows) > limit {
+			rows = rows[:limit]
+		}
+		return columns, rows, err
+	}
+	var (
+		columns []string
+		rows    [][]interface{}
+	)
+	err = b.readTables(func(q reader.Querier) error {
+		var err error
+		columns, rows, err = reader.ReadRowsContext(ctx, q, sqlQuery, limit)
+		return err
+	})
+	return columns, rows, err
+}
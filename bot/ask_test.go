@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func TestAsk(t *testing.T) {
+	db := testutil.DB(t)
+	for _, stmt := range []string{
+		"INSERT INTO service_requests (year, ward, service_request_type) VALUES (2022, 'Davenport', 'Pothole'), (2022, 'Spadina-Fort York', 'Graffiti'), (2023, 'Davenport', 'Pothole')",
+		`INSERT INTO ingest_runs (dataset, table_name, year, url, duration_ms, status, started_at, finished_at)
+			VALUES ('311', 'service_requests', 2023, 'https://example.com', 1, 'succeeded', '2026-10-01 09:00:00', '2026-10-01 09:05:00')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	tb, err := New(ctx, db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var analyzed bool
+	answer, err := tb.Ask(ctx, &AskRequest{
+		Question:   "How many service requests were made each year?",
+		OnAnalysis: func(*DataTable, *SQLResponse) { analyzed = true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Table.Name != "service_requests" {
+		t.Errorf("question was routed to %s, want service_requests", answer.Table.Name)
+	}
+	if want := "SELECT year, COUNT(id) AS total_requests FROM service_requests GROUP BY year ORDER BY year;"; answer.SQLResponse.SQL != want {
+		t.Errorf("SQL = %q, want %q", answer.SQLResponse.SQL, want)
+	}
+	if !analyzed {
+		t.Error("OnAnalysis wasn't called")
+	}
+	for _, s := range []string{"YEAR", "TOTAL_REQUESTS", "2,022", "2,023"} {
+		if !strings.Contains(answer.Results, s) {
+			t.Errorf("results don't contain %q:\n%s", s, answer.Results)
+		}
+	}
+	if got := answer.LastRefreshed.Format("2006-01-02"); got != "2026-10-01" {
+		t.Errorf("LastRefreshed = %s, want 2026-10-01", got)
+	}
+
+	// Questions the data can't answer come back with the model's explanation and no query.
+	answer, err = tb.Ask(ctx, &AskRequest{Question: "How many raccoons live in Toronto?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.SQLResponse.MissingData == "" || answer.Results != "" {
+		t.Errorf("unanswerable question got missing data %q and results %q", answer.SQLResponse.MissingData, answer.Results)
+	}
+}
+
+func TestLoadRowsContext(t *testing.T) {
+	ctx := context.Background()
+	tb, err := New(ctx, testutil.DB(t), testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) "
+
+	_, rows, err := tb.LoadRowsContext(ctx, endless+"SELECT i FROM n", 3)
+	if err != nil || len(rows) != 3 {
+		t.Errorf("limited query returned %d rows (%v), want 3", len(rows), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := tb.LoadRowsContext(ctx, endless+"SELECT i FROM n WHERE i < 0", 0); err == nil || ctx.Err() == nil {
+		t.Errorf("endless query returned %v, want it interrupted at its deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("endless query ran for %v after its deadline", elapsed)
+	}
+}
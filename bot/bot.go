@@ -9,8 +9,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -25,7 +27,7 @@ import (
 )
 
 const (
-	// Model is the openai model to query. GPT-4 is expensive, so we use GPT-3.5.
+	// Model is the openai model to query by default. GPT-4 is expensive, so we use GPT-3.5.
 	//Model = openai.GPT4
 	Model = openai.GPT3Dot5Turbo
 	// RespTemp is the response temperature we want from the model. Default temp is 1.0 and higher
@@ -49,14 +51,24 @@ type DataTable struct {
 	Enums        map[string][]interface{}     `json:"enums"`
 	Hints        map[string]map[string]string `json:"hints"`
 	Instructions string                       `json:"instructions"`
+	Source       string                       `json:"source"`
+	// Examples are curated questions which are known to be answered well from this table.
+	Examples []string `json:"examples"`
+	// Kind is DatastoreKind for tables queried live from the open data portal's datastore, where
+	// ResourceID identifies the resource to query, or empty for tables ingested locally.
+	Kind       string `json:"kind,omitempty"`
+	ResourceID string `json:"resource_id,omitempty"`
 }
 
+// EmbeddingText returns the text embedded to select the table for a question. Enums and hints are
+// listed in order so the text is the same every time.
 func (t *DataTable) EmbeddingText() string {
 	txt := t.Name + "\n" + t.Desc + "\n"
 	txt += "Schema:\n" + t.Schema + "\n"
 	if len(t.Enums) > 0 {
 		txt += "Enums:\n"
-		for k, v := range t.Enums {
+		for _, k := range sortedKeys(t.Enums) {
+			v := t.Enums[k]
 			var vals []string
 			for _, val := range v {
 				switch ev := val.(type) {
@@ -73,10 +85,11 @@ func (t *DataTable) EmbeddingText() string {
 	}
 	if len(t.Hints) > 0 {
 		txt += "Hints:\n"
-		for k, v := range t.Hints {
+		for _, k := range sortedKeys(t.Hints) {
+			v := t.Hints[k]
 			txt += " - " + k + ": "
-			for k2, v2 := range v {
-				txt += k2 + ": " + v2 + ", "
+			for _, k2 := range sortedKeys(v) {
+				txt += k2 + ": " + v[k2] + ", "
 			}
 			txt += "\n"
 		}
@@ -84,6 +97,15 @@ func (t *DataTable) EmbeddingText() string {
 	return txt
 }
 
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type MsgTemplate struct {
 	Role         string               `json:"role"`
 	Name         string               `json:"name"`
@@ -107,7 +129,11 @@ func (t *MsgTemplate) Parse() error {
 }
 
 type TorontoBot struct {
-	Hostname          string
+	Hostname string
+	// Limiter, if set, limits how often each user may make requests that call the LLM.
+	Limiter *RateLimiter
+	// Datastore, if set, runs queries against datastore tables. Without it they can't be queried.
+	Datastore         *Datastore
 	sqlGenPrompt      *template.Template
 	sqlGenTemplates   []*MsgTemplate
 	chartSelectPrompt *template.Template
@@ -116,12 +142,36 @@ type TorontoBot struct {
 	db                *sql.DB
 	tables            map[string]*DataTable
 	tableIndex        *index.VectorIndex[string]
+
+	mu    sync.Mutex
+	model string
 }
 
-func New(ctx context.Context, db *sql.DB, ai *openai.Client, store *citygraph.Store, host string) (*TorontoBot, error) {
+// LoadTables returns the tables described in tables.json5, without the embeddings New needs to
+// select between them.
+func LoadTables() ([]*DataTable, error) {
 	var tableList []*DataTable
 	if err := json5.Unmarshal(tablesJSON, &tableList); err != nil {
-		log.Fatalf("Error unmarshalling tables.json5: %s", err)
+		return nil, fmt.Errorf("unmarshalling tables.json5: %v", err)
+	}
+	for _, table := range tableList {
+		switch table.Kind {
+		case "":
+		case DatastoreKind:
+			if table.ResourceID == "" {
+				return nil, fmt.Errorf("datastore table %s has no resource_id", table.Name)
+			}
+		default:
+			return nil, fmt.Errorf("table %s has unknown kind %q", table.Name, table.Kind)
+		}
+	}
+	return tableList, nil
+}
+
+func New(ctx context.Context, db *sql.DB, ai *openai.Client, store *citygraph.Store, host string) (*TorontoBot, error) {
+	tableList, err := LoadTables()
+	if err != nil {
+		log.Fatalf("Error loading tables: %s", err)
 	}
 	tables := map[string]*DataTable{}
 	for _, table := range tableList {
@@ -192,9 +242,42 @@ func New(ctx context.Context, db *sql.DB, ai *openai.Client, store *citygraph.St
 		db:                db,
 		tables:            tables,
 		tableIndex:        tableIndex,
+		model:             Model,
 	}, nil
 }
 
+// Model returns the openai model the bot queries.
+func (b *TorontoBot) Model() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.model
+}
+
+// SetModel changes the openai model the bot queries, for every frontend.
+func (b *TorontoBot) SetModel(model string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model = model
+}
+
+// Tables returns all tables the bot can query, sorted by name.
+func (b *TorontoBot) Tables() []*DataTable {
+	tables := make([]*DataTable, 0, len(b.tables))
+	for _, table := range b.tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+	return tables
+}
+
+// Table returns the table with the given name.
+func (b *TorontoBot) Table(name string) (*DataTable, bool) {
+	table, ok := b.tables[name]
+	return table, ok
+}
+
 func (b *TorontoBot) SelectTable(ctx context.Context, question string) (*DataTable, error) {
 	req := openai.EmbeddingRequestStrings{
 		Input: []string{question},
@@ -219,7 +302,7 @@ func (b *TorontoBot) SelectTable(ctx context.Context, question string) (*DataTab
 	}
 	selected := b.tables[(*searchResults)[0].ID]
 	for _, searchResult := range *searchResults {
-		fmt.Printf("id: %v, distance: %f\n", searchResult.ID, searchResult.Distance)
+		log.Printf("Table %v is at distance %f\n", searchResult.ID, searchResult.Distance)
 	}
 	return selected, nil
 }
@@ -259,7 +342,20 @@ var SQLAnalysisFunction = openai.FunctionDefinition{
 	},
 }
 
+// PriorExchange is an earlier question and the SQL generated to answer it. Follow-up questions are
+// analyzed in the context of prior exchanges.
+type PriorExchange struct {
+	Question string
+	SQL      string
+}
+
 func (b *TorontoBot) SQLAnalysis(ctx context.Context, table *DataTable, question string) (*SQLResponse, error) {
+	return b.FollowUpSQLAnalysis(ctx, table, nil, question)
+}
+
+// FollowUpSQLAnalysis is like SQLAnalysis, but includes prior exchanges, oldest first, in the
+// conversation so the model can refine or build on the previous SQL.
+func (b *TorontoBot) FollowUpSQLAnalysis(ctx context.Context, table *DataTable, history []*PriorExchange, question string) (*SQLResponse, error) {
 	data := struct {
 		Date  string
 		Table *DataTable
@@ -273,15 +369,34 @@ func (b *TorontoBot) SQLAnalysis(ctx context.Context, table *DataTable, question
 		return nil, fmt.Errorf("executing sql_gen template: %v", err)
 	}
 
-	aiResp, err := b.ai.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: Model,
-		Messages: []openai.ChatCompletionMessage{{
-			Role:    openai.ChatMessageRoleSystem,
-			Content: systemPrompt.String(),
-		}, {
+	messages := []openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: systemPrompt.String(),
+	}}
+	for _, prior := range history {
+		args, err := json.Marshal(map[string]string{"sql": prior.SQL})
+		if err != nil {
+			return nil, fmt.Errorf("marshalling prior exchange: %v", err)
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleUser,
-			Content: question,
-		}},
+			Content: prior.Question,
+		}, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant,
+			FunctionCall: &openai.FunctionCall{
+				Name:      SQLAnalysisFunction.Name,
+				Arguments: string(args),
+			},
+		})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: question,
+	})
+
+	aiResp, err := b.ai.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:       b.Model(),
+		Messages:    messages,
 		Temperature: RespTemp,
 		Functions: []openai.FunctionDefinition{
 			SQLAnalysisFunction,
@@ -309,8 +424,72 @@ func (b *TorontoBot) SQLAnalysis(ctx context.Context, table *DataTable, question
 
 func (b *TorontoBot) LoadResults(sqlQuery string, isCurrency bool) (string, error) {
 	sqlQuery = sanitizeQuery(sqlQuery)
-	fmt.Println("running sqlQuery:", sqlQuery)
-	return reader.ReadDataTable(b.db, sqlQuery, isCurrency)
+	if err := ValidateReadOnly(sqlQuery); err != nil {
+		return "", err
+	}
+	pgQuery, err := b.datastoreQuery(sqlQuery)
+	if err != nil {
+		return "", err
+	}
+	if pgQuery != "" {
+		log.Println("Running datastore query:", pgQuery)
+		columns, types, rows, err := b.Datastore.query(pgQuery)
+		if err != nil {
+			return "", err
+		}
+		return reader.RenderDataTable(columns, types, rows, isCurrency)
+	}
+	log.Println("Running query:", sqlQuery)
+	var results string
+	err = b.readTables(func(q reader.Querier) error {
+		var err error
+		results, err = reader.ReadDataTable(q, sqlQuery, isCurrency)
+		return err
+	})
+	return results, err
+}
+
+// TableFingerprint summarizes the current contents of a table so that re-ingestion can be detected:
+// ingest replaces rows, which changes the row count or the highest rowid. Datastore tables are
+// summarized by their record count.
+func (b *TorontoBot) TableFingerprint(name string) (string, error) {
+	table, ok := b.tables[name]
+	if !ok {
+		return "", fmt.Errorf("unknown table %q", name)
+	}
+	if table.Live() {
+		return b.datastoreFingerprint(table)
+	}
+	var count, maxRowID int64
+	if err := b.db.QueryRow(
+		fmt.Sprintf("SELECT COUNT(*), COALESCE(MAX(rowid), 0) FROM %q", name),
+	).Scan(&count, &maxRowID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", count, maxRowID), nil
+}
+
+// LastRefreshed returns when data was last successfully ingested into a table, according to the
+// ingest_runs ledger, or the zero time if it never has been. It matches db.LastRefreshed, which the
+// bot can't call since the db package imports it.
+func (b *TorontoBot) LastRefreshed(name string) (time.Time, error) {
+	var finishedAt time.Time
+	err := b.db.QueryRow(`SELECT finished_at FROM ingest_runs
+		WHERE table_name = ? AND status = 'succeeded'
+		ORDER BY finished_at DESC LIMIT 1`, name).Scan(&finishedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return finishedAt, err
+}
+
+// RefreshedText describes when a table's data was last refreshed, e.g. "Data refreshed Oct 18,
+// 2026", or returns "" if it's not known.
+func RefreshedText(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return "Data refreshed " + t.Local().Format("Jan 2, 2006")
 }
 
 type ChartSelectResponse struct {
@@ -365,6 +544,42 @@ var ChartSelectFunction = openai.FunctionDefinition{
 	},
 }
 
+// UnsupportedChartError is returned when asked to render a chart type that can't be drawn yet.
+type UnsupportedChartError struct {
+	Chart string
+}
+
+func (e *UnsupportedChartError) Error() string {
+	return fmt.Sprintf("unsupported chart type %q", e.Chart)
+}
+
+// HTML renders the chart as a standalone HTML page, ready to be screenshotted. It supports the same
+// charts as JS.
+func (c *ChartSelectResponse) HTML(darkMode bool, options ...viz.ChartOption) (string, error) {
+	js, err := c.JS("body", options...)
+	if err != nil {
+		return "", err
+	}
+	return viz.ChartHTML(js, darkMode), nil
+}
+
+// JS renders the chart as a script that draws it into the element matching selector, for embedding
+// in a web page that loads d3.
+func (c *ChartSelectResponse) JS(selector string, options ...viz.ChartOption) (string, error) {
+	switch strings.ToLower(c.Chart) {
+	case "bar", "bar chart":
+		return viz.GenerateBarChartJS(selector, c.Title, c.Data, c.ValueIsCurrency, options...)
+	case "stacked-bar", "stacked bar chart":
+		return viz.GenerateStackedBarChartJS(selector, c.Title, c.Data, c.ValueIsCurrency, options...)
+	case "line", "line chart":
+		return viz.GenerateLineChartJS(selector, c.Title, c.Data, c.ValueIsCurrency, options...)
+	case "pie", "pie chart":
+		return viz.GeneratePieChartJS(selector, c.Title, c.Data, c.ValueIsCurrency, options...)
+	default:
+		return "", &UnsupportedChartError{Chart: c.Chart}
+	}
+}
+
 //  Potenial response for stacked bar chart:
 //  {
 //    "Chart": "stacked bar chart",
@@ -406,7 +621,7 @@ func (b *TorontoBot) SelectChart(ctx context.Context, question, dataTable string
 	}
 	log.Printf("sending request to openai: %q\n", query.String())
 	aiResp, err := b.ai.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: Model,
+		Model: b.Model(),
 		Messages: []openai.ChatCompletionMessage{{
 			Role:    openai.ChatMessageRoleUser,
 			Content: query.String(),
@@ -436,7 +651,7 @@ func (b *TorontoBot) SelectChart(ctx context.Context, question, dataTable string
 		return nil, fmt.Errorf("unmarshaling function call: %v", err)
 	}
 
-	fmt.Printf("%+v\n", resp)
+	log.Printf("Got chart selection: %+v\n", resp)
 
 	return &resp, nil
 }
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/geomodulus/torontobot/viz"
+)
+
+func TestChartSelectResponseHTML(t *testing.T) {
+	data := []*viz.DataEntry{
+		{Name: "TTC", Date: 2022, Value: 1},
+		{Name: "Police", Date: 2022, Value: 2},
+	}
+	for _, chart := range []string{"bar", "stacked-bar", "line", "pie"} {
+		t.Run(chart, func(t *testing.T) {
+			c := &ChartSelectResponse{Chart: chart, Title: "Budgets", Data: data}
+			html, err := c.HTML(true)
+			if err != nil {
+				t.Fatalf("HTML: %v", err)
+			}
+			js, err := c.JS("body")
+			if err != nil {
+				t.Fatalf("JS: %v", err)
+			}
+			if !strings.Contains(html, js) || !strings.Contains(html, `class="dark"`) {
+				t.Errorf("HTML doesn't embed the chart's script in a dark page")
+			}
+		})
+	}
+
+	c := &ChartSelectResponse{Chart: "scatter"}
+	_, err := c.HTML(false)
+	var unsupported *UnsupportedChartError
+	if !errors.As(err, &unsupported) || unsupported.Chart != "scatter" {
+		t.Errorf("HTML of a scatter chart returned %v, want UnsupportedChartError", err)
+	}
+}
@@ -0,0 +1,199 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geomodulus/torontobot/opendata"
+)
+
+const (
+	// DatastoreKind is the kind of table which isn't ingested locally, but queried live from a resource
+	// in the open data portal's datastore.
+	DatastoreKind = "datastore"
+
+	// datastoreTimeout limits how long a datastore query may take, including retries.
+	datastoreTimeout = 30 * time.Second
+	// maxCachedQueries is how many datastore query results are kept.
+	maxCachedQueries = 1000
+)
+
+// ErrNoDatastore is returned when querying a datastore table without a Datastore to query it from.
+var ErrNoDatastore = errors.New("no open data datastore is configured")
+
+// Datastore queries datastore tables through the open data portal's datastore_search_sql action,
+// caching results so repeated questions and subscriptions don't query the portal every time.
+type Datastore struct {
+	client *opendata.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*datastoreResult
+}
+
+type datastoreResult struct {
+	columns, types []string
+	rows           [][]interface{}
+	expires        time.Time
+}
+
+// NewDatastore returns a Datastore which queries the portal through client, caching results for ttl.
+func NewDatastore(client *opendata.Client, ttl time.Duration) *Datastore {
+	return &Datastore{
+		client: client,
+		ttl:    ttl,
+		cache:  map[string]*datastoreResult{},
+	}
+}
+
+// query runs a PostgreSQL query against the datastore, or returns its cached results. Like
+// reader.ReadRows, it returns sql.ErrNoRows when there are no results.
+func (d *Datastore) query(pgQuery string) ([]string, []string, [][]interface{}, error) {
+	now := time.Now()
+	d.mu.Lock()
+	cached, ok := d.cache[pgQuery]
+	d.mu.Unlock()
+	if !ok || now.After(cached.expires) {
+		ctx, cancel := context.WithTimeout(context.Background(), datastoreTimeout)
+		defer cancel()
+		result, err := d.client.DatastoreSearchSQL(ctx, pgQuery)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("datastore query: %w", err)
+		}
+		cached = newDatastoreResult(result, now.Add(d.ttl))
+
+		d.mu.Lock()
+		if len(d.cache) >= maxCachedQueries {
+			for q, c := range d.cache {
+				if now.After(c.expires) {
+					delete(d.cache, q)
+				}
+			}
+			if len(d.cache) >= maxCachedQueries {
+				d.cache = map[string]*datastoreResult{}
+			}
+		}
+		d.cache[pgQuery] = cached
+		d.mu.Unlock()
+	}
+	if len(cached.rows) == 0 {
+		return nil, nil, nil, sql.ErrNoRows
+	}
+	return cached.columns, cached.types, cached.rows, nil
+}
+
+// newDatastoreResult converts the records of a datastore result to rows of the values a local query
+// would return, in the order of its fields. The datastore's internal _id and _full_text fields are
+// left out.
+func newDatastoreResult(result *opendata.DatastoreResult, expires time.Time) *datastoreResult {
+	res := &datastoreResult{expires: expires}
+	var fields []opendata.DatastoreField
+	for _, field := range result.Fields {
+		if field.ID == "_id" || field.ID == "_full_text" {
+			continue
+		}
+		fields = append(fields, field)
+		res.columns = append(res.columns, field.ID)
+		res.types = append(res.types, columnType(field.Type))
+	}
+	for _, record := range result.Records {
+		row := make([]interface{}, len(fields))
+		for i, field := range fields {
+			row[i] = datastoreValue(record[field.ID], field.Type)
+		}
+		res.rows = append(res.rows, row)
+	}
+	return res
+}
+
+// columnType names a PostgreSQL type as SQLite would, e.g. "REAL" for "numeric".
+func columnType(pgType string) string {
+	switch pgType {
+	case "int2", "int4", "int8":
+		return "INTEGER"
+	case "float4", "float8", "numeric":
+		return "REAL"
+	case "text", "varchar", "bpchar":
+		return "TEXT"
+	}
+	return strings.ToUpper(pgType)
+}
+
+// datastoreValue converts a value decoded from a datastore record to the type a local query would
+// return it as. The datastore encodes numeric values as strings, and integers as JSON numbers.
+func datastoreValue(v interface{}, pgType string) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case float64:
+		if columnType(pgType) == "INTEGER" && v == math.Trunc(v) {
+			return int64(v)
+		}
+		return v
+	case string:
+		switch columnType(pgType) {
+		case "INTEGER":
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+		case "REAL":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
+
+// Live reports whether the table is queried live from the open data datastore rather than ingested
+// locally.
+func (t *DataTable) Live() bool {
+	return t.Kind == DatastoreKind
+}
+
+// datastoreQuery returns sqlQuery translated for the datastore if it reads from datastore tables,
+// or "" if it reads from local tables.
+func (b *TorontoBot) datastoreQuery(sqlQuery string) (string, error) {
+	pgQuery, err := translateQuery(sqlQuery, b.tables)
+	if err != nil {
+		return "", err
+	}
+	if pgQuery != "" && b.Datastore == nil {
+		return "", ErrNoDatastore
+	}
+	return pgQuery, nil
+}
+
+// datastoreFingerprint summarizes the current contents of a datastore table by its record count,
+// which changes when the portal loads new data. It isn't cached.
+func (b *TorontoBot) datastoreFingerprint(table *DataTable) (string, error) {
+	if b.Datastore == nil {
+		return "", ErrNoDatastore
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), datastoreTimeout)
+	defer cancel()
+	result, err := b.Datastore.client.DatastoreSearchSQL(ctx, "SELECT COUNT(*) AS count FROM "+quoteIdent(table.ResourceID))
+	if err != nil {
+		return "", fmt.Errorf("datastore query: %w", err)
+	}
+	if len(result.Records) == 0 {
+		return "", fmt.Errorf("no count returned for %s", table.Name)
+	}
+	return fmt.Sprint(datastoreValue(result.Records[0]["count"], "int8")), nil
+}
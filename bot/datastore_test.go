@@ -0,0 +1,127 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geomodulus/torontobot/opendata"
+)
+
+// testDatastore returns a bot which queries testTables, with a stand-in datastore answering
+// datastore_search_sql with result. It counts the queries it's sent, and records the last.
+func testDatastore(t *testing.T, result string) (*TorontoBot, *int32, *atomic.Value) {
+	t.Helper()
+	var queries int32
+	var last atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/3/action/datastore_search_sql" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&queries, 1)
+		last.Store(r.URL.Query().Get("sql"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": true, "result": ` + result + `}`))
+	}))
+	t.Cleanup(srv.Close)
+	client := opendata.NewClient(opendata.WithBaseURL(srv.URL), opendata.WithRetries(0, 0))
+	return &TorontoBot{tables: testTables, Datastore: NewDatastore(client, time.Minute)}, &queries, &last
+}
+
+func TestLoadResultsDatastore(t *testing.T) {
+	b, queries, last := testDatastore(t, `{
+		"fields": [
+			{"id": "_id", "type": "int4"},
+			{"id": "route", "type": "text"},
+			{"id": "delays", "type": "int8"},
+			{"id": "avg_delay", "type": "numeric"}
+		],
+		"records": [
+			{"_id": 1, "route": "504", "delays": 120, "avg_delay": "11.5"},
+			{"_id": 2, "route": "501", "delays": 98, "avg_delay": null}
+		]
+	}`)
+
+	query := "SELECT route, COUNT(*) AS delays, AVG(min_delay) AS avg_delay FROM delays WHERE location LIKE '%queen%' GROUP BY route"
+	got, err := b.LoadResults(query, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT route, COUNT(*) AS delays, AVG(min_delay) AS avg_delay FROM "res-1" AS "delays" WHERE location ILIKE '%queen%' GROUP BY route`
+	if sent, _ := last.Load().(string); sent != want {
+		t.Errorf("datastore was sent\n%s\nwant\n%s", sent, want)
+	}
+	for _, s := range []string{"ROUTE (TEXT)", "DELAYS (INTEGER)", "AVG_DELAY (REAL)", "504", "120", "11.50", "<no data found>"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("results don't contain %q:\n%s", s, got)
+		}
+	}
+	if strings.Contains(got, "_ID") {
+		t.Errorf("results contain the datastore's _id field:\n%s", got)
+	}
+
+	// The results are cached.
+	if _, err := b.LoadResults(query, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Errorf("datastore was queried %d times, want once", n)
+	}
+}
+
+func TestLoadResultsDatastoreEmpty(t *testing.T) {
+	b, _, _ := testDatastore(t, `{"fields": [{"id": "route", "type": "text"}], "records": []}`)
+	if _, err := b.LoadResults("SELECT route FROM delays", false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("LoadResults of no records returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestLoadResultsNoDatastore(t *testing.T) {
+	b := &TorontoBot{tables: testTables}
+	if _, err := b.LoadResults("SELECT * FROM delays", false); !errors.Is(err, ErrNoDatastore) {
+		t.Errorf("LoadResults without a datastore returned %v, want ErrNoDatastore", err)
+	}
+}
+
+func TestTableFingerprintDatastore(t *testing.T) {
+	b, _, last := testDatastore(t, `{"fields": [{"id": "count", "type": "int8"}], "records": [{"count": 4321}]}`)
+	got, err := b.TableFingerprint("delays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "4321" {
+		t.Errorf("TableFingerprint = %q, want 4321", got)
+	}
+	if sent, _ := last.Load().(string); sent != `SELECT COUNT(*) AS count FROM "res-1"` {
+		t.Errorf("datastore was sent %q", sent)
+	}
+}
+
+func TestLoadTablesDatastore(t *testing.T) {
+	tables, err := LoadTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var live int
+	for _, table := range tables {
+		if table.Live() {
+			live++
+			if table.ResourceID == "" {
+				t.Errorf("datastore table %s has no resource ID", table.Name)
+			}
+			// Quoted identifiers in queries are checked against the schema's columns.
+			if columns, err := table.columns(); err != nil || len(columns) == 0 {
+				t.Errorf("datastore table %s has columns %v (%v)", table.Name, columns, err)
+			}
+		}
+	}
+	if live == 0 {
+		t.Errorf("no datastore tables are configured")
+	}
+}
@@ -0,0 +1,509 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// identifier matches unquoted identifiers and keywords.
+	identifier = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	// likeOp matches LIKE, which is case-insensitive in SQLite but not in PostgreSQL.
+	likeOp = regexp.MustCompile(`(?i)\bLIKE\b`)
+	// castReal matches casts to REAL, which is single precision in PostgreSQL but double in SQLite.
+	castReal = regexp.MustCompile(`(?i)\bAS\s+REAL\b`)
+	// placeholder matches a literal masked out of a query by maskLiterals.
+	placeholder = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+// strftimeFormats maps the strftime substitutions which have a to_char equivalent.
+var strftimeFormats = map[byte]string{
+	'Y': "YYYY",
+	'm': "MM",
+	'd': "DD",
+	'H': "HH24",
+	'M': "MI",
+	'S': "SS",
+	'j': "DDD",
+	'%': "%",
+}
+
+// translateQuery rewrites a SQLite query which reads from datastore tables into the PostgreSQL
+// dialect of the datastore, where tables are named by their resource IDs. It returns "" if the query
+// doesn't read from any datastore table. Queries can't read from datastore and local tables at once.
+//
+// Only the differences that generated SQL runs into are translated: date functions, IFNULL,
+// GROUP_CONCAT, INSTR, two-argument ROUND, case-insensitive LIKE and casts to REAL.
+func translateQuery(sqlQuery string, tables map[string]*DataTable) (string, error) {
+	masked, literals, err := maskLiterals(sqlQuery)
+	if err != nil {
+		return "", err
+	}
+	quoted := len(literals)
+
+	// Find which tables are read, by their unquoted or quoted names in FROM and JOIN clauses. Datastore
+	// tables are renamed to their resource IDs, keeping their names as aliases so columns qualified by
+	// the table name still resolve.
+	var datastore, local *DataTable
+	refs := tableRefs(masked)
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		name := masked[ref.start:ref.end]
+		if m := placeholder.FindStringSubmatch(name); m != nil {
+			lit, _ := strconv.Atoi(m[1])
+			name = unquote(literals[lit])
+		}
+		table, ok := tables[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if !table.Live() {
+			local = table
+			continue
+		}
+		datastore = table
+		ident := quoteIdent(table.ResourceID)
+		if !ref.aliased {
+			ident += " AS " + quoteIdent(table.Name)
+		}
+		masked = masked[:ref.start] + fmt.Sprintf("\x00%d\x00", len(literals)) + masked[ref.end:]
+		literals = append(literals, ident)
+	}
+	if datastore == nil {
+		return "", nil
+	}
+	if local != nil {
+		return "", fmt.Errorf("%s is queried from the open data datastore, so can't be queried with %s", datastore.Name, local.Name)
+	}
+	if err := checkQuotedIdents(masked, literals[:quoted], datastore); err != nil {
+		return "", err
+	}
+
+	masked = likeOp.ReplaceAllString(masked, "ILIKE")
+	masked = castReal.ReplaceAllString(masked, "AS double precision")
+	if masked, err = translateCalls(masked, literals); err != nil {
+		return "", err
+	}
+	return placeholder.ReplaceAllStringFunc(masked, func(p string) string {
+		i, _ := strconv.Atoi(strings.Trim(p, "\x00"))
+		return literals[i]
+	}), nil
+}
+
+// maskLiterals replaces the string literals and quoted identifiers of a query with numbered
+// placeholders, returning them separately, and drops comments, so the rest can be rewritten without
+// touching them.
+func maskLiterals(sqlQuery string) (string, []string, error) {
+	var (
+		out      strings.Builder
+		literals []string
+	)
+	for i := 0; i < len(sqlQuery); i++ {
+		c := sqlQuery[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := -1
+			for j := i + 1; j < len(sqlQuery); j++ {
+				if sqlQuery[j] == c {
+					if j+1 < len(sqlQuery) && sqlQuery[j+1] == c {
+						j++
+						continue
+					}
+					end = j
+					break
+				}
+			}
+			if end == -1 {
+				return "", nil, fmt.Errorf("unterminated quote in query")
+			}
+			fmt.Fprintf(&out, "\x00%d\x00", len(literals))
+			literals = append(literals, sqlQuery[i:end+1])
+			i = end
+		case c == '-' && i+1 < len(sqlQuery) && sqlQuery[i+1] == '-':
+			end := strings.IndexByte(sqlQuery[i:], '\n')
+			if end == -1 {
+				i = len(sqlQuery)
+			} else {
+				i += end
+			}
+			out.WriteByte(' ')
+		case c == '/' && i+1 < len(sqlQuery) && sqlQuery[i+1] == '*':
+			end := strings.Index(sqlQuery[i+2:], "*/")
+			if end == -1 {
+				return "", nil, fmt.Errorf("unterminated comment in query")
+			}
+			i += end + 3
+			out.WriteByte(' ')
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), literals, nil
+}
+
+var (
+	// aliasIdent matches a quoted identifier defined as an alias with AS.
+	aliasIdent = regexp.MustCompile("(?i)\\bAS\\s+\x00([0-9]+)\x00")
+	// cteIdent matches a quoted identifier naming a common table expression, with any list of its
+	// columns.
+	cteIdent = regexp.MustCompile("\x00([0-9]+)\x00\\s*(\\([^()]*\\))?\\s*(?i:AS)\\s*\\(")
+	// tableAlias matches a quoted identifier aliasing a table without AS, following a placeholder
+	// for the table.
+	tableAlias = regexp.MustCompile("\x00([0-9]+)\x00\\s+\x00([0-9]+)\x00")
+)
+
+// checkQuotedIdents checks that the quoted identifiers of a masked query, among the first literals,
+// are columns of the datastore table or names the query defines, rather than leaving the datastore
+// to reject them. SQLite matches identifiers case-insensitively, so they're quoted as the columns
+// are named, and backquotes, which PostgreSQL doesn't accept, are replaced.
+func checkQuotedIdents(masked string, literals []string, table *DataTable) error {
+	columns, err := table.columns()
+	if err != nil {
+		return err
+	}
+	defined := map[string]bool{strings.ToLower(table.Name): true}
+	define := func(placeholder string) {
+		if i, _ := strconv.Atoi(placeholder); i < len(literals) {
+			defined[strings.ToLower(unquote(literals[i]))] = true
+		}
+	}
+	for _, m := range aliasIdent.FindAllStringSubmatch(masked, -1) {
+		define(m[1])
+	}
+	for _, m := range cteIdent.FindAllStringSubmatch(masked, -1) {
+		define(m[1])
+		for _, c := range placeholder.FindAllStringSubmatch(m[2], -1) {
+			define(c[1])
+		}
+	}
+	for _, m := range tableAlias.FindAllStringSubmatch(masked, -1) {
+		// The table is a placeholder added for its resource ID, after the query's own literals.
+		if i, _ := strconv.Atoi(m[1]); i >= len(literals) {
+			define(m[2])
+		}
+	}
+
+	for _, m := range placeholder.FindAllStringSubmatch(masked, -1) {
+		i, _ := strconv.Atoi(m[1])
+		if i >= len(literals) || literals[i][0] == '\'' {
+			continue
+		}
+		name := unquote(literals[i])
+		if column, ok := columns[strings.ToLower(name)]; ok {
+			literals[i] = quoteIdent(column)
+			continue
+		}
+		if !defined[strings.ToLower(name)] {
+			return fmt.Errorf("%s has no column %q", table.Name, name)
+		}
+		literals[i] = quoteIdent(name)
+	}
+	return nil
+}
+
+// columnConstraints are the keywords which start table constraints rather than columns in a
+// CREATE TABLE statement.
+var columnConstraints = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true,
+}
+
+// columns returns the names of the columns of the table's schema, by their lowercase names.
+func (t *DataTable) columns() (map[string]string, error) {
+	masked, literals, err := maskLiterals(t.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema of %s: %v", t.Name, err)
+	}
+	start := strings.IndexByte(masked, '(')
+	if start == -1 {
+		return nil, fmt.Errorf("parsing schema of %s: no columns", t.Name)
+	}
+	defs, _, err := splitArgs(masked[start:])
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema of %s: %v", t.Name, err)
+	}
+	columns := map[string]string{}
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 || columnConstraints[strings.ToUpper(fields[0])] {
+			continue
+		}
+		name := fields[0]
+		if m := placeholder.FindStringSubmatch(name); m != nil {
+			i, _ := strconv.Atoi(m[1])
+			name = unquote(literals[i])
+		}
+		columns[strings.ToLower(name)] = name
+	}
+	return columns, nil
+}
+
+// unquote returns the value of a quoted literal or identifier.
+func unquote(lit string) string {
+	q := lit[:1]
+	return strings.ReplaceAll(lit[1:len(lit)-1], q+q, q)
+}
+
+// tableRef is where a table is named in a masked query.
+type tableRef struct {
+	start, end int
+	// aliased is whether the table is given an alias.
+	aliased bool
+}
+
+// fromEnd are the keywords which end the comma-separated list of tables in a FROM clause.
+var fromEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "WINDOW": true,
+	"UNION": true, "EXCEPT": true, "INTERSECT": true, "ON": true, "USING": true,
+}
+
+// joinKeywords are the keywords which can follow a table in place of an alias.
+var joinKeywords = map[string]bool{
+	"JOIN": true, "LEFT": true, "RIGHT": true, "FULL": true, "INNER": true, "OUTER": true,
+	"CROSS": true, "NATURAL": true, "INDEXED": true, "NOT": true,
+}
+
+// tableToken matches the tokens of a masked query which tableRefs reads: identifiers, placeholders
+// and punctuation.
+var tableToken = regexp.MustCompile("[A-Za-z_][A-Za-z0-9_]*|\x00[0-9]+\x00|[(),.]")
+
+// tableRefs finds the tables named in the FROM and JOIN clauses of a masked query, including those
+// of subqueries. Other identifiers, such as columns and aliases, are left out even when they share
+// a table's name.
+func tableRefs(masked string) []tableRef {
+	tokens := tableToken.FindAllStringIndex(masked, -1)
+	token := func(i int) string {
+		if i >= len(tokens) {
+			return ""
+		}
+		return masked[tokens[i][0]:tokens[i][1]]
+	}
+	var (
+		refs []tableRef
+		// from records, for each level of parentheses, whether it's in a FROM clause.
+		from = []bool{false}
+		// expect is whether the next token names a table.
+		expect bool
+	)
+	for i, loc := range tokens {
+		tok := token(i)
+		upper := strings.ToUpper(tok)
+		switch {
+		case tok == "(":
+			from = append(from, false)
+			expect = false
+		case tok == ")":
+			if len(from) > 1 {
+				from = from[:len(from)-1]
+			}
+			expect = false
+		case tok == ",":
+			expect = from[len(from)-1]
+		case tok == ".":
+		case upper == "FROM" || upper == "JOIN":
+			from[len(from)-1] = true
+			expect = true
+		case fromEnd[upper]:
+			from[len(from)-1] = false
+			expect = false
+		case expect:
+			expect = false
+			switch token(i + 1) {
+			case ".":
+				// A schema name, followed by the table's.
+				expect = true
+				continue
+			case "(":
+				// A table-valued function.
+				continue
+			}
+			next := strings.ToUpper(token(i + 1))
+			aliased := next != "" && !strings.Contains("(),.", next) && !fromEnd[next] && !joinKeywords[next]
+			refs = append(refs, tableRef{start: loc[0], end: loc[1], aliased: aliased})
+		}
+	}
+	return refs
+}
+
+// translateCalls rewrites calls to SQLite functions which PostgreSQL lacks or treats differently,
+// including calls nested in their arguments.
+func translateCalls(masked string, literals []string) (string, error) {
+	var out strings.Builder
+	for {
+		loc := identifier.FindStringIndex(masked)
+		if loc == nil {
+			out.WriteString(masked)
+			return out.String(), nil
+		}
+		name := masked[loc[0]:loc[1]]
+		rest := strings.TrimLeft(masked[loc[1]:], " \t\r\n")
+		if !strings.HasPrefix(rest, "(") {
+			out.WriteString(masked[:loc[1]])
+			masked = masked[loc[1]:]
+			continue
+		}
+		args, n, err := splitArgs(rest)
+		if err != nil {
+			return "", err
+		}
+		for i := range args {
+			if args[i], err = translateCalls(args[i], literals); err != nil {
+				return "", err
+			}
+		}
+		call, err := translateCall(name, args, literals)
+		if err != nil {
+			return "", err
+		}
+		// Keywords such as FROM and AS are followed by parentheses too, so keep the space after them.
+		if gap := masked[loc[1] : len(masked)-len(rest)]; strings.HasPrefix(call, name+"(") {
+			call = name + gap + call[len(name):]
+		}
+		out.WriteString(masked[:loc[0]])
+		out.WriteString(call)
+		masked = rest[n:]
+	}
+}
+
+// splitArgs splits the parenthesized, comma-separated arguments at the start of s, returning them
+// trimmed along with the length of s they took up.
+func splitArgs(s string) ([]string, int, error) {
+	var (
+		args  []string
+		depth int
+		start = 1
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				if arg := strings.TrimSpace(s[start:i]); arg != "" || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, i + 1, nil
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("unbalanced parentheses in query")
+}
+
+// translateCall returns the PostgreSQL equivalent of a call to a function with translated args.
+func translateCall(name string, args, literals []string) (string, error) {
+	call := func(fn string, args ...string) string {
+		return fn + "(" + strings.Join(args, ", ") + ")"
+	}
+	switch strings.ToLower(name) {
+	case "strftime":
+		if len(args) != 2 {
+			return "", fmt.Errorf("strftime with modifiers can't be queried from the datastore")
+		}
+		format, ok := literal(args[0], literals)
+		if !ok {
+			return "", fmt.Errorf("strftime needs a literal format to be queried from the datastore")
+		}
+		pgFormat, err := toCharFormat(format)
+		if err != nil {
+			return "", err
+		}
+		return call("to_char", timeArg(args[1], "timestamp", literals), quoteString(pgFormat)), nil
+	case "date", "datetime":
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s with modifiers can't be queried from the datastore", name)
+		}
+		typ := "date"
+		if strings.EqualFold(name, "datetime") {
+			typ = "timestamp"
+		}
+		return timeArg(args[0], typ, literals), nil
+	case "ifnull":
+		return call("COALESCE", args...), nil
+	case "instr":
+		return call("strpos", args...), nil
+	case "group_concat":
+		if len(args) == 0 || len(args) > 2 {
+			return "", fmt.Errorf("group_concat takes one or two arguments")
+		}
+		sep := "','"
+		if len(args) == 2 {
+			sep = args[1]
+		}
+		arg, distinct := args[0], ""
+		if fields := strings.Fields(arg); len(fields) > 1 && strings.EqualFold(fields[0], "DISTINCT") {
+			arg, distinct = strings.TrimSpace(arg[len(fields[0]):]), "DISTINCT "
+		}
+		return call("string_agg", distinct+"CAST("+arg+" AS text)", sep), nil
+	case "round":
+		// PostgreSQL only rounds numeric values to a number of decimal places.
+		if len(args) == 2 {
+			return call(name, "CAST("+args[0]+" AS numeric)", args[1]), nil
+		}
+	}
+	return call(name, args...), nil
+}
+
+// literal returns the value of arg if it's a string literal.
+func literal(arg string, literals []string) (string, bool) {
+	m := placeholder.FindStringSubmatch(arg)
+	if m == nil || m[0] != arg {
+		return "", false
+	}
+	i, _ := strconv.Atoi(m[1])
+	if literals[i][0] != '\'' {
+		return "", false
+	}
+	return unquote(literals[i]), true
+}
+
+// timeArg casts arg to a date or timestamp, translating SQLite's 'now'.
+func timeArg(arg, typ string, literals []string) string {
+	if v, ok := literal(arg, literals); ok && strings.EqualFold(v, "now") {
+		if typ == "date" {
+			return "CURRENT_DATE"
+		}
+		return "CURRENT_TIMESTAMP"
+	}
+	return "CAST(" + arg + " AS " + typ + ")"
+}
+
+// toCharFormat translates a strftime format into a to_char one, quoting any other letters so
+// to_char doesn't take them for patterns.
+func toCharFormat(format string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '%':
+			if i+1 == len(format) {
+				return "", fmt.Errorf("incomplete strftime format %q", format)
+			}
+			i++
+			pattern, ok := strftimeFormats[format[i]]
+			if !ok {
+				return "", fmt.Errorf("strftime format %%%c can't be queried from the datastore", format[i])
+			}
+			out.WriteString(pattern)
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			out.WriteString(`"` + string(c) + `"`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"testing"
+)
+
+// testTables are a datastore table of delays and a local table of service requests.
+var testTables = map[string]*DataTable{
+	"delays": {
+		Name:       "delays",
+		Kind:       DatastoreKind,
+		ResourceID: "res-1",
+		Schema: `CREATE TABLE delays (date DATE, route TEXT, line TEXT, location TEXT, incident TEXT,
+			min_delay INTEGER, "Min Gap" INTEGER CHECK ("Min Gap" >= 0), PRIMARY KEY (date, route))`,
+	},
+	"service_requests": {Name: "service_requests"},
+}
+
+func TestTranslateQuery(t *testing.T) {
+	for _, test := range []struct {
+		name, query, want string
+	}{{
+		name:  "local",
+		query: "SELECT * FROM service_requests",
+		want:  "",
+	}, {
+		name:  "table",
+		query: "SELECT * FROM delays",
+		want:  `SELECT * FROM "res-1" AS "delays"`,
+	}, {
+		name:  "quoted table",
+		query: "SELECT * FROM `delays` WHERE `route` = 504",
+		want:  `SELECT * FROM "res-1" AS "delays" WHERE "route" = 504`,
+	}, {
+		name:  "alias",
+		query: "SELECT d.route FROM delays d JOIN delays AS e ON d.route = e.route",
+		want:  `SELECT d.route FROM "res-1" d JOIN "res-1" AS e ON d.route = e.route`,
+	}, {
+		name:  "qualified columns",
+		query: "SELECT delays.route FROM delays WHERE delays.min_delay > 10",
+		want:  `SELECT delays.route FROM "res-1" AS "delays" WHERE delays.min_delay > 10`,
+	}, {
+		name:  "columns and aliases named like tables",
+		query: "SELECT delays, service_requests, COUNT(*) AS delays FROM delays GROUP BY delays ORDER BY delays",
+		want:  `SELECT delays, service_requests, COUNT(*) AS delays FROM "res-1" AS "delays" GROUP BY delays ORDER BY delays`,
+	}, {
+		name:  "table name in a string",
+		query: "SELECT * FROM delays WHERE incident = 'delays' -- FROM service_requests",
+		want:  `SELECT * FROM "res-1" AS "delays" WHERE incident = 'delays'  `,
+	}, {
+		name:  "comma list",
+		query: "SELECT * FROM delays a, delays WHERE a.route = delays.route",
+		want:  `SELECT * FROM "res-1" a, "res-1" AS "delays" WHERE a.route = delays.route`,
+	}, {
+		name:  "subquery",
+		query: "SELECT route FROM (SELECT route, COUNT(*) AS n FROM delays GROUP BY route) WHERE n > (SELECT AVG(min_delay) FROM delays)",
+		want:  `SELECT route FROM (SELECT route, COUNT(*) AS n FROM "res-1" AS "delays" GROUP BY route) WHERE n > (SELECT AVG(min_delay) FROM "res-1" AS "delays")`,
+	}, {
+		name:  "cte",
+		query: "WITH d AS (SELECT * FROM delays) SELECT COUNT(*) FROM d",
+		want:  `WITH d AS (SELECT * FROM "res-1" AS "delays") SELECT COUNT(*) FROM d`,
+	}, {
+		name:  "functions",
+		query: "SELECT strftime('%Y-%m', date) AS month, IFNULL(line, 'none'), ROUND(AVG(min_delay), 2), CAST(n AS REAL) FROM delays WHERE location LIKE '%queen%' GROUP BY month",
+		want:  `SELECT to_char(CAST(date AS timestamp), 'YYYY-MM') AS month, COALESCE(line, 'none'), ROUND(CAST(AVG(min_delay) AS numeric), 2), CAST(n AS double precision) FROM "res-1" AS "delays" WHERE location ILIKE '%queen%' GROUP BY month`,
+	}, {
+		name:  "quoted columns",
+		query: "SELECT \"Min Gap\", `min gap`, \"ROUTE\" FROM delays WHERE delays.\"Location\" = 'x'",
+		want:  `SELECT "Min Gap", "Min Gap", "route" FROM "res-1" AS "delays" WHERE delays."location" = 'x'`,
+	}, {
+		name:  "quoted names defined by the query",
+		query: "WITH \"By Route\"(\"r\", \"n\") AS (SELECT route, COUNT(*) FROM delays GROUP BY route) SELECT b.\"r\", \"n\" AS \"Delays\" FROM \"By Route\" b ORDER BY \"Delays\"",
+		want:  `WITH "By Route"("r", "n") AS (SELECT route, COUNT(*) FROM "res-1" AS "delays" GROUP BY route) SELECT b."r", "n" AS "Delays" FROM "By Route" b ORDER BY "Delays"`,
+	}, {
+		name:  "quoted table aliases",
+		query: "SELECT \"d\".route, \"delays\".line FROM delays \"d\", delays AS `e`",
+		want:  `SELECT "d".route, "delays".line FROM "res-1" "d", "res-1" AS "e"`,
+	}, {
+		name:  "group_concat",
+		query: "SELECT GROUP_CONCAT(DISTINCT route) FROM delays WHERE date(date) = date('now')",
+		want:  `SELECT string_agg(DISTINCT CAST(route AS text), ',') FROM "res-1" AS "delays" WHERE CAST(date AS date) = CURRENT_DATE`,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := translateQuery(test.query, testTables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("translateQuery(%q) =\n%s\nwant\n%s", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTranslateQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"SELECT * FROM delays JOIN service_requests ON delays.ward = service_requests.ward",
+		"SELECT * FROM delays, service_requests",
+		"SELECT * FROM delays WHERE route IN (SELECT ward FROM service_requests)",
+		"SELECT strftime('%Y', date, 'start of month') FROM delays",
+		"SELECT strftime('%W', date) FROM delays",
+		"SELECT strftime(format, date) FROM delays",
+		"SELECT * FROM delays WHERE route = 'unterminated",
+		`SELECT "nope" FROM delays`,
+		"SELECT route FROM delays WHERE `_id` > 10",
+		`SELECT route AS "n" FROM delays ORDER BY "m"`,
+	} {
+		if got, err := translateQuery(query, testTables); err == nil {
+			t.Errorf("translateQuery(%q) = %q, want an error", query, got)
+		}
+	}
+}
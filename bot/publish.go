@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/geomodulus/citygraph"
+
+	"github.com/geomodulus/torontobot/viz"
+)
+
+// Publication is an answer published to the web as a module with an interactive chart.
+type Publication struct {
+	ModuleID string
+	URL      string
+}
+
+// Publish charts an answer and saves it to the graph as a module on the web, credited to author. It
+// returns an *UnsupportedChartError if the chart selected for the answer can't be published yet.
+func (b *TorontoBot) Publish(ctx context.Context, question string, sqlResponse *SQLResponse, results, author string) (*Publication, error) {
+	chart, err := b.SelectChart(ctx, question, results)
+	if err != nil {
+		return nil, fmt.Errorf("selecting chart: %w", err)
+	}
+
+	var (
+		js, chartHTML string
+		options       = []viz.ChartOption{viz.WithFixedWidth(800), viz.WithFixedHeight(750)}
+	)
+	switch strings.ToLower(chart.Chart) {
+	case "bar", "bar chart", "pie", "pie chart":
+		if js, err = chart.JS("#torontobot-chart", viz.WithBreakpointWidth()); err != nil {
+			return nil, fmt.Errorf("generating JS: %w", err)
+		}
+		if chartHTML, err = chart.HTML(true, options...); err != nil {
+			return nil, fmt.Errorf("generating HTML: %w", err)
+		}
+	default:
+		return nil, &UnsupportedChartError{Chart: chart.Chart}
+	}
+
+	id := citygraph.NewID().String()
+	featureImageURL, err := viz.GenerateAndUploadFeatureImage(
+		ctx,
+		id,
+		chart.Title,
+		chartHTML,
+		chart.Data,
+		chart.ValueIsCurrency,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("generating feature image: %w", err)
+	}
+
+	modPath, err := b.SaveToGraph(
+		ctx,
+		id,
+		question,
+		viz.RenderBody(question, sqlResponse.Schema, sqlResponse.Applicability, sqlResponse.SQL),
+		js,
+		featureImageURL,
+		author)
+	if err != nil {
+		return nil, fmt.Errorf("saving to graph: %w", err)
+	}
+	return &Publication{ModuleID: id, URL: b.Hostname + modPath}, nil
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many keys the rate limiter tracks before forgetting those that have fully
+// refilled.
+const maxIdleBuckets = 10000
+
+// RateLimiter allows each key, such as a user, a number of requests per interval. Allowance refills
+// continuously, so a user who has used it all up may ask again after a fraction of the interval.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limit   float64
+	per     time.Duration
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns a limiter allowing limit requests per interval for each key.
+func NewRateLimiter(limit int, per time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   float64(limit),
+		per:     per,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow reports whether key may make a request now, counting it if so.
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.forgetFull(now)
+		}
+		b = &bucket{tokens: l.limit, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * l.limit / l.per.Seconds()
+	if b.tokens > l.limit {
+		b.tokens = l.limit
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// forgetFull drops keys whose allowance has refilled, which behave the same as unseen keys.
+func (l *RateLimiter) forgetFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.limit/l.per.Seconds() >= l.limit {
+			delete(l.buckets, key)
+		}
+	}
+}
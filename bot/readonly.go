@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/geomodulus/torontobot/db/reader"
+)
+
+// ErrNotReadOnly is returned when a query would do anything other than read from the database.
+var ErrNotReadOnly = errors.New("only read-only SELECT queries are allowed")
+
+// writeKeywords are statements which modify the database or its connection, none of which are
+// allowed anywhere in a query, including inside a CTE.
+var writeKeywords = map[string]bool{
+	"ALTER":    true,
+	"ANALYZE":  true,
+	"ATTACH":   true,
+	"CREATE":   true,
+	"DELETE":   true,
+	"DETACH":   true,
+	"DROP":     true,
+	"INSERT":   true,
+	"PRAGMA":   true,
+	"REINDEX":  true,
+	"REPLACE":  true,
+	"TRUNCATE": true,
+	"UPDATE":   true,
+	"VACUUM":   true,
+}
+
+// sqliteRecursive is the authorizer action for a recursive CTE, which go-sqlite3 doesn't define.
+const sqliteRecursive = 33
+
+// replaceFunc matches calls to the REPLACE() string function, which shares its name with the
+// REPLACE statement.
+var replaceFunc = regexp.MustCompile(`\bREPLACE\s*\(`)
+
+// ValidateReadOnly checks that sqlQuery is a single SELECT statement, optionally introduced by a
+// WITH clause. Both generated and user-edited SQL go through this check before being run.
+func ValidateReadOnly(sqlQuery string) error {
+	stripped, err := stripLiterals(sqlQuery)
+	if err != nil {
+		return err
+	}
+	stripped = strings.TrimSpace(stripped)
+	stripped = strings.TrimSpace(strings.TrimSuffix(stripped, ";"))
+	if stripped == "" {
+		return fmt.Errorf("empty query")
+	}
+	if strings.Contains(stripped, ";") {
+		return fmt.Errorf("%w: multiple statements found", ErrNotReadOnly)
+	}
+
+	upper := replaceFunc.ReplaceAllString(strings.ToUpper(stripped), "(")
+	words := strings.FieldsFunc(upper, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if len(words) == 0 || (words[0] != "SELECT" && words[0] != "WITH") {
+		return fmt.Errorf("%w: query must start with SELECT or WITH", ErrNotReadOnly)
+	}
+	for _, word := range words {
+		if writeKeywords[word] {
+			return fmt.Errorf("%w: %s is not permitted", ErrNotReadOnly, word)
+		}
+	}
+	return nil
+}
+
+// ReadTables runs fn with a connection to db on which queries may only read from the given tables.
+// SQLite checks each statement against them as it's prepared, so reading any other table, even from a
+// subquery or CTE or sqlite_master, fails with ErrNotReadOnly, as do writes, PRAGMA and ATTACH. The
+// connection is closed afterwards rather than returned to db's pool.
+func ReadTables(db *sql.DB, tables []string, fn func(q reader.Querier) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	allowed := map[string]bool{}
+	for _, table := range tables {
+		allowed[strings.ToLower(table)] = true
+	}
+	var denied error
+	authorize := func(action int, arg1, arg2, arg3 string) int {
+		switch action {
+		case sqlite3.SQLITE_SELECT, sqlite3.SQLITE_FUNCTION, sqliteRecursive:
+			return sqlite3.SQLITE_OK
+		case sqlite3.SQLITE_READ:
+			if allowed[strings.ToLower(arg1)] {
+				return sqlite3.SQLITE_OK
+			}
+			denied = fmt.Errorf("%w: %s is not a dataset table", ErrNotReadOnly, arg1)
+		case sqlite3.SQLITE_PRAGMA:
+			denied = fmt.Errorf("%w: PRAGMA is not permitted", ErrNotReadOnly)
+		case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+			denied = fmt.Errorf("%w: ATTACH is not permitted", ErrNotReadOnly)
+		default:
+			denied = ErrNotReadOnly
+		}
+		return sqlite3.SQLITE_DENY
+	}
+	if err := conn.Raw(func(dc interface{}) error {
+		c, ok := dc.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("restricting tables needs a SQLite database, not %T", dc)
+		}
+		c.RegisterAuthorizer(authorize)
+		return nil
+	}); err != nil {
+		return err
+	}
+	// Discard the connection, rather than leave a pooled one restricted.
+	defer conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+
+	err = fn(conn)
+	if denied != nil {
+		return denied
+	}
+	return err
+}
+
+// readTables runs fn with a connection which may only read from the bot's local dataset tables.
+func (b *TorontoBot) readTables(fn func(q reader.Querier) error) error {
+	var names []string
+	for name, table := range b.tables {
+		if !table.Live() {
+			names = append(names, name)
+		}
+	}
+	return ReadTables(b.db, names, fn)
+}
+
+// stripLiterals blanks out string literals, quoted identifiers and comments so that keywords inside
+// them are not mistaken for SQL.
+func stripLiterals(sqlQuery string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(sqlQuery); i++ {
+		c := sqlQuery[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// Quoted values and identifiers end at the next unescaped (undoubled) matching quote.
+			end := -1
+			for j := i + 1; j < len(sqlQuery); j++ {
+				if sqlQuery[j] == c {
+					if j+1 < len(sqlQuery) && sqlQuery[j+1] == c {
+						j++
+						continue
+					}
+					end = j
+					break
+				}
+			}
+			if end == -1 {
+				return "", fmt.Errorf("unterminated quote in query")
+			}
+			out.WriteString(" _ ")
+			i = end
+		case c == '-' && i+1 < len(sqlQuery) && sqlQuery[i+1] == '-':
+			end := strings.IndexByte(sqlQuery[i:], '\n')
+			if end == -1 {
+				i = len(sqlQuery)
+			} else {
+				i += end
+			}
+			out.WriteByte(' ')
+		case c == '/' && i+1 < len(sqlQuery) && sqlQuery[i+1] == '*':
+			end := strings.Index(sqlQuery[i+2:], "*/")
+			if end == -1 {
+				return "", fmt.Errorf("unterminated comment in query")
+			}
+			i += end + 3
+			out.WriteByte(' ')
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
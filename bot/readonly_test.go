@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/geomodulus/torontobot/db/reader"
+)
+
+func TestValidateReadOnly(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		query string
+		// notReadOnly is whether the query should fail with ErrNotReadOnly, rather than be allowed.
+		notReadOnly bool
+		// invalid is whether the query should fail for some other reason.
+		invalid bool
+	}{
+		{name: "select", query: "SELECT ward, COUNT(*) FROM service_requests GROUP BY ward"},
+		{name: "trailing semicolon", query: "SELECT 1;"},
+		{name: "lowercase", query: "select 1"},
+		{name: "cte", query: "WITH w AS (SELECT ward FROM service_requests) SELECT * FROM w"},
+		{name: "keyword in string", query: "SELECT * FROM service_requests WHERE status = 'DELETE; DROP TABLE x'"},
+		{name: "escaped quote in string", query: "SELECT * FROM operating_budget WHERE program = 'Children''s Services; DROP'"},
+		{name: "keyword in quoted identifier", query: `SELECT "update", ` + "`insert`" + ` FROM t`},
+		{name: "keyword in line comment", query: "SELECT 1 -- DROP TABLE user_queries; \n"},
+		{name: "keyword in block comment", query: "SELECT /* DELETE FROM t; */ 1"},
+		{name: "replace function", query: "SELECT REPLACE(ward, '-', ' ') FROM service_requests"},
+		{name: "insert", query: "INSERT INTO t VALUES (1)", notReadOnly: true},
+		{name: "update", query: "UPDATE t SET x = 1", notReadOnly: true},
+		{name: "replace statement", query: "REPLACE INTO t VALUES (1)", notReadOnly: true},
+		{name: "cte delete", query: "WITH x AS (SELECT 1) DELETE FROM t", notReadOnly: true},
+		{name: "cte insert", query: "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", notReadOnly: true},
+		{name: "cte update", query: "WITH x AS (SELECT 1) UPDATE t SET y = 1", notReadOnly: true},
+		{name: "pragma", query: "PRAGMA table_info(user_queries)", notReadOnly: true},
+		{name: "pragma after select", query: "SELECT 1 FROM t WHERE 0 UNION SELECT 1 FROM PRAGMA", notReadOnly: true},
+		{name: "attach", query: "ATTACH DATABASE 'other.db' AS other", notReadOnly: true},
+		{name: "select attach", query: "SELECT 1 FROM t; ATTACH 'x' AS y", notReadOnly: true},
+		{name: "multiple selects", query: "SELECT 1; SELECT 2", notReadOnly: true},
+		{name: "statement after comment", query: "SELECT 1 /* ; */; DROP TABLE t", notReadOnly: true},
+		{name: "comment hiding start", query: "/* SELECT */ DELETE FROM t", notReadOnly: true},
+		{name: "vacuum into", query: "VACUUM INTO '/tmp/copy.db'", notReadOnly: true},
+		{name: "empty", query: "  ; ", invalid: true},
+		{name: "only comment", query: "-- SELECT 1", invalid: true},
+		{name: "unterminated string", query: "SELECT 'oops", invalid: true},
+		{name: "unterminated comment", query: "SELECT 1 /* DROP", invalid: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateReadOnly(test.query)
+			switch {
+			case test.notReadOnly && !errors.Is(err, ErrNotReadOnly):
+				t.Errorf("ValidateReadOnly(%q) = %v, want ErrNotReadOnly", test.query, err)
+			case test.invalid && (err == nil || errors.Is(err, ErrNotReadOnly)):
+				t.Errorf("ValidateReadOnly(%q) = %v, want an invalid query error", test.query, err)
+			case !test.notReadOnly && !test.invalid && err != nil:
+				t.Errorf("ValidateReadOnly(%q) = %v, want nil", test.query, err)
+			}
+		})
+	}
+}
+
+func TestReadTables(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE service_requests (ward TEXT, year INTEGER)",
+		"INSERT INTO service_requests VALUES ('Beaches-East York (19)', 2022), ('Spadina-Fort York (10)', 2023)",
+		"CREATE TABLE user_queries (question TEXT, results TEXT)",
+		"INSERT INTO user_queries VALUES ('secret', 'results')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		query string
+		// rows is how many rows the query should return, or -1 if it should fail with ErrNotReadOnly.
+		rows int
+	}{
+		{name: "select", query: "SELECT * FROM service_requests", rows: 2},
+		{name: "count", query: "SELECT COUNT(*) FROM service_requests", rows: 1},
+		{name: "case insensitive", query: "SELECT ward FROM SERVICE_REQUESTS WHERE year = 2023", rows: 1},
+		{name: "recursive cte", query: "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5) SELECT * FROM n", rows: 5},
+		{name: "functions", query: "SELECT UPPER(ward), strftime('%Y', 'now') FROM service_requests", rows: 2},
+		{name: "other table", query: "SELECT * FROM user_queries", rows: -1},
+		{name: "other table count", query: "SELECT COUNT(*) FROM user_queries", rows: -1},
+		{name: "other table in subquery", query: "SELECT ward FROM service_requests WHERE ward IN (SELECT question FROM user_queries)", rows: -1},
+		{name: "other table in cte", query: "WITH q AS (SELECT * FROM user_queries) SELECT * FROM q", rows: -1},
+		{name: "other table in join", query: "SELECT * FROM service_requests, user_queries", rows: -1},
+		{name: "other table quoted as string", query: "SELECT * FROM 'user_queries'", rows: -1},
+		{name: "schema", query: "SELECT sql FROM sqlite_master", rows: -1},
+		{name: "pragma function", query: "SELECT * FROM pragma_table_info('user_queries')", rows: -1},
+		{name: "pragma", query: "PRAGMA table_info(user_queries)", rows: -1},
+		{name: "attach", query: "ATTACH DATABASE ':memory:' AS other", rows: -1},
+		{name: "insert", query: "INSERT INTO service_requests VALUES ('x', 2024)", rows: -1},
+		{name: "delete", query: "DELETE FROM service_requests", rows: -1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var rows [][]interface{}
+			err := ReadTables(db, []string{"service_requests"}, func(q reader.Querier) error {
+				var err error
+				_, rows, err = reader.ReadRows(q, test.query)
+				return err
+			})
+			if test.rows == -1 {
+				if !errors.Is(err, ErrNotReadOnly) {
+					t.Errorf("%s returned %v, want ErrNotReadOnly", test.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", test.query, err)
+			}
+			if len(rows) != test.rows {
+				t.Errorf("%s returned %d rows, want %d", test.query, len(rows), test.rows)
+			}
+		})
+	}
+
+	// Nothing was written, and the database's other connections aren't restricted.
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM service_requests").Scan(&n); err != nil || n != 2 {
+		t.Errorf("service_requests has %d rows (%v), want 2", n, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_queries").Scan(&n); err != nil {
+		t.Errorf("reading user_queries after ReadTables: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package cassette records OpenAI traffic to fixture files and replays it offline, so the pipeline
+// can be exercised deterministically without API access.
+//
+// Each request is keyed by a hash of its method, path and normalized JSON body, and stored with its
+// response as <hash>.json in the cassette directory. Normalizing masks dates like "October 18, 2026"
+// in the body, since prompts include today's date.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Mode is whether a Transport records or replays.
+type Mode int
+
+const (
+	// Replay serves recorded responses, failing requests which weren't recorded.
+	Replay Mode = iota
+	// Record sends requests on and records their responses, replacing earlier recordings.
+	Record
+)
+
+// ParseMode parses "record" or "replay".
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "record":
+		return Record, nil
+	case "replay":
+		return Replay, nil
+	default:
+		return 0, fmt.Errorf("invalid cassette mode %q, expected record or replay", s)
+	}
+}
+
+// Transport is an http.RoundTripper which records or replays requests in a directory.
+type Transport struct {
+	dir  string
+	mode Mode
+	next http.RoundTripper
+}
+
+// New returns a Transport using the cassette in dir. When recording, requests are sent with next,
+// or http.DefaultTransport if nil.
+func New(dir string, mode Mode, next http.RoundTripper) (*Transport, error) {
+	if mode == Record {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("opening cassette: %v", err)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{dir: dir, mode: mode, next: next}, nil
+}
+
+// Interaction is a recorded request and its response, as stored in a fixture file.
+type Interaction struct {
+	Request struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		// Body is the normalized request body the hash is taken of.
+		Body json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"response"`
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	normalized, err := normalize(body)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: normalizing request body: %v", err)
+	}
+	key := hash(req.Method, req.URL.Path, normalized)
+	file := filepath.Join(t.dir, key+".json")
+
+	if t.mode == Replay {
+		b, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			log.Printf("Cassette has no recording of %s %s: %s\n", req.Method, req.URL.Path, normalized)
+			return nil, fmt.Errorf("cassette: unrecorded request %s %s (%s), record it with --cassette-mode record", req.Method, req.URL.Path, file)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cassette: %v", err)
+		}
+		var in Interaction
+		if err := json.Unmarshal(b, &in); err != nil {
+			return nil, fmt.Errorf("cassette: decoding %s: %v", file, err)
+		}
+		return response(req, in.Response.Status, in.Response.Body), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if !json.Valid(respBody) {
+		// Only JSON API responses are recorded.
+		return resp, nil
+	}
+
+	var in Interaction
+	in.Request.Method = req.Method
+	in.Request.Path = req.URL.Path
+	in.Request.Body = normalized
+	in.Response.Status = resp.StatusCode
+	in.Response.Body = respBody
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&in); err != nil {
+		return nil, fmt.Errorf("cassette: encoding %s: %v", file, err)
+	}
+	if err := os.WriteFile(file, b.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("cassette: %v", err)
+	}
+	return resp, nil
+}
+
+func response(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+var dateRE = regexp.MustCompile(`(January|February|March|April|May|June|July|August|September|October|November|December) \d{1,2}, \d{4}`)
+
+// normalize returns a JSON body with its keys sorted, insignificant whitespace removed and dates
+// masked, so equivalent requests are keyed alike.
+func normalize(body []byte) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(maskDates(v)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(b.Bytes()), nil
+}
+
+func maskDates(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return dateRE.ReplaceAllString(v, "<date>")
+	case []interface{}:
+		for i := range v {
+			v[i] = maskDates(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = maskDates(v[k])
+		}
+	}
+	return v
+}
+
+func hash(method, path string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/db/reader"
+	"github.com/geomodulus/torontobot/eval"
+	"github.com/geomodulus/torontobot/viz"
+)
+
+const usage = `Usage: torontobot [flags] [command]
+
+With no command, runs an interactive console. Commands:
+
+  ask "question"     Answer a question (--format, --chart)
+  batch <file>       Answer each line of a file as a question (--out, --concurrency)
+  sql "SELECT ..."   Run a read-only SQL query (--format)
+  datasets           List the datasets TorontoBot can answer questions about (--format)
+  eval [suites...]   Score answers to eval suites, eval/suites by default (--baseline, --out, --fake-llm)
+  serve              Run the Discord and Slack bots and HTTP server without the console
+
+Flags may also follow the command and its arguments.
+
+Flags:
+`
+
+// parseArgs parses any flags following the command and its arguments, e.g. `ask "question" --format
+// json`, returning the command and its arguments.
+func parseArgs() []string {
+	var args []string
+	rest := flag.Args()
+	for len(rest) > 0 {
+		args = append(args, rest[0])
+		flag.CommandLine.Parse(rest[1:])
+		rest = flag.Args()
+	}
+	return args
+}
+
+// stdout is where commands write their results. The bot prints progress to os.Stdout, so commands
+// point it at stderr to keep their output clean for scripts.
+var stdout io.Writer = os.Stdout
+
+func redirectStdout() {
+	stdout = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// result is the outcome of a question or query, as written by the json format and batch mode.
+type result struct {
+	Question      string          `json:"question,omitempty"`
+	Table         string          `json:"table,omitempty"`
+	Applicability string          `json:"applicability,omitempty"`
+	MissingData   string          `json:"missing_data,omitempty"`
+	SQL           string          `json:"sql,omitempty"`
+	LastRefreshed *time.Time      `json:"last_refreshed,omitempty"`
+	Columns       []string        `json:"columns"`
+	Rows          [][]interface{} `json:"rows"`
+	DurationMS    int64           `json:"duration_ms,omitempty"`
+	Error         string          `json:"error,omitempty"`
+
+	// results is the rendered results table, for the table format.
+	results string
+}
+
+// ask answers a question, returning the result even if it couldn't be answered.
+func ask(ctx context.Context, tb *bot.TorontoBot, question string) *result {
+	start := time.Now()
+	res := &result{Question: question}
+	defer func() {
+		res.DurationMS = time.Since(start).Milliseconds()
+	}()
+
+	answer, err := tb.Ask(ctx, &bot.AskRequest{Question: question})
+	if answer == nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Table = answer.Table.Name
+	res.Applicability = answer.SQLResponse.Applicability
+	res.MissingData = answer.SQLResponse.MissingData
+	res.SQL = answer.SQLResponse.SQL
+	if !answer.LastRefreshed.IsZero() {
+		res.LastRefreshed = &answer.LastRefreshed
+	}
+	res.results = answer.Results
+	switch {
+	case errors.Is(err, sql.ErrNoRows), res.MissingData != "":
+		return res
+	case err != nil:
+		res.Error = fmt.Sprintf("running query: %v", err)
+		return res
+	}
+
+	// The rendered results are formatted for people, so run the query again for raw values.
+	if res.Columns, res.Rows, err = tb.LoadRows(res.SQL); err != nil {
+		res.Error = fmt.Sprintf("loading rows: %v", err)
+	}
+	return res
+}
+
+func runAsk(ctx context.Context, tb *bot.TorontoBot, question, format, chartFile string) {
+	res := ask(ctx, tb, question)
+	if res.Error != "" && format != "json" {
+		log.Fatalf("Error %s", res.Error)
+	}
+	if format != "json" && res.SQL != "" {
+		table := res.Table
+		if res.LastRefreshed != nil {
+			table += " (" + bot.RefreshedText(*res.LastRefreshed) + ")"
+		}
+		fmt.Fprintf(os.Stderr, "Table: %s\nSQL: %s\n\n", table, res.SQL)
+	}
+	if err := writeResult(stdout, res, format); err != nil {
+		log.Fatalf("Error writing result: %v", err)
+	}
+	if res.Error != "" {
+		os.Exit(1)
+	}
+
+	if chartFile != "" && len(res.Rows) > 0 {
+		if err := writeChart(ctx, tb, question, res.results, "", chartFile); err != nil {
+			log.Fatalf("Error charting answer: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote chart to %s\n", chartFile)
+	}
+}
+
+// writeResult writes a result to w as a table, CSV or JSON.
+func writeResult(w io.Writer, res *result, format string) error {
+	switch format {
+	case "json":
+		if res.Columns == nil {
+			res.Columns = []string{}
+		}
+		if res.Rows == nil {
+			res.Rows = [][]interface{}{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(res.Columns); err != nil {
+			return err
+		}
+		record := make([]string, len(res.Columns))
+		for _, row := range res.Rows {
+			for i, v := range row {
+				if v == nil {
+					record[i] = ""
+				} else {
+					record[i] = fmt.Sprint(v)
+				}
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "table":
+		if res.MissingData != "" {
+			_, err := fmt.Fprintln(w, res.MissingData)
+			return err
+		}
+		if len(res.Rows) == 0 {
+			_, err := fmt.Fprintln(w, "No results found.")
+			return err
+		}
+		if res.results == "" {
+			tw := table.NewWriter()
+			header := table.Row{}
+			for _, column := range res.Columns {
+				header = append(header, column)
+			}
+			tw.AppendHeader(header)
+			for _, row := range res.Rows {
+				tw.AppendRow(table.Row(row))
+			}
+			res.results = tw.Render()
+		}
+		_, err := fmt.Fprintln(w, res.results)
+		return err
+
+	default:
+		return fmt.Errorf("unknown format %q, expected json, csv or table", format)
+	}
+}
+
+// writeChart charts an answer as a PNG file, as a chart of the given type or of the type the LLM
+// picks if it's empty.
+func writeChart(ctx context.Context, tb *bot.TorontoBot, question, results, chartType, path string) error {
+	chart, err := tb.SelectChart(ctx, question, results)
+	if err != nil {
+		return fmt.Errorf("selecting chart: %v", err)
+	}
+	if chartType != "" {
+		chart.Chart = chartType
+	}
+	chartHTML, err := chart.HTML(false, viz.WithFixedWidth(800), viz.WithFixedHeight(750))
+	if err != nil {
+		return err
+	}
+	png, err := viz.ScreenshotHTML(ctx, chartHTML, viz.WithWidth(800), viz.WithHeight(750))
+	if err != nil {
+		return fmt.Errorf("generating PNG: %v", err)
+	}
+	return os.WriteFile(path, png, 0644)
+}
+
+// maxBatchConcurrency is the most questions the batch command answers at once, to stay within
+// OpenAI's rate limits.
+const maxBatchConcurrency = 16
+
+// batchConcurrency clamps the --concurrency flag to between 1 and maxBatchConcurrency.
+func batchConcurrency(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > maxBatchConcurrency {
+		return maxBatchConcurrency
+	}
+	return n
+}
+
+// runBatch answers each question in a file, one per line, running up to concurrency at once. Blank
+// lines and lines starting with # are skipped. A JSON result is written for each question, in order,
+// to outFile or stdout if it's "-".
+func runBatch(ctx context.Context, tb *bot.TorontoBot, questionsFile, outFile string, concurrency int) {
+	questions, err := readQuestions(questionsFile)
+	if err != nil {
+		log.Fatalf("Error reading questions: %v", err)
+	}
+
+	w := stdout
+	if outFile != "-" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", outFile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	start := time.Now()
+	failed, err := answerBatch(w, questions, batchConcurrency(concurrency), func(question string) *result {
+		return ask(ctx, tb, question)
+	})
+	if err != nil {
+		log.Fatalf("Error writing result: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Answered %d questions with %d errors in %s\n",
+		len(questions), failed, time.Since(start).Round(time.Millisecond))
+}
+
+// answerBatch answers questions with answer, up to concurrency at once, and writes a JSON result
+// for each to w in the order of the questions. It returns how many couldn't be answered.
+func answerBatch(w io.Writer, questions []string, concurrency int, answer func(question string) *result) (int, error) {
+	// Each question reports on its own channel so results can be written in order as they finish.
+	done := make([]chan *result, len(questions))
+	for i := range done {
+		done[i] = make(chan *result, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, concurrency)
+		for i, question := range questions {
+			sem <- struct{}{}
+			go func(i int, question string) {
+				defer func() { <-sem }()
+				done[i] <- answer(question)
+			}(i, question)
+		}
+	}()
+
+	enc := json.NewEncoder(w)
+	failed := 0
+	for i := range done {
+		res := <-done[i]
+		if res.Error != "" {
+			failed++
+		}
+		if res.Columns == nil {
+			res.Columns = []string{}
+		}
+		if res.Rows == nil {
+			res.Rows = [][]interface{}{}
+		}
+		if err := enc.Encode(res); err != nil {
+			return failed, err
+		}
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s (%dms)\n", i+1, len(questions), res.Question, res.DurationMS)
+	}
+	return failed, nil
+}
+
+func readQuestions(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var questions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		questions = append(questions, line)
+	}
+	return questions, scanner.Err()
+}
+
+// runSQL runs a read-only query directly against the database, without needing the LLM.
+func runSQL(db *sql.DB, sqlQuery, format string) {
+	res, err := querySQL(db, sqlQuery)
+	if err != nil {
+		log.Fatalf("Error %v", err)
+	}
+	if err := writeResult(stdout, res, format); err != nil {
+		log.Fatalf("Error writing result: %v", err)
+	}
+}
+
+// querySQL runs a read-only query against the local dataset tables.
+func querySQL(db *sql.DB, sqlQuery string) (*result, error) {
+	if err := bot.ValidateReadOnly(sqlQuery); err != nil {
+		return nil, err
+	}
+	tables, err := bot.LoadTables()
+	if err != nil {
+		return nil, fmt.Errorf("loading tables: %v", err)
+	}
+	var names []string
+	for _, t := range tables {
+		if !t.Live() {
+			names = append(names, t.Name)
+		}
+	}
+	res := &result{SQL: sqlQuery}
+	err = bot.ReadTables(db, names, func(q reader.Querier) error {
+		var err error
+		res.Columns, res.Rows, err = reader.ReadRows(q, sqlQuery)
+		return err
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("running query: %w", err)
+	}
+	return res, nil
+}
+
+// maxTableDescLen keeps dataset descriptions to a readable width in the table format.
+const maxTableDescLen = 80
+
+// dataset is a table described in tables.json5, with when its data was last refreshed.
+type dataset struct {
+	*bot.DataTable
+	LastRefreshed *time.Time `json:"last_refreshed,omitempty"`
+}
+
+// listDatasets lists the tables described in tables.json5.
+func listDatasets(db *sql.DB, format string) {
+	tables, err := bot.LoadTables()
+	if err != nil {
+		log.Fatalf("Error loading tables: %v", err)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+	var datasets []*dataset
+	for _, t := range tables {
+		ds := &dataset{DataTable: t}
+		refreshed, err := uq.LastRefreshed(db, t.Name)
+		if err != nil {
+			log.Printf("Error getting last refresh of %s: %v", t.Name, err)
+		} else if !refreshed.IsZero() {
+			ds.LastRefreshed = &refreshed
+		}
+		datasets = append(datasets, ds)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(datasets); err != nil {
+			log.Fatalf("Error writing datasets: %v", err)
+		}
+	default:
+		res := &result{Columns: []string{"name", "description", "source", "last_refreshed"}}
+		for _, ds := range datasets {
+			desc := strings.Join(strings.Fields(ds.Desc), " ")
+			if format == "table" && len(desc) > maxTableDescLen {
+				desc = desc[:maxTableDescLen-3] + "..."
+			}
+			var refreshed interface{}
+			if ds.LastRefreshed != nil {
+				refreshed = ds.LastRefreshed.Local().Format(time.DateOnly)
+			}
+			res.Rows = append(res.Rows, []interface{}{ds.Name, desc, ds.Source, refreshed})
+		}
+		if err := writeResult(stdout, res, format); err != nil {
+			log.Fatalf("Error writing datasets: %v", err)
+		}
+	}
+}
+
+// runEval runs eval suites and prints a report, compared with the run saved in baselineFile if set.
+// The run is saved to outFile unless it's "-". It exits non-zero if accuracy is below minAccuracy.
+func runEval(ctx context.Context, tb *bot.TorontoBot, usage *eval.UsageCounter, suites []*eval.Suite, baselineFile, outFile string, minAccuracy float64) {
+	var baseline *eval.Report
+	if baselineFile != "" {
+		var err error
+		if baseline, err = eval.LoadReport(baselineFile); err != nil {
+			log.Fatalf("Error loading baseline: %v", err)
+		}
+	}
+
+	report := eval.Run(ctx, tb, usage, suites)
+	if err := report.Write(stdout, baseline); err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	if outFile != "-" {
+		if err := report.Save(outFile); err != nil {
+			log.Fatalf("Error saving run: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Saved run to %s\n", outFile)
+	}
+	if accuracy := report.Summary().Accuracy(); accuracy < minAccuracy {
+		log.Fatalf("Accuracy %.1f%% is below the minimum of %.1f%%", accuracy*100, minAccuracy*100)
+	}
+}
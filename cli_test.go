@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func TestAnswerBatch(t *testing.T) {
+	var questions []string
+	for i := 0; i < 20; i++ {
+		questions = append(questions, fmt.Sprint("question ", i))
+	}
+	var running, most int32
+	answer := func(question string) *result {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		// Finish out of order.
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		res := &result{Question: question}
+		if strings.HasSuffix(question, "7") {
+			res.Error = "failed"
+		}
+		return res
+	}
+
+	var out bytes.Buffer
+	failed, err := answerBatch(&out, questions, 4, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 2 {
+		t.Errorf("failed = %d, want 2", failed)
+	}
+	if most := atomic.LoadInt32(&most); most > 4 {
+		t.Errorf("answered %d questions at once, want at most 4", most)
+	}
+
+	dec := json.NewDecoder(&out)
+	for i, question := range questions {
+		var res struct {
+			Question string          `json:"question"`
+			Columns  []string        `json:"columns"`
+			Rows     [][]interface{} `json:"rows"`
+		}
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("decoding result %d: %v", i, err)
+		}
+		if res.Question != question {
+			t.Errorf("result %d is for %q, want %q", i, res.Question, question)
+		}
+		if res.Columns == nil || res.Rows == nil {
+			t.Errorf("result %d has null columns or rows", i)
+		}
+	}
+	if dec.More() {
+		t.Error("more results than questions")
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	for n, want := range map[int]int{-1: 1, 0: 1, 1: 1, 4: 4, maxBatchConcurrency: maxBatchConcurrency, 1000: maxBatchConcurrency} {
+		if got := batchConcurrency(n); got != want {
+			t.Errorf("batchConcurrency(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestQuerySQL(t *testing.T) {
+	db := testutil.DB(t)
+	if _, err := db.Exec(`INSERT INTO service_requests (year, ward) VALUES (2022, 'Davenport'), (2023, 'Davenport')`); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := querySQL(db, "SELECT year FROM service_requests ORDER BY year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(res.Columns, res.Rows); got != "[year] [[2022] [2023]]" {
+		t.Errorf("results = %s", got)
+	}
+	if res, err := querySQL(db, "SELECT year FROM service_requests WHERE year = 1900"); err != nil || len(res.Rows) != 0 {
+		t.Errorf("query with no results = %v, %v", res, err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM service_requests",
+		"UPDATE service_requests SET year = 1900",
+		"SELECT 1; DROP TABLE service_requests",
+		"SELECT * FROM user_queries",
+		"SELECT sql FROM sqlite_master",
+	} {
+		if _, err := querySQL(db, query); !errors.Is(err, bot.ErrNotReadOnly) {
+			t.Errorf("querySQL(%q) = %v, want ErrNotReadOnly", query, err)
+		}
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM service_requests WHERE year > 1900").Scan(&n); err != nil || n != 2 {
+		t.Errorf("service_requests has %d rows (%v), want 2", n, err)
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	res := &result{Columns: []string{"ward", "requests"}, Rows: [][]interface{}{{"Davenport", int64(3)}, {nil, int64(1)}}}
+	for format, want := range map[string]string{
+		"csv":   "ward,requests\nDavenport,3\n,1\n",
+		"json":  `"columns": [`,
+		"table": "| WARD      | REQUESTS |",
+	} {
+		var out bytes.Buffer
+		if err := writeResult(&out, res, format); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%s output doesn't contain %q:\n%s", format, want, out.String())
+		}
+	}
+	if err := writeResult(&bytes.Buffer{}, res, "xml"); err == nil {
+		t.Error("writing an unknown format succeeded")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/geomodulus/torontobot/bot"
+)
+
+const consoleHelp = `Type a question to ask it, or a command:
+
+  :tables                    List the tables questions are answered from
+  :schema <table>            Show the columns of a table
+  :use [table]               Answer questions from a table instead of selecting one, or stop with no table
+  :sql <query>               Run a read-only SQL query
+  :last                      Show the SQL of the last answer
+  :export csv|json <file>    Write the results of the last answer to a file
+  :chart [type] <file.png>   Chart the last answer, as a bar, stacked-bar, line or pie chart
+  :history                   List the questions asked this session
+  :model [name]              Show or change the OpenAI model
+  :help                      Show this help`
+
+var (
+	consoleCommands = []string{
+		":chart", ":export", ":help", ":history", ":last", ":model", ":schema", ":sql", ":tables", ":use",
+	}
+	chartTypes    = []string{"bar", "stacked-bar", "line", "pie"}
+	exportFormats = []string{"csv", "json"}
+)
+
+// column is a column of a table in the database.
+type column struct {
+	name, typ string
+}
+
+// console is the state the interactive console keeps between lines for its commands. It also
+// completes table and column names for readline.
+type console struct {
+	db *sql.DB
+	tb *bot.TorontoBot
+	// out is where commands print their output.
+	out io.Writer
+	// table, if set, answers every question instead of selecting a table for each.
+	table *bot.DataTable
+	// last is the last answer or query result, nil before the first.
+	last    *result
+	history []string
+
+	columns    map[string][]column
+	tableNames []string
+	// words are the table and column names completed in questions and SQL.
+	words []string
+}
+
+func newConsole(db *sql.DB, tb *bot.TorontoBot) *console {
+	c := &console{db: db, tb: tb, out: os.Stdout, columns: map[string][]column{}}
+	seen := map[string]bool{}
+	for _, t := range tb.Tables() {
+		c.tableNames = append(c.tableNames, t.Name)
+		c.words = append(c.words, t.Name)
+		columns, err := tableColumns(db, t.Name)
+		if err != nil {
+			// The table may not have been ingested yet, which :schema will point out.
+			continue
+		}
+		c.columns[t.Name] = columns
+		for _, col := range columns {
+			if !seen[col.name] {
+				seen[col.name] = true
+				c.words = append(c.words, col.name)
+			}
+		}
+	}
+	sort.Strings(c.words)
+	return c
+}
+
+// tableColumns returns the columns of a table in the database.
+func tableColumns(db *sql.DB, name string) ([]column, error) {
+	rows, err := db.Query("SELECT name, type FROM pragma_table_info(?)", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []column
+	for rows.Next() {
+		var col column
+		if err := rows.Scan(&col.name, &col.typ); err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// command runs a line starting with ":", printing its output or any error.
+func (c *console) command(ctx context.Context, line string) {
+	name, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	args = strings.TrimSpace(args)
+	var err error
+	switch name {
+	case ":help":
+		fmt.Fprintln(c.out, consoleHelp)
+	case ":tables":
+		err = c.listTables()
+	case ":schema":
+		err = c.showSchema(args)
+	case ":use":
+		err = c.use(args)
+	case ":sql":
+		err = c.runSQL(args)
+	case ":last":
+		err = c.showLast()
+	case ":export":
+		err = c.export(args)
+	case ":chart":
+		err = c.chart(ctx, args)
+	case ":history":
+		for i, question := range c.history {
+			fmt.Fprintf(c.out, "%3d  %s\n", i+1, question)
+		}
+	case ":model":
+		if args == "" {
+			fmt.Fprintln(c.out, "Model:", c.tb.Model())
+			break
+		}
+		c.tb.SetModel(args)
+		fmt.Fprintln(c.out, "Now using model", args)
+	default:
+		err = fmt.Errorf("unknown command %q, type :help for a list", name)
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, "Error", err)
+	}
+}
+
+func (c *console) listTables() error {
+	res := &result{Columns: []string{"name", "description", "last refreshed"}}
+	for _, t := range c.tb.Tables() {
+		name := t.Name
+		if c.table != nil && c.table.Name == t.Name {
+			name += " (in use)"
+		}
+		desc := strings.Join(strings.Fields(t.Desc), " ")
+		if len(desc) > maxTableDescLen {
+			desc = desc[:maxTableDescLen-3] + "..."
+		}
+		refreshed, err := c.tb.LastRefreshed(t.Name)
+		if err != nil {
+			log.Println("Error getting last refresh:", err)
+		}
+		var date string
+		if !refreshed.IsZero() {
+			date = refreshed.Local().Format(time.DateOnly)
+		}
+		res.Rows = append(res.Rows, []interface{}{name, desc, date})
+	}
+	return writeResult(c.out, res, "table")
+}
+
+func (c *console) lookupTable(name string) (*bot.DataTable, error) {
+	if name == "" {
+		return nil, errors.New("missing table name")
+	}
+	t, ok := c.tb.Table(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown table %q, see :tables", name)
+	}
+	return t, nil
+}
+
+func (c *console) showSchema(name string) error {
+	t, err := c.lookupTable(name)
+	if err != nil {
+		return err
+	}
+	columns := c.columns[t.Name]
+	if len(columns) == 0 {
+		fmt.Fprintf(c.out, "%s hasn't been loaded into the database yet. It's defined as:\n%s\n",
+			t.Name, strings.Join(strings.Fields(t.Schema), " "))
+		return nil
+	}
+	tw := table.NewWriter()
+	tw.AppendHeader(table.Row{"Column", "Type"})
+	for _, col := range columns {
+		tw.AppendRow(table.Row{col.name, col.typ})
+	}
+	fmt.Fprintln(c.out, tw.Render())
+	return nil
+}
+
+func (c *console) use(name string) error {
+	if name == "" {
+		c.table = nil
+		fmt.Fprintln(c.out, "Selecting a table for each question again.")
+		return nil
+	}
+	t, err := c.lookupTable(name)
+	if err != nil {
+		return err
+	}
+	c.table = t
+	fmt.Fprintf(c.out, "Answering questions from %s. Type :use on its own to stop.\n", t.Name)
+	return nil
+}
+
+func (c *console) runSQL(sqlQuery string) error {
+	if sqlQuery == "" {
+		return errors.New("missing SQL query")
+	}
+	res := &result{SQL: sqlQuery}
+	var err error
+	if res.Columns, res.Rows, err = c.tb.LoadRows(sqlQuery); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	c.last = res
+	return writeResult(c.out, res, "table")
+}
+
+func (c *console) showLast() error {
+	if c.last == nil {
+		return errors.New("nothing has been asked yet")
+	}
+	if c.last.Question != "" {
+		fmt.Fprintln(c.out, "Question:", c.last.Question)
+	}
+	if c.last.Table != "" {
+		fmt.Fprintln(c.out, "Table:", c.last.Table)
+	}
+	fmt.Fprintln(c.out, "SQL:", c.last.SQL)
+	return nil
+}
+
+// lastRows returns the last result, loading its raw rows if it was an answer to a question.
+func (c *console) lastRows() (*result, error) {
+	if c.last == nil || c.last.SQL == "" {
+		return nil, errors.New("there's no answer to use yet")
+	}
+	if c.last.Rows == nil {
+		var err error
+		if c.last.Columns, c.last.Rows, err = c.tb.LoadRows(c.last.SQL); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("loading rows: %v", err)
+		}
+	}
+	return c.last, nil
+}
+
+func (c *console) export(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return errors.New("usage: :export csv|json <file>")
+	}
+	format, path := fields[0], fields[1]
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q, expected csv or json", format)
+	}
+	res, err := c.lastRows()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeResult(f, res, format); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Wrote %d rows to %s\n", len(res.Rows), path)
+	return nil
+}
+
+func (c *console) chart(ctx context.Context, args string) error {
+	var chartType, path string
+	switch fields := strings.Fields(args); len(fields) {
+	case 1:
+		path = fields[0]
+	case 2:
+		chartType, path = fields[0], fields[1]
+		if !contains(chartTypes, chartType) {
+			return fmt.Errorf("unknown chart type %q, expected one of %s", chartType, strings.Join(chartTypes, ", "))
+		}
+	default:
+		return errors.New("usage: :chart [type] <file.png>")
+	}
+	if c.last == nil || c.last.SQL == "" {
+		return errors.New("there's no answer to chart yet")
+	}
+	if c.last.results == "" {
+		results, err := c.tb.LoadResults(c.last.SQL, false)
+		if err != nil {
+			return fmt.Errorf("loading results: %v", err)
+		}
+		c.last.results = results
+	}
+
+	// Results of :sql have no question, so the query describes them instead.
+	title := c.last.Question
+	if title == "" {
+		title = c.last.SQL
+	}
+	if err := writeChart(ctx, c.tb, title, c.last.results, chartType, path); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Wrote chart to", path)
+	return nil
+}
+
+// Do completes commands, then table names, chart types or export formats as the first argument of
+// the commands that take them, and table and column names in questions and SQL. It implements
+// readline.AutoCompleter.
+func (c *console) Do(line []rune, pos int) ([][]rune, int) {
+	before := string(line[:pos])
+	word := before[strings.LastIndexAny(before, " \t(),.=<>")+1:]
+
+	var candidates []string
+	name, args, hasArgs := strings.Cut(strings.TrimLeft(before, " \t"), " ")
+	switch {
+	case !hasArgs && strings.HasPrefix(name, ":"):
+		candidates = consoleCommands
+	case name == ":sql", !strings.HasPrefix(name, ":"):
+		candidates = c.words
+	case strings.ContainsAny(strings.TrimLeft(args, " \t"), " \t"):
+		// Only the first argument of other commands is completed.
+	case name == ":schema", name == ":use":
+		candidates = c.tableNames
+	case name == ":chart":
+		candidates = chartTypes
+	case name == ":export":
+		candidates = exportFormats
+	}
+
+	var suffixes [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			suffixes = append(suffixes, []rune(candidate[len(word):]+" "))
+		}
+	}
+	return suffixes, len([]rune(word))
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func testConsole(t *testing.T) (*console, *bytes.Buffer) {
+	t.Helper()
+	db := testutil.DB(t)
+	if _, err := db.Exec(`INSERT INTO service_requests (year, ward) VALUES (2022, 'Davenport'), (2023, 'Davenport')`); err != nil {
+		t.Fatal(err)
+	}
+	tb, err := bot.New(context.Background(), db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c := newConsole(db, tb)
+	c.out = &out
+	return c, &out
+}
+
+func TestConsoleCommands(t *testing.T) {
+	c, out := testConsole(t)
+	export := filepath.Join(t.TempDir(), "out.csv")
+	for _, test := range []struct {
+		line string
+		want string
+	}{
+		{":help", ":export csv|json <file>"},
+		{"  :tables  ", "service_requests"},
+		{":schema service_requests", "| ward"},
+		{":schema", "Error missing table name"},
+		{":schema user_queries", `Error unknown table "user_queries"`},
+		{":use service_requests", "Answering questions from service_requests."},
+		{":tables", "service_requests (in use)"},
+		{":use", "Selecting a table for each question again."},
+		{":last", "Error nothing has been asked yet"},
+		{":export csv " + export, "Error there's no answer to use yet"},
+		{":sql", "Error missing SQL query"},
+		{":sql DELETE FROM service_requests", "Error only read-only SELECT queries are allowed"},
+		{":sql SELECT year FROM service_requests ORDER BY year", "2023"},
+		{":last", "SQL: SELECT year FROM service_requests ORDER BY year"},
+		{":export xml " + export, `Error unknown format "xml"`},
+		{":export csv", "Error usage: :export csv|json <file>"},
+		{":export csv " + export, "Wrote 2 rows to " + export},
+		{":chart", "Error usage: :chart [type] <file.png>"},
+		{":chart donut chart.png", `Error unknown chart type "donut"`},
+		{":model gpt-4o", "Now using model gpt-4o"},
+		{":model", "Model: gpt-4o"},
+		{":tabels", `Error unknown command ":tabels", type :help for a list`},
+	} {
+		out.Reset()
+		c.command(context.Background(), test.line)
+		if !strings.Contains(out.String(), test.want) {
+			t.Errorf("%q printed %q, want it to contain %q", test.line, out.String(), test.want)
+		}
+	}
+
+	data, err := os.ReadFile(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "year\n2022\n2023\n"; got != want {
+		t.Errorf("exported %q, want %q", got, want)
+	}
+}
+
+func TestConsoleComplete(t *testing.T) {
+	c, _ := testConsole(t)
+	for _, test := range []struct {
+		line string
+		want []string
+	}{
+		{":", consoleCommands},
+		{":s", []string{":schema", ":sql"}},
+		{":schema serv", []string{"service_requests"}},
+		{":use ase", []string{"ase_tickets"}},
+		{":chart ", chartTypes},
+		{":chart st", []string{"stacked-bar"}},
+		{":chart bar ", nil},
+		{":export j", []string{"json"}},
+		{":export json ", nil},
+		{":model g", nil},
+		{":sql SELECT ward FROM service_req", []string{"service_request_type", "service_requests"}},
+		{":sql SELECT COUNT(service_request_t", []string{"service_request_type"}},
+		{"How many requests per wa", []string{"ward"}},
+	} {
+		suffixes, n := c.Do([]rune(test.line), len([]rune(test.line)))
+		word := test.line[strings.LastIndexAny(test.line, " \t(),.=<>")+1:]
+		if n != len([]rune(word)) {
+			t.Errorf("%q replaces %d runes, want %d", test.line, n, len(word))
+		}
+		var got []string
+		for _, suffix := range suffixes {
+			got = append(got, word+strings.TrimSuffix(string(suffix), " "))
+		}
+		want := append([]string(nil), test.want...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%q completes to %v, want %v", test.line, got, want)
+		}
+	}
+}
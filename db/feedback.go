@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/geomodulus/torontobot/bot"
+)
+
+const (
+	RatingUp   = 1
+	RatingDown = -1
+)
+
+// Feedback is a user's rating of an answer, stored against the user_queries row it was given for.
+type Feedback struct {
+	QueryID int64
+	UserID  string
+	Rating  int
+	Comment string
+}
+
+// StoreFeedback records a rating, replacing any earlier rating by the same user for the same query.
+func StoreFeedback(db *sql.DB, fb *Feedback) error {
+	_, err := db.Exec(`INSERT INTO answer_feedback (query_id, user_id, rating, comment)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (query_id, user_id) DO UPDATE SET
+			rating = excluded.rating,
+			comment = COALESCE(excluded.comment, answer_feedback.comment),
+			created_at = CURRENT_TIMESTAMP`,
+		fb.QueryID, fb.UserID, fb.Rating, nullString(fb.Comment))
+	return err
+}
+
+// TableAccuracy summarizes feedback for answers generated against a single table.
+type TableAccuracy struct {
+	TableName  string
+	Queries    int
+	ThumbsUp   int
+	ThumbsDown int
+}
+
+// Accuracy returns the share of rated answers which were rated thumbs up.
+func (a *TableAccuracy) Accuracy() float64 {
+	rated := a.ThumbsUp + a.ThumbsDown
+	if rated == 0 {
+		return 0
+	}
+	return float64(a.ThumbsUp) / float64(rated)
+}
+
+// AccuracyByTable summarizes feedback per table, for queries which received any feedback.
+func AccuracyByTable(db *sql.DB) ([]*TableAccuracy, error) {
+	rows, err := db.Query(`SELECT COALESCE(q.table_name, ''),
+			COUNT(DISTINCT q.id),
+			SUM(CASE WHEN f.rating > 0 THEN 1 ELSE 0 END),
+			SUM(CASE WHEN f.rating < 0 THEN 1 ELSE 0 END)
+		FROM answer_feedback f
+		JOIN user_queries q ON q.id = f.query_id
+		GROUP BY 1
+		ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*TableAccuracy
+	for rows.Next() {
+		var ta TableAccuracy
+		if err := rows.Scan(&ta.TableName, &ta.Queries, &ta.ThumbsUp, &ta.ThumbsDown); err != nil {
+			return nil, err
+		}
+		results = append(results, &ta)
+	}
+	return results, rows.Err()
+}
+
+// RatedQuery is a stored query along with the sum of its ratings and any comments left on it.
+type RatedQuery struct {
+	*UserQuery
+	Score    int
+	Comments []string
+}
+
+// ThumbsDownQueries lists queries which received at least one thumbs down, worst first.
+func ThumbsDownQueries(db *sql.DB) ([]*RatedQuery, error) {
+	return ratedQueries(db, `HAVING SUM(CASE WHEN f.rating < 0 THEN 1 ELSE 0 END) > 0
+		ORDER BY score ASC, q.id DESC`)
+}
+
+// TopRatedQueries lists queries whose ratings sum to at least minScore, best first. These make good
+// few-shot examples for tables.json5.
+func TopRatedQueries(db *sql.DB, minScore int) ([]*RatedQuery, error) {
+	return ratedQueries(db, `HAVING score >= ? ORDER BY score DESC, q.id DESC`, minScore)
+}
+
+// KnownGoodQuestions returns questions about a table whose answers were rated thumbs up overall,
+// best first.
+func KnownGoodQuestions(db *sql.DB, tableName string, limit int) ([]string, error) {
+	rows, err := db.Query(`SELECT q.question
+		FROM answer_feedback f
+		JOIN user_queries q ON q.id = f.query_id
+		WHERE q.table_name = ?
+		GROUP BY q.question
+		HAVING SUM(f.rating) > 0
+		ORDER BY SUM(f.rating) DESC
+		LIMIT ?`, tableName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var questions []string
+	for rows.Next() {
+		var question string
+		if err := rows.Scan(&question); err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
+
+func ratedQueries(db *sql.DB, having string, args ...interface{}) ([]*RatedQuery, error) {
+	rows, err := db.Query(`SELECT q.id, q.user_id, q.question, COALESCE(q.table_name, ''),
+			q.schema_comment, q.applicability, q.sql_query, q.is_currency,
+			SUM(f.rating) AS score,
+			COALESCE(GROUP_CONCAT(f.comment, char(10)), '')
+		FROM answer_feedback f
+		JOIN user_queries q ON q.id = f.query_id
+		GROUP BY q.id `+having, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*RatedQuery
+	for rows.Next() {
+		rq := RatedQuery{UserQuery: &UserQuery{SQLResponse: &bot.SQLResponse{}}}
+		var comments string
+		if err := rows.Scan(
+			&rq.ID,
+			&rq.UserID,
+			&rq.Question,
+			&rq.TableName,
+			&rq.SQLResponse.Schema,
+			&rq.SQLResponse.Applicability,
+			&rq.SQLResponse.SQL,
+			&rq.SQLResponse.IsCurrency,
+			&rq.Score,
+			&comments,
+		); err != nil {
+			return nil, err
+		}
+		if comments != "" {
+			rq.Comments = strings.Split(comments, "\n")
+		}
+		results = append(results, &rq)
+	}
+	return results, rows.Err()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// IngestCheckpoint records how far a streamed load of a year of a dataset got, so an interrupted load
+// can be resumed from the same row of the same file.
+type IngestCheckpoint struct {
+	Dataset string
+	Year    int
+	// SHA256 is the hex checksum of the resource being loaded. A checkpoint only applies to the file
+	// it was made loading.
+	SHA256 string
+	// Offset is how many rows of the resource have been loaded.
+	Offset    int
+	UpdatedAt time.Time
+}
+
+// GetIngestCheckpoint returns the checkpoint of an unfinished load of a year of a dataset, or nil if
+// there isn't one.
+func GetIngestCheckpoint(db *sql.DB, dataset string, year int) (*IngestCheckpoint, error) {
+	cp := IngestCheckpoint{Dataset: dataset, Year: year}
+	err := db.QueryRow(`SELECT sha256, row_offset, updated_at FROM ingest_checkpoints
+		WHERE dataset = ? AND year = ?`, dataset, year).Scan(&cp.SHA256, &cp.Offset, &cp.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// SaveIngestCheckpoint records a checkpoint in the transaction which loaded the rows up to it, so the
+// two are committed together.
+func SaveIngestCheckpoint(tx *sql.Tx, cp *IngestCheckpoint) error {
+	_, err := tx.Exec(`INSERT INTO ingest_checkpoints (dataset, year, sha256, row_offset, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (dataset, year) DO UPDATE SET
+			sha256 = excluded.sha256, row_offset = excluded.row_offset, updated_at = excluded.updated_at`,
+		cp.Dataset, cp.Year, cp.SHA256, cp.Offset, time.Now().UTC())
+	return err
+}
+
+// DeleteIngestCheckpoint removes the checkpoint of a year of a dataset once its load is finished.
+func DeleteIngestCheckpoint(tx *sql.Tx, dataset string, year int) error {
+	_, err := tx.Exec(`DELETE FROM ingest_checkpoints WHERE dataset = ? AND year = ?`, dataset, year)
+	return err
+}
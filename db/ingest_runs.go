@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	IngestSucceeded = "succeeded"
+	IngestFailed    = "failed"
+	// IngestUnchanged is the status of a refresh which found the resource unchanged since it was last
+	// ingested, and so didn't load it.
+	IngestUnchanged = "unchanged"
+)
+
+// IngestRun records one attempt to ingest a year of a dataset, in the ingest_runs ledger.
+type IngestRun struct {
+	ID        int64
+	Dataset   string
+	TableName string
+	Year      int
+	URL       string
+	// ETag and LastModified are the HTTP headers the resource was served with, if any.
+	ETag         string
+	LastModified string
+	// SHA256 is the hex checksum of the downloaded resource.
+	SHA256 string
+	// SourceHash is the hash the open data portal published for the resource, if any.
+	SourceHash string
+	// RowsLoaded is how many rows were loaded for the year, and TableRows how many rows the table
+	// held afterwards.
+	RowsLoaded int
+	TableRows  int
+	Duration   time.Duration
+	Status     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RecordIngestRun adds a run to the ledger, returning its ID.
+func RecordIngestRun(db *sql.DB, run *IngestRun) (int64, error) {
+	res, err := db.Exec(`INSERT INTO ingest_runs
+		(dataset, table_name, year, url, etag, last_modified, sha256, source_hash, rows_loaded, table_rows,
+			duration_ms, status, error, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Dataset, run.TableName, run.Year, run.URL, nullString(run.ETag), nullString(run.LastModified),
+		nullString(run.SHA256), nullString(run.SourceHash), run.RowsLoaded, run.TableRows,
+		run.Duration.Milliseconds(), run.Status, nullString(run.Error), run.StartedAt.UTC(), run.FinishedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// LastIngest returns the last run which successfully ingested a year of a dataset, or nil if there
+// hasn't been one.
+func LastIngest(db *sql.DB, dataset string, year int) (*IngestRun, error) {
+	var (
+		run                                         IngestRun
+		etag, lastModified, sha, sourceHash, errMsg sql.NullString
+		durationMS                                  int64
+	)
+	err := db.QueryRow(`SELECT id, dataset, table_name, year, url, etag, last_modified, sha256, source_hash,
+			rows_loaded, table_rows, duration_ms, status, error, started_at, finished_at
+		FROM ingest_runs
+		WHERE dataset = ? AND year = ? AND status = ?
+		ORDER BY id DESC LIMIT 1`, dataset, year, IngestSucceeded).Scan(
+		&run.ID, &run.Dataset, &run.TableName, &run.Year, &run.URL, &etag, &lastModified, &sha, &sourceHash,
+		&run.RowsLoaded, &run.TableRows, &durationMS, &run.Status, &errMsg, &run.StartedAt, &run.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.ETag, run.LastModified, run.SHA256, run.SourceHash = etag.String, lastModified.String, sha.String, sourceHash.String
+	run.Error = errMsg.String
+	run.Duration = time.Duration(durationMS) * time.Millisecond
+	return &run, nil
+}
+
+// LastRefreshed returns when data was last successfully ingested into a table, or the zero time if it
+// never has been.
+func LastRefreshed(db *sql.DB, table string) (time.Time, error) {
+	var finishedAt time.Time
+	err := db.QueryRow(`SELECT finished_at FROM ingest_runs
+		WHERE table_name = ? AND status = ?
+		ORDER BY finished_at DESC LIMIT 1`, table, IngestSucceeded).Scan(&finishedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return finishedAt, err
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS discord_threads (
+    thread_id TEXT PRIMARY KEY,
+    table_name TEXT NOT NULL,
+    last_query_id INTEGER NOT NULL REFERENCES user_queries(id),
+    last_channel_id TEXT NOT NULL,
+    last_message_id TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query_id INTEGER NOT NULL REFERENCES user_queries(id),
+    user_id TEXT NOT NULL,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    schedule TEXT,
+    on_ingest BOOLEAN NOT NULL DEFAULT 0,
+    last_results TEXT NOT NULL,
+    table_fingerprint TEXT,
+    last_run_at TIMESTAMP,
+    next_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_channel_id ON subscriptions (channel_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS publish_audit_log;
+DROP TABLE IF EXISTS publish_requests;
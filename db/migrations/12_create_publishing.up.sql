@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS publish_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    query_id INTEGER NOT NULL REFERENCES user_queries(id),
+    user_id TEXT NOT NULL,
+    username TEXT NOT NULL,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    reviewer_id TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS publish_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    module_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    query_id INTEGER NOT NULL REFERENCES user_queries(id),
+    user_id TEXT NOT NULL,
+    username TEXT NOT NULL,
+    guild_id TEXT NOT NULL,
+    approved_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
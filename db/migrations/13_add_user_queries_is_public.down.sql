@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS user_queries_public_created_at;
+
+ALTER TABLE user_queries DROP COLUMN is_public;
@@ -0,0 +1,3 @@
+ALTER TABLE user_queries ADD COLUMN is_public BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS user_queries_public_created_at ON user_queries (is_public, created_at);
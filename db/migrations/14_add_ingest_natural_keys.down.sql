@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS service_requests_year;
+DROP INDEX IF EXISTS operating_budget_year;
+DROP INDEX IF EXISTS condominium_apartment_price_natural_key;
+DROP INDEX IF EXISTS ase_tickets_natural_key;
@@ -0,0 +1,16 @@
+-- Ingest used to append, so drop rows loaded more than once before making natural keys unique.
+DELETE FROM ase_tickets WHERE id NOT IN (
+    SELECT MAX(id) FROM ase_tickets GROUP BY site_code, enforcement_start_date, year, month
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ase_tickets_natural_key
+    ON ase_tickets (site_code, enforcement_start_date, year, month);
+
+DELETE FROM condominium_apartment_price WHERE id NOT IN (
+    SELECT MAX(id) FROM condominium_apartment_price GROUP BY geolocation, year, record_period
+);
+CREATE UNIQUE INDEX IF NOT EXISTS condominium_apartment_price_natural_key
+    ON condominium_apartment_price (geolocation, year, record_period);
+
+-- Operating budget line items and 311 requests have no natural key, and are replaced a year at a time.
+CREATE INDEX IF NOT EXISTS operating_budget_year ON operating_budget (year);
+CREATE INDEX IF NOT EXISTS service_requests_year ON service_requests (year);
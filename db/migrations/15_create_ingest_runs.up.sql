@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS ingest_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    dataset TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    etag TEXT,
+    last_modified TEXT,
+    sha256 TEXT,
+    rows_loaded INTEGER NOT NULL DEFAULT 0,
+    table_rows INTEGER NOT NULL DEFAULT 0,
+    duration_ms INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ingest_runs_dataset_year ON ingest_runs (dataset, year);
+CREATE INDEX IF NOT EXISTS ingest_runs_table_name ON ingest_runs (table_name);
@@ -0,0 +1 @@
+ALTER TABLE ingest_runs DROP COLUMN source_hash;
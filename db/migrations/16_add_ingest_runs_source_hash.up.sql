@@ -0,0 +1 @@
+ALTER TABLE ingest_runs ADD COLUMN source_hash TEXT;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ingest_checkpoints (
+    dataset TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,
+    row_offset INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (dataset, year)
+);
@@ -0,0 +1 @@
+ALTER TABLE user_queries DROP COLUMN result_rows;
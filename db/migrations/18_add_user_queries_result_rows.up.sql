@@ -0,0 +1 @@
+ALTER TABLE user_queries ADD COLUMN result_rows TEXT;
@@ -0,0 +1 @@
+ALTER TABLE user_queries ADD COLUMN parent_id INTEGER REFERENCES user_queries(id);
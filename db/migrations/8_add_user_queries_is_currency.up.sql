@@ -0,0 +1 @@
+ALTER TABLE user_queries ADD COLUMN is_currency BOOLEAN NOT NULL DEFAULT 0;
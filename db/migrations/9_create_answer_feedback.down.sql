@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS answer_feedback;
+
+ALTER TABLE user_queries DROP COLUMN table_name;
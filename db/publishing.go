@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Statuses of a request to publish a chart to the web.
+const (
+	PublishPending  = "pending"
+	PublishApproved = "approved"
+	PublishRejected = "rejected"
+)
+
+// PublishRequest is an export to the web held for approval by a moderator.
+type PublishRequest struct {
+	ID         int64
+	QueryID    int64
+	UserID     string
+	Username   string
+	GuildID    string
+	ChannelID  string
+	Status     string
+	ReviewerID string
+	CreatedAt  time.Time
+}
+
+// Publication is an audit log entry for a module published to the web.
+type Publication struct {
+	ModuleID string
+	URL      string
+	QueryID  int64
+	UserID   string
+	Username string
+	GuildID  string
+	// ApprovedBy is the moderator who approved the export, or empty if it didn't need approval.
+	ApprovedBy string
+	CreatedAt  time.Time
+}
+
+// StorePublishRequest queues an export for approval, returning the request's ID.
+func StorePublishRequest(db *sql.DB, r *PublishRequest) (int64, error) {
+	res, err := db.Exec(`INSERT INTO publish_requests (query_id, user_id, username, guild_id, channel_id)
+		VALUES (?, ?, ?, ?, ?)`,
+		r.QueryID, r.UserID, r.Username, r.GuildID, r.ChannelID)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetPublishRequest returns the publish request with the given ID, or nil if there isn't one.
+func GetPublishRequest(db *sql.DB, id int64) (*PublishRequest, error) {
+	row := db.QueryRow(`SELECT id, query_id, user_id, username, guild_id, channel_id, status, reviewer_id, created_at
+		FROM publish_requests WHERE id = ?`, id)
+
+	var (
+		r        PublishRequest
+		reviewer sql.NullString
+	)
+	if err := row.Scan(&r.ID, &r.QueryID, &r.UserID, &r.Username, &r.GuildID, &r.ChannelID, &r.Status, &reviewer, &r.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	r.ReviewerID = reviewer.String
+	return &r, nil
+}
+
+// ReviewPublishRequest moves a pending request to the given status. It reports false if the request
+// was no longer pending, so that two moderators can't both act on it.
+func ReviewPublishRequest(db *sql.DB, id int64, status, reviewerID string) (bool, error) {
+	res, err := db.Exec(`UPDATE publish_requests
+		SET status = ?, reviewer_id = ?, reviewed_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?`,
+		status, reviewerID, id, PublishPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReopenPublishRequest puts a request back in the queue, for when publishing an approved request
+// fails.
+func ReopenPublishRequest(db *sql.DB, id int64) error {
+	_, err := db.Exec(`UPDATE publish_requests
+		SET status = ?, reviewer_id = NULL, reviewed_at = NULL
+		WHERE id = ?`,
+		PublishPending, id)
+	return err
+}
+
+// StorePublication records a module published to the web in the audit log.
+func StorePublication(db *sql.DB, p *Publication) error {
+	_, err := db.Exec(`INSERT INTO publish_audit_log
+		(module_id, url, query_id, user_id, username, guild_id, approved_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.ModuleID, p.URL, p.QueryID, p.UserID, p.Username, p.GuildID, nullString(p.ApprovedBy))
+	return err
+}
+
+// Publications returns the audit log of published modules, most recent first.
+func Publications(db *sql.DB, limit int) ([]*Publication, error) {
+	rows, err := db.Query(`SELECT module_id, url, query_id, user_id, username, guild_id, approved_by, created_at
+		FROM publish_audit_log ORDER BY created_at DESC, id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pubs []*Publication
+	for rows.Next() {
+		var (
+			p          Publication
+			approvedBy sql.NullString
+		)
+		if err := rows.Scan(&p.ModuleID, &p.URL, &p.QueryID, &p.UserID, &p.Username, &p.GuildID, &approvedBy, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.ApprovedBy = approvedBy.String
+		pubs = append(pubs, &p)
+	}
+	return pubs, rows.Err()
+}
@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -11,10 +12,13 @@ import (
 	"github.com/jedib0t/go-pretty/v6/table"
 )
 
-func ReadDataTable(db *sql.DB, sqlQuery string, isCurrency bool) (string, error) {
-	p := message.NewPrinter(language.English)
+// Querier runs queries, e.g. a *sql.DB or a *sql.Conn.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
 
-	rows, err := db.Query(sqlQuery)
+func ReadDataTable(db Querier, sqlQuery string, isCurrency bool) (string, error) {
+	rows, err := db.QueryContext(context.Background(), sqlQuery)
 	if err != nil {
 		return "", fmt.Errorf("query: %v", err)
 	}
@@ -29,17 +33,13 @@ func ReadDataTable(db *sql.DB, sqlQuery string, isCurrency bool) (string, error)
 		return "", fmt.Errorf("getting column types: %v", err)
 	}
 
-	// Create a table writer and set column headers
-	tw := table.NewWriter()
-	header := make(table.Row, columnCount)
-	for i, columnName := range columnNames {
-		header[i] = fmt.Sprintf("%s (%s)", columnName, columnTypes[i].DatabaseTypeName())
+	types := make([]string, columnCount)
+	for i := range columnTypes {
+		types[i] = columnTypes[i].DatabaseTypeName()
 	}
-	tw.AppendHeader(header)
 
-	hasRows := false
+	var values [][]interface{}
 	for rows.Next() {
-		hasRows = true
 		columns := make([]interface{}, columnCount)
 		columnPointers := make([]interface{}, columnCount)
 
@@ -50,12 +50,36 @@ func ReadDataTable(db *sql.DB, sqlQuery string, isCurrency bool) (string, error)
 		if err := rows.Scan(columnPointers...); err != nil {
 			return "", fmt.Errorf("error scanning row: %v", err)
 		}
+		values = append(values, columns)
+	}
+	rows.Close()
 
-		var prefix string
-		if isCurrency {
-			prefix = "$"
-		}
-		row := make(table.Row, columnCount)
+	if len(values) == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return RenderDataTable(columnNames, types, values, isCurrency)
+}
+
+// RenderDataTable renders rows of values as ReadDataTable does, for results which didn't come from a
+// local query. Each column header is suffixed with the column's type, e.g. "amount (REAL)".
+func RenderDataTable(columnNames, types []string, values [][]interface{}, isCurrency bool) (string, error) {
+	p := message.NewPrinter(language.English)
+
+	// Create a table writer and set column headers
+	tw := table.NewWriter()
+	header := make(table.Row, len(columnNames))
+	for i, columnName := range columnNames {
+		header[i] = fmt.Sprintf("%s (%s)", columnName, types[i])
+	}
+	tw.AppendHeader(header)
+
+	var prefix string
+	if isCurrency {
+		prefix = "$"
+	}
+	for _, columns := range values {
+		row := make(table.Row, len(columns))
 		for i, column := range columns {
 			switch v := column.(type) {
 			case int, int32, int64:
@@ -72,11 +96,52 @@ func ReadDataTable(db *sql.DB, sqlQuery string, isCurrency bool) (string, error)
 		}
 		tw.AppendRow(row)
 	}
-	rows.Close()
 
-	if !hasRows {
-		return "", sql.ErrNoRows
+	return tw.Render(), nil
+}
+
+// ReadRows runs a query and returns its column names and raw row values, for callers that format
+// results themselves rather than as a rendered table. Text is returned as strings rather than bytes.
+func ReadRows(db Querier, sqlQuery string) ([]string, [][]interface{}, error) {
+	return ReadRowsContext(context.Background(), db, sqlQuery, 0)
+}
+
+// ReadRowsContext is ReadRows with a context bounding the query, reading at most limit rows, or all
+// of them if limit is 0.
+func ReadRowsContext(ctx context.Context, db Querier, sqlQuery string, limit int) ([]string, [][]interface{}, error) {
+	rows, err := db.QueryContext(ctx, sqlQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query: %v", err)
 	}
+	defer rows.Close()
 
-	return tw.Render(), nil
+	columnNames, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting columns: %v", err)
+	}
+
+	var values [][]interface{}
+	for (limit == 0 || len(values) < limit) && rows.Next() {
+		columns := make([]interface{}, len(columnNames))
+		columnPointers := make([]interface{}, len(columnNames))
+		for i := range columns {
+			columnPointers[i] = &columns[i]
+		}
+		if err := rows.Scan(columnPointers...); err != nil {
+			return nil, nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		for i, column := range columns {
+			if b, ok := column.([]byte); ok {
+				columns[i] = string(b)
+			}
+		}
+		values = append(values, columns)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil, sql.ErrNoRows
+	}
+	return columnNames, values, nil
 }
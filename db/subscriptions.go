@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Subscription re-runs a saved query in a Discord channel, on a cron schedule, whenever the table it
+// queries is re-ingested, or both.
+type Subscription struct {
+	ID        int64
+	QueryID   int64
+	UserID    string
+	GuildID   string
+	ChannelID string
+	// Schedule is a cron expression, or empty if the subscription only fires on re-ingest.
+	Schedule string
+	OnIngest bool
+	// LastResults are the results posted on the previous run, which the next run is compared to.
+	LastResults string
+	// TableFingerprint identifies the state of the queried table as of the previous run.
+	TableFingerprint string
+	LastRunAt        time.Time
+	NextRunAt        time.Time
+	CreatedAt        time.Time
+}
+
+const subscriptionColumns = `id, query_id, user_id, guild_id, channel_id, schedule, on_ingest, last_results,
+	table_fingerprint, last_run_at, next_run_at, created_at`
+
+// StoreSubscription creates a subscription, returning its ID.
+func StoreSubscription(db *sql.DB, s *Subscription) (int64, error) {
+	res, err := db.Exec(`INSERT INTO subscriptions
+		(query_id, user_id, guild_id, channel_id, schedule, on_ingest, last_results, table_fingerprint, next_run_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.QueryID, s.UserID, s.GuildID, s.ChannelID, nullString(s.Schedule), s.OnIngest, s.LastResults,
+		nullString(s.TableFingerprint), nullTime(s.NextRunAt))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetSubscription returns the subscription with the given ID, or nil if there isn't one.
+func GetSubscription(db *sql.DB, id int64) (*Subscription, error) {
+	subs, err := listSubscriptions(db, `SELECT `+subscriptionColumns+` FROM subscriptions WHERE id = ?`, id)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+// ChannelSubscriptions returns the subscriptions posting to a channel, oldest first.
+func ChannelSubscriptions(db *sql.DB, channelID string) ([]*Subscription, error) {
+	return listSubscriptions(db, `SELECT `+subscriptionColumns+` FROM subscriptions
+		WHERE channel_id = ? ORDER BY id`, channelID)
+}
+
+// AllSubscriptions returns every subscription, oldest first.
+func AllSubscriptions(db *sql.DB) ([]*Subscription, error) {
+	return listSubscriptions(db, `SELECT `+subscriptionColumns+` FROM subscriptions ORDER BY id`)
+}
+
+// RecordSubscriptionRun saves the results and table state from a run, along with when to run next.
+func RecordSubscriptionRun(db *sql.DB, s *Subscription) error {
+	_, err := db.Exec(`UPDATE subscriptions
+		SET last_results = ?, table_fingerprint = ?, last_run_at = ?, next_run_at = ?
+		WHERE id = ?`,
+		s.LastResults, nullString(s.TableFingerprint), nullTime(s.LastRunAt), nullTime(s.NextRunAt), s.ID)
+	return err
+}
+
+// DeleteSubscription cancels a subscription.
+func DeleteSubscription(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM subscriptions WHERE id = ?`, id)
+	return err
+}
+
+func listSubscriptions(db *sql.DB, query string, args ...interface{}) ([]*Subscription, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*Subscription
+	for rows.Next() {
+		var (
+			s                     Subscription
+			schedule, fingerprint sql.NullString
+			lastRunAt, nextRunAt  sql.NullTime
+		)
+		if err := rows.Scan(&s.ID, &s.QueryID, &s.UserID, &s.GuildID, &s.ChannelID, &schedule, &s.OnIngest,
+			&s.LastResults, &fingerprint, &lastRunAt, &nextRunAt, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Schedule = schedule.String
+		s.TableFingerprint = fingerprint.String
+		s.LastRunAt = lastRunAt.Time
+		s.NextRunAt = nextRunAt.Time
+		subs = append(subs, &s)
+	}
+	return subs, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
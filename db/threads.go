@@ -0,0 +1,45 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// Thread is a Discord thread spun off an answer, in which plain messages are treated as follow-up
+// questions. It tracks the latest answer so follow-ups build on it.
+type Thread struct {
+	ThreadID      string
+	TableName     string
+	LastQueryID   int64
+	LastChannelID string
+	LastMessageID string
+}
+
+// GetThread returns the thread with the given ID, or nil if it is not a TorontoBot thread.
+func GetThread(db *sql.DB, threadID string) (*Thread, error) {
+	row := db.QueryRow(`SELECT thread_id, table_name, last_query_id, last_channel_id, last_message_id
+		FROM discord_threads WHERE thread_id = ?`, threadID)
+
+	var t Thread
+	if err := row.Scan(&t.ThreadID, &t.TableName, &t.LastQueryID, &t.LastChannelID, &t.LastMessageID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// StoreThread creates or updates a thread's state.
+func StoreThread(db *sql.DB, t *Thread) error {
+	_, err := db.Exec(`INSERT INTO discord_threads
+		(thread_id, table_name, last_query_id, last_channel_id, last_message_id)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (thread_id) DO UPDATE SET
+			table_name = excluded.table_name,
+			last_query_id = excluded.last_query_id,
+			last_channel_id = excluded.last_channel_id,
+			last_message_id = excluded.last_message_id,
+			updated_at = CURRENT_TIMESTAMP`,
+		t.ThreadID, t.TableName, t.LastQueryID, t.LastChannelID, t.LastMessageID)
+	return err
+}
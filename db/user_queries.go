@@ -2,31 +2,44 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/geomodulus/torontobot/bot"
 )
 
 type UserQuery struct {
+	ID          int64
+	ParentID    int64
 	UserID      string
 	GuildID     string
 	ChannelID   string
 	Question    string
+	TableName   string
 	SQLResponse *bot.SQLResponse
 	Results     string
-	CreatedAt   time.Time
+	// Columns and Rows are the raw values of the results, where they were kept. Numbers are
+	// json.Numbers once stored.
+	Columns []string
+	Rows    [][]interface{}
+	// IsPublic queries are listed in the web UI and can be viewed there by anyone with the link.
+	IsPublic  bool
+	CreatedAt time.Time
 }
 
 func GetUserQuery(db *sql.DB, id string) (*UserQuery, error) {
-	query := `SELECT user_id, guild_id, channel_id, question, schema_comment, applicability, sql_query, results, created_at
+	query := `SELECT id, parent_id, user_id, guild_id, channel_id, question, table_name, schema_comment, applicability, sql_query, is_currency, results, result_rows, is_public, created_at
 		FROM user_queries WHERE id = ?`
 
 	row := db.QueryRow(query, id)
 
 	var uq UserQuery
 	var sqlResponse bot.SQLResponse
+	var parentID sql.NullInt64
+	var tableName, resultRows sql.NullString
 	uq.SQLResponse = &sqlResponse
-	err := row.Scan(&uq.UserID, &uq.GuildID, &uq.ChannelID, &uq.Question, &uq.SQLResponse.Schema, &uq.SQLResponse.Applicability, &uq.SQLResponse.SQL, &uq.Results, &uq.CreatedAt)
+	err := row.Scan(&uq.ID, &parentID, &uq.UserID, &uq.GuildID, &uq.ChannelID, &uq.Question, &tableName, &uq.SQLResponse.Schema, &uq.SQLResponse.Applicability, &uq.SQLResponse.SQL, &uq.SQLResponse.IsCurrency, &uq.Results, &resultRows, &uq.IsPublic, &uq.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No match found
@@ -34,19 +47,50 @@ func GetUserQuery(db *sql.DB, id string) (*UserQuery, error) {
 		}
 		return nil, err
 	}
+	uq.ParentID = parentID.Int64
+	uq.TableName = tableName.String
+	if resultRows.Valid {
+		var raw storedRows
+		dec := json.NewDecoder(strings.NewReader(resultRows.String))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		uq.Columns, uq.Rows = raw.Columns, raw.Rows
+	}
 
 	return &uq, nil
 }
+
+// storedRows is how the raw values of a query's results are stored.
+type storedRows struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
 func StoreUserQuery(db *sql.DB, uq *UserQuery) (int64, error) {
+	var resultRows sql.NullString
+	if uq.Columns != nil {
+		raw, err := json.Marshal(&storedRows{Columns: uq.Columns, Rows: uq.Rows})
+		if err != nil {
+			return 0, err
+		}
+		resultRows = sql.NullString{String: string(raw), Valid: true}
+	}
+
 	statement, err := db.Prepare(`INSERT INTO user_queries
-		(user_id, guild_id, channel_id, question, schema_comment, applicability, sql_query, results) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		(parent_id, user_id, guild_id, channel_id, question, table_name, schema_comment, applicability, sql_query, is_currency, results, result_rows, is_public)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return 0, err
 	}
 	defer statement.Close()
 
-	res, err := statement.Exec(uq.UserID, uq.GuildID, uq.ChannelID, uq.Question, uq.SQLResponse.Schema, uq.SQLResponse.Applicability, uq.SQLResponse.SQL, uq.Results)
+	var parentID sql.NullInt64
+	if uq.ParentID != 0 {
+		parentID = sql.NullInt64{Int64: uq.ParentID, Valid: true}
+	}
+	res, err := statement.Exec(parentID, uq.UserID, uq.GuildID, uq.ChannelID, uq.Question, uq.TableName, uq.SQLResponse.Schema, uq.SQLResponse.Applicability, uq.SQLResponse.SQL, uq.SQLResponse.IsCurrency, uq.Results, resultRows, uq.IsPublic)
 	if err != nil {
 		return 0, err
 	}
@@ -58,3 +102,67 @@ func StoreUserQuery(db *sql.DB, uq *UserQuery) (int64, error) {
 
 	return id, nil
 }
+
+// PopularQuestions returns the most frequently asked questions containing search, most popular
+// first. Questions whose answers were rated thumbs down more than up are left out.
+func PopularQuestions(db *sql.DB, search string, limit int) ([]string, error) {
+	rows, err := db.Query(`SELECT q.question
+		FROM user_queries q
+		LEFT JOIN (
+			SELECT query_id, SUM(rating) AS score FROM answer_feedback GROUP BY query_id
+		) f ON f.query_id = q.id
+		WHERE q.question LIKE '%' || ? || '%' AND q.parent_id IS NULL
+		GROUP BY q.question
+		HAVING COALESCE(SUM(f.score), 0) >= 0
+		ORDER BY COUNT(*) DESC, MAX(q.created_at) DESC
+		LIMIT ?`, search, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var questions []string
+	for rows.Next() {
+		var question string
+		if err := rows.Scan(&question); err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
+
+// RecentPublicQuery summarizes a public query for listing.
+type RecentPublicQuery struct {
+	ID        int64
+	Question  string
+	TableName string
+	CreatedAt time.Time
+}
+
+// RecentPublicQueries returns the latest public queries, most recent first.
+func RecentPublicQueries(db *sql.DB, limit int) ([]*RecentPublicQuery, error) {
+	rows, err := db.Query(`SELECT id, question, table_name, created_at
+		FROM user_queries
+		WHERE is_public
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queries []*RecentPublicQuery
+	for rows.Next() {
+		var (
+			q         RecentPublicQuery
+			tableName sql.NullString
+		)
+		if err := rows.Scan(&q.ID, &q.Question, &tableName, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		q.TableName = tableName.String
+		queries = append(queries, &q)
+	}
+	return queries, rows.Err()
+}
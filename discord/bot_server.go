@@ -19,9 +19,16 @@ type BotServer struct {
 	bot     *bot.TorontoBot
 	cmd     *discordgo.ApplicationCommand
 	db      *sql.DB
+	actions map[string]componentAction
+
+	publisherRoles    []string
+	moderatorRoles    []string
+	moderationChannel string
 }
 
-func OpenBotServer(db *sql.DB, token string, tb *bot.TorontoBot) (*BotServer, error) {
+type Option func(*BotServer)
+
+func OpenBotServer(db *sql.DB, token string, tb *bot.TorontoBot, options ...Option) (*BotServer, error) {
 	ds, err := discordgo.New("Bot " + token)
 	if err != nil {
 		return nil, fmt.Errorf("error creating Discord session: %v", err)
@@ -31,10 +38,20 @@ func OpenBotServer(db *sql.DB, token string, tb *bot.TorontoBot) (*BotServer, er
 		bot:     tb,
 		db:      db,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	s.actions = s.componentActions()
+	// Reading follow-up questions in threads requires the privileged message content intent, which
+	// must also be enabled for the bot in the Discord developer portal.
+	s.session.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentMessageContent
 	s.session.AddHandler(s.respondToDM)
+	s.session.AddHandler(s.respondInThread)
 	s.session.AddHandler(s.slashCommandHandler)
-	s.session.AddHandler(s.generatePNGHandler)
-	s.session.AddHandler(s.exportToWebHandler)
+	s.session.AddHandler(s.datasetsCommandHandler)
+	s.session.AddHandler(s.autocompleteHandler)
+	s.session.AddHandler(s.subscriptionCommandHandler)
+	s.session.AddHandler(s.componentRouter)
 	if err = s.session.Open(); err != nil {
 		return nil, fmt.Errorf("error opening Discord connection: %v", err)
 	}
@@ -43,10 +60,69 @@ func OpenBotServer(db *sql.DB, token string, tb *bot.TorontoBot) (*BotServer, er
 		Description: "Responds to questions about city of Toronto Open Data.",
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "question",
-				Description: "Question about Toronto open data",
-				Required:    true,
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "ask",
+				Description: "Ask a question about Toronto open data",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "question",
+						Description:  "Question about Toronto open data",
+						Required:     true,
+						Autocomplete: true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "thread",
+						Description: "Continue in a thread where you can ask follow-up questions",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "datasets",
+				Description: "List the datasets TorontoBot can answer questions about",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "subscribe",
+				Description: "Re-run an answer in this channel on a schedule or when its data is refreshed",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "query",
+						Description: "Query number, shown at the bottom of the answer",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "schedule",
+						Description: "Cron schedule in UTC, e.g. \"0 13 * * 1\" for Mondays at 13:00, or @daily",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "on_ingest",
+						Description: "Re-run whenever the underlying dataset is refreshed",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "subscriptions",
+				Description: "List subscriptions in this channel",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "unsubscribe",
+				Description: "Cancel a subscription in this channel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "id",
+						Description: "Subscription number, as listed by /torontobot subscriptions",
+						Required:    true,
+					},
+				},
 			},
 		},
 	})
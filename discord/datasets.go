@@ -0,0 +1,156 @@
+package discord
+
+import (
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	// examplesPerDataset is how many example questions are listed for each dataset.
+	examplesPerDataset = 3
+	// Discord limits on autocomplete suggestions.
+	maxAutocompleteChoices  = 25
+	maxAutocompleteValueLen = 100
+	// Discord allows at most 10 embeds per message.
+	maxEmbedsPerMessage = 10
+)
+
+// commandOptions returns the name of the invoked subcommand, if any, along with its options.
+func commandOptions(data discordgo.ApplicationCommandInteractionData) (string, []*discordgo.ApplicationCommandInteractionDataOption) {
+	for _, option := range data.Options {
+		if option.Type == discordgo.ApplicationCommandOptionSubCommand {
+			return option.Name, option.Options
+		}
+	}
+	return "", data.Options
+}
+
+// datasetsCommandHandler lists every dataset along with example questions known to work against it.
+func (s *BotServer) datasetsCommandHandler(ds *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	if subcommand, _ := commandOptions(i.ApplicationCommandData()); subcommand != "datasets" {
+		// Not the interaction we are looking for.
+		return
+	}
+
+	var embeds []*discordgo.MessageEmbed
+	for _, table := range s.bot.Tables() {
+		if len(embeds) == maxEmbedsPerMessage {
+			break
+		}
+		embed := &discordgo.MessageEmbed{
+			Title:       truncate(table.Name, maxEmbedTitleLen),
+			Description: truncate(table.Desc, maxEmbedFieldLen),
+			URL:         table.Source,
+			Color:       embedColor,
+		}
+		if refreshed := s.refreshedText(table.Name); refreshed != "" {
+			embed.Footer = &discordgo.MessageEmbedFooter{Text: refreshed}
+		}
+		if examples := s.exampleQuestions(table.Name, table.Examples); len(examples) > 0 {
+			embed.Fields = []*discordgo.MessageEmbedField{{
+				Name:  "Try asking",
+				Value: truncate("• "+strings.Join(examples, "\n• "), maxEmbedFieldLen),
+			}}
+		}
+		embeds = append(embeds, embed)
+	}
+
+	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Here's what I can answer questions about. Ask with `/torontobot ask`.",
+			Embeds:  embeds,
+		},
+	}); err != nil {
+		log.Println("Error responding with datasets:", err)
+	}
+}
+
+// exampleQuestions returns up to examplesPerDataset questions for a table, preferring questions that
+// users rated thumbs up and topping up with the curated examples.
+func (s *BotServer) exampleQuestions(tableName string, curated []string) []string {
+	examples, err := uq.KnownGoodQuestions(s.db, tableName, examplesPerDataset)
+	if err != nil {
+		log.Println("Error getting known good questions:", err)
+	}
+	return appendUnique(examples, examplesPerDataset, curated...)
+}
+
+// autocompleteHandler suggests questions as the user types: popular past questions first, then
+// curated examples from each dataset.
+func (s *BotServer) autocompleteHandler(ds *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+		return
+	}
+	var typed string
+	subcommand, options := commandOptions(i.ApplicationCommandData())
+	for _, option := range options {
+		if option.Name == "question" && option.Focused {
+			typed = option.StringValue()
+		}
+	}
+	if subcommand != "ask" {
+		// Not the interaction we are looking for.
+		return
+	}
+
+	suggestions, err := uq.PopularQuestions(s.db, typed, maxAutocompleteChoices)
+	if err != nil {
+		log.Println("Error getting popular questions:", err)
+	}
+	for _, table := range s.bot.Tables() {
+		for _, example := range table.Examples {
+			if strings.Contains(strings.ToLower(example), strings.ToLower(typed)) {
+				suggestions = appendUnique(suggestions, maxAutocompleteChoices, example)
+			}
+		}
+	}
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, suggestion := range suggestions {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		if len([]rune(suggestion)) > maxAutocompleteValueLen {
+			// Choice values can't be truncated without changing the question asked.
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  suggestion,
+			Value: suggestion,
+		})
+	}
+	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	}); err != nil {
+		log.Println("Error responding with suggestions:", err)
+	}
+}
+
+// appendUnique appends values to list, skipping duplicates, until list holds max entries.
+func appendUnique(list []string, max int, values ...string) []string {
+	seen := map[string]bool{}
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if len(list) >= max {
+			break
+		}
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,154 @@
+package discord
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	editSQLInputID = "sql"
+	// Discord limits text input values to 4000 characters.
+	maxTextInputLen = 4000
+)
+
+// editSQLAction opens a modal pre-filled with the SQL of the answer whose "Edit SQL" button was
+// clicked.
+func (s *BotServer) editSQLAction(c *component) error {
+	query, err := c.query(s.db, 0)
+	if err != nil {
+		return err
+	}
+
+	sqlQuery := query.SQLResponse.SQL
+	if len(sqlQuery) > maxTextInputLen {
+		sqlQuery = sqlQuery[:maxTextInputLen]
+	}
+	if err := c.respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: customID("editsql-submit", query.ID),
+			Title:    "Edit SQL",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  editSQLInputID,
+							Label:     "SQL query",
+							Style:     discordgo.TextInputParagraph,
+							Value:     sqlQuery,
+							Required:  true,
+							MaxLength: maxTextInputLen,
+						},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("opening edit SQL modal: %v", err)
+	}
+	return nil
+}
+
+// editSQLSubmitAction runs the SQL submitted through the edit modal, stores it as a new query
+// linked to the original and posts the results.
+func (s *BotServer) editSQLSubmitAction(c *component) error {
+	original, err := c.query(s.db, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.deferReply(); err != nil {
+		return err
+	}
+
+	sqlQuery := strings.TrimSpace(textInputValue(c.i.ModalSubmitData().Components, editSQLInputID))
+	out := fmt.Sprintf("Question: *%s*\n\nExecuting edited query `%s`", original.Question, sqlQuery)
+	if err := bot.ValidateReadOnly(sqlQuery); err != nil {
+		log.Println("Error validating edited query:", err)
+		c.reply(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(&userError{
+			msg: "Sorry, only a single read-only SELECT query can be run.",
+			err: err,
+		})))
+		return nil
+	}
+
+	resultsTable, err := s.bot.LoadResults(sqlQuery, original.SQLResponse.IsCurrency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.reply(fmt.Sprintf("%s\n\n**No results found for that query.** Try again?", out))
+		} else {
+			log.Println("Error running edited query:", err)
+			c.reply(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(&userError{
+				msg: "Sorry, that query didn't run. Check it and try again.",
+				err: err,
+			})))
+		}
+		return nil
+	}
+
+	user := interactionUser(c.i)
+	query := &uq.UserQuery{
+		ParentID:  original.ID,
+		UserID:    user.ID,
+		GuildID:   c.i.GuildID,
+		ChannelID: c.i.ChannelID,
+		Question:  original.Question,
+		TableName: original.TableName,
+		SQLResponse: &bot.SQLResponse{
+			Schema:        original.SQLResponse.Schema,
+			Applicability: fmt.Sprintf("SQL edited by %s.", user.Username),
+			SQL:           sqlQuery,
+			IsCurrency:    original.SQLResponse.IsCurrency,
+		},
+		Results: resultsTable,
+	}
+	id, err := uq.StoreUserQuery(s.db, query)
+	if err != nil {
+		return &userError{msg: "Sorry, I couldn't save the edited query.", err: err}
+	}
+	query.ID = id
+
+	embed, pages := s.answerEmbed(query, 0)
+	out = ""
+	components := s.answerComponents(id, c.i.GuildID != "", 0, pages)
+	if _, err := c.ds.InteractionResponseEdit(c.i.Interaction, &discordgo.WebhookEdit{
+		Content:    &out,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &components,
+	}); err != nil {
+		return fmt.Errorf("editing response: %v", err)
+	}
+	return nil
+}
+
+// textInputValue finds the value of the text input with the given ID among modal components.
+func textInputValue(components []discordgo.MessageComponent, customID string) string {
+	for _, component := range components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, rowComponent := range row.Components {
+			if input, ok := rowComponent.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
+
+// interactionUser returns the user behind an interaction, which is set on the member in guilds and
+// directly on the interaction in DMs.
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
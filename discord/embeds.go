@@ -0,0 +1,238 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	// rowsPerPage is how many result rows are shown in an answer embed before paginating.
+	rowsPerPage = 15
+	// maxKeyNumbers is the most inline fields used to highlight numbers from small results.
+	maxKeyNumbers = 6
+	// Discord limits on embed content.
+	maxEmbedTitleLen       = 256
+	maxEmbedDescriptionLen = 4096
+	maxEmbedFieldLen       = 1024
+	maxEmbedFooterLen      = 2048
+
+	embedColor = 0x1d4ed8
+)
+
+// columnType matches the "(TYPE)" suffix reader.ReadDataTable adds to column headers.
+var columnType = regexp.MustCompile(`\s*\([^)]*\)$`)
+
+// resultsTable is a rendered results table split into its header, rows and closing border so that
+// it can be shown a page at a time.
+type resultsTable struct {
+	header []string
+	rows   []string
+	footer string
+}
+
+func parseResultsTable(results string) *resultsTable {
+	lines := strings.Split(strings.TrimRight(results, "\n"), "\n")
+	// A rendered table is a top border, header, separator, at least one row and a bottom border.
+	if len(lines) < 5 {
+		return &resultsTable{rows: lines}
+	}
+	return &resultsTable{
+		header: lines[:3],
+		rows:   lines[3 : len(lines)-1],
+		footer: lines[len(lines)-1],
+	}
+}
+
+func (t *resultsTable) pages() int {
+	if len(t.rows) == 0 {
+		return 1
+	}
+	return (len(t.rows) + rowsPerPage - 1) / rowsPerPage
+}
+
+// page renders a single page of the table, clamping page to the available range.
+func (t *resultsTable) page(page int) string {
+	page = clampPage(page, t.pages())
+	end := (page + 1) * rowsPerPage
+	if end > len(t.rows) {
+		end = len(t.rows)
+	}
+	lines := append([]string{}, t.header...)
+	lines = append(lines, t.rows[page*rowsPerPage:end]...)
+	if t.footer != "" {
+		lines = append(lines, t.footer)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cells splits a rendered row into trimmed cell values.
+func cells(line string) []string {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(line), "|"), "|")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return parts
+}
+
+// keyNumbers picks out the numbers worth highlighting from small results: every column of a single
+// row, or the last column of each row labelled by the others.
+func (t *resultsTable) keyNumbers() []*discordgo.MessageEmbedField {
+	if len(t.header) < 2 || len(t.rows) == 0 || len(t.rows) > maxKeyNumbers {
+		return nil
+	}
+	header := cells(t.header[1])
+	for i, name := range header {
+		header[i] = columnType.ReplaceAllString(name, "")
+	}
+
+	var fields []*discordgo.MessageEmbedField
+	if len(t.rows) == 1 {
+		for i, value := range cells(t.rows[0]) {
+			if i >= len(header) || len(fields) == maxKeyNumbers {
+				break
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:   truncate(header[i], 256),
+				Value:  truncate(value, maxEmbedFieldLen),
+				Inline: true,
+			})
+		}
+		return fields
+	}
+	for _, row := range t.rows {
+		values := cells(row)
+		if len(values) < 2 {
+			return nil
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   truncate(strings.Join(values[:len(values)-1], " · "), 256),
+			Value:  truncate(values[len(values)-1], maxEmbedFieldLen),
+			Inline: true,
+		})
+	}
+	return fields
+}
+
+// answerEmbed renders a stored answer as an embed showing one page of its results. It returns the
+// embed along with the number of pages of results.
+func (s *BotServer) answerEmbed(query *uq.UserQuery, page int) (*discordgo.MessageEmbed, int) {
+	results := parseResultsTable(query.Results)
+	pages := results.pages()
+	page = clampPage(page, pages)
+
+	embed := &discordgo.MessageEmbed{
+		Title:       truncate(query.Question, maxEmbedTitleLen),
+		Description: "```" + truncate(results.page(page), maxEmbedDescriptionLen-6) + "```",
+		Color:       embedColor,
+		Fields:      results.keyNumbers(),
+	}
+	if query.SQLResponse.Applicability != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Analysis",
+			Value: truncate(query.SQLResponse.Applicability, maxEmbedFieldLen),
+		})
+	}
+	// Spoiler tags keep the SQL collapsed until clicked.
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:  "SQL",
+		Value: "||`" + truncate(query.SQLResponse.SQL, maxEmbedFieldLen-6) + "`||",
+	})
+
+	footer := []string{fmt.Sprintf("Query #%d", query.ID)}
+	if table, ok := s.bot.Table(query.TableName); ok {
+		footer = append(footer, "Dataset: "+table.Name)
+		if table.Source != "" {
+			embed.URL = table.Source
+			footer = append(footer, "Source: "+table.Source)
+		}
+		if refreshed := s.refreshedText(table.Name); refreshed != "" {
+			footer = append(footer, refreshed)
+		}
+	}
+	if pages > 1 {
+		footer = append(footer, fmt.Sprintf("Page %d of %d", page+1, pages))
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{
+		Text: truncate(strings.Join(footer, " • "), maxEmbedFooterLen),
+	}
+	return embed, pages
+}
+
+// pageRow returns previous and next buttons for paginating an answer's results.
+func pageRow(id int64, page, pages int) discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			&discordgo.Button{
+				Label:    "◀ Previous",
+				Style:    discordgo.SecondaryButton,
+				CustomID: customID("page", id, page-1),
+				Disabled: page <= 0,
+			},
+			&discordgo.Button{
+				Label:    "Next ▶",
+				Style:    discordgo.SecondaryButton,
+				CustomID: customID("page", id, page+1),
+				Disabled: page >= pages-1,
+			},
+		},
+	}
+}
+
+// pageAction shows another page of a stored answer's results in place.
+func (s *BotServer) pageAction(c *component) error {
+	query, err := c.query(s.db, 0)
+	if err != nil {
+		return err
+	}
+	page, err := c.intArg(1)
+	if err != nil {
+		return err
+	}
+
+	embed, pages := s.answerEmbed(query, int(page))
+	if err := c.respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{embed},
+			Components: s.answerComponents(query.ID, c.i.GuildID != "", clampPage(int(page), pages), pages),
+		},
+	}); err != nil {
+		return fmt.Errorf("updating page: %v", err)
+	}
+	return nil
+}
+
+func clampPage(page, pages int) int {
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	return page
+}
+
+// truncate shortens s to at most maxLen characters, which is how Discord measures its limits.
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen-3]) + "..."
+}
+
+// refreshedText describes when a table's data was last refreshed, or returns "" if it's not known.
+func (s *BotServer) refreshedText(tableName string) string {
+	refreshed, err := s.bot.LastRefreshed(tableName)
+	if err != nil {
+		log.Println("Error getting last refresh:", err)
+	}
+	return bot.RefreshedText(refreshed)
+}
@@ -0,0 +1,103 @@
+package discord
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const feedbackInputID = "comment"
+
+// feedbackAction records a thumbs up or thumbs down for an answer. A thumbs down also opens an
+// optional modal asking what was wrong.
+func (s *BotServer) feedbackAction(c *component) error {
+	id, err := c.intArg(0)
+	if err != nil {
+		return err
+	}
+	vote, err := c.arg(1)
+	if err != nil {
+		return err
+	}
+	rating := uq.RatingUp
+	if vote == "down" {
+		rating = uq.RatingDown
+	}
+
+	if err := uq.StoreFeedback(s.db, &uq.Feedback{
+		QueryID: id,
+		UserID:  interactionUser(c.i).ID,
+		Rating:  rating,
+	}); err != nil {
+		return &userError{msg: "Sorry, I couldn't save your feedback. Please try again.", err: err}
+	}
+
+	if rating == uq.RatingUp {
+		c.reply("Thanks for the feedback! 🙏")
+		return nil
+	}
+
+	if err := c.respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: customID("fb-comment", id),
+			Title:    "What was wrong?",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    feedbackInputID,
+							Label:       "What was wrong with this answer? (optional)",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: "e.g. wrong program, missing years, the numbers look off",
+							Required:    false,
+							MaxLength:   1000,
+						},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		return fmt.Errorf("opening feedback modal: %v", err)
+	}
+	return nil
+}
+
+// feedbackCommentAction saves the comment left in the "what was wrong" modal.
+func (s *BotServer) feedbackCommentAction(c *component) error {
+	id, err := c.intArg(0)
+	if err != nil {
+		return err
+	}
+
+	comment := strings.TrimSpace(textInputValue(c.i.ModalSubmitData().Components, feedbackInputID))
+	if comment != "" {
+		if err := uq.StoreFeedback(s.db, &uq.Feedback{
+			QueryID: id,
+			UserID:  interactionUser(c.i).ID,
+			Rating:  uq.RatingDown,
+			Comment: comment,
+		}); err != nil {
+			return &userError{msg: "Sorry, I couldn't save your comment. Please try again.", err: err}
+		}
+	}
+	c.reply("Thanks, that helps us improve TorontoBot! 🙏")
+	return nil
+}
+
+// respondEphemeral replies to an interaction with a message only the user who triggered it can see.
+func respondEphemeral(ds *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	}); err != nil {
+		log.Println("Error responding to interaction:", err)
+	}
+}
@@ -4,14 +4,13 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 
-	"github.com/geomodulus/citygraph"
+	"github.com/geomodulus/torontobot/bot"
 	uq "github.com/geomodulus/torontobot/db"
 	"github.com/geomodulus/torontobot/viz"
 )
@@ -20,11 +19,21 @@ func (s *BotServer) slashCommandHandler(ds *discordgo.Session, i *discordgo.Inte
 	if i.Type != discordgo.InteractionApplicationCommand {
 		return
 	}
-	var question string
-	for _, option := range i.ApplicationCommandData().Options {
-		if option.Name == "question" {
+	subcommand, options := commandOptions(i.ApplicationCommandData())
+	if subcommand != "ask" {
+		// Not the interaction we are looking for.
+		return
+	}
+	var (
+		question    string
+		startThread bool
+	)
+	for _, option := range options {
+		switch option.Name {
+		case "question":
 			question = option.StringValue()
-			break
+		case "thread":
+			startThread = option.BoolValue()
 		}
 	}
 	if question == "" {
@@ -35,106 +44,130 @@ func (s *BotServer) slashCommandHandler(ds *discordgo.Session, i *discordgo.Inte
 	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 	}); err != nil {
-		fmt.Println("Error sending deferred response:", err)
+		log.Println("Error sending deferred response:", err)
 		return
 	}
 
 	ctx := context.Background()
 	log.Printf("Received question: %s\n", question)
+	user := interactionUser(i)
 
-	// Select table then query
-	// table, _ := s.bot.SelectTable(ctx, question)
-	// sqlAnalysis, _ := s.bot.SQLAnalysis(ctx, table, question)
-	table, err := s.bot.SelectTable(ctx, question)
-	if err != nil {
-		errMsg := fmt.Sprintf("Error selecting table: %v", err)
-		_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &errMsg,
-		})
-		if err != nil {
-			log.Println("Error editing initial response:", err)
+	out := fmt.Sprintf("Question: *%s*", question)
+	edit := func(content string) {
+		if _, err := ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		}); err != nil {
+			log.Println("Error editing response:", err)
 		}
-		return
 	}
 
-	sqlAnalysis, err := s.bot.SQLAnalysis(ctx, table, question)
-	if err != nil {
-		errMsg := fmt.Sprintf("Error analyzing SQL query: %v", err)
-		_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &errMsg,
-		})
-		if err != nil {
-			log.Println("Error editing initial response:", err)
-		}
+	answer, err := s.bot.Ask(ctx, &bot.AskRequest{
+		Question: question,
+		User:     "discord:" + user.ID,
+		OnAnalysis: func(_ *bot.DataTable, sqlAnalysis *bot.SQLResponse) {
+			out = fmt.Sprintf(
+				"%s\n\n%s\n\nExecuting query `%s`",
+				out,
+				sqlAnalysis.Applicability,
+				sqlAnalysis.SQL)
+			edit(out)
+		},
+	})
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		edit(fmt.Sprintf("%s\n\nSorry, %v.", out, err))
+		return
+	case answer == nil:
+		log.Println("Error answering question:", err)
+		edit(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(err)))
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		edit(fmt.Sprintf("%s\n\n**No results found for that query.** Try again?", out))
+		return
+	case err != nil:
+		log.Println("Error answering question:", err)
+		edit(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(err)))
+		return
+	case answer.SQLResponse.MissingData != "":
+		edit(fmt.Sprintf("%s\n%s", out, answer.SQLResponse.MissingData))
 		return
 	}
+	table, sqlAnalysis, resultsTable := answer.Table, answer.SQLResponse, answer.Results
 
-	out := fmt.Sprintf("Question: *%s*", question)
-	if sqlAnalysis.MissingData != "" {
-		out = fmt.Sprintf(
-			"%s\n%s",
-			out,
-			sqlAnalysis.MissingData)
-		// Edit the original deferred response with the actual content
-		_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &out,
-		})
-		if err != nil {
-			log.Println("Error editing initial response:", err)
-		}
+	// Store query for subsequent charting and export,
+	query := &uq.UserQuery{
+		UserID:      user.ID,
+		GuildID:     i.GuildID,
+		ChannelID:   i.ChannelID,
+		Question:    question,
+		TableName:   table.Name,
+		SQLResponse: sqlAnalysis,
+		Results:     resultsTable,
+	}
+	id, err := uq.StoreUserQuery(s.db, query)
+	if err != nil {
+		log.Println("Error storing query:", err)
+		edit(fmt.Sprintf("%s\n\n```Error: could not save query.```", out))
 		return
 	}
+	query.ID = id
 
-	out = fmt.Sprintf(
-		"%s\n\n%s\n\nExecuting query `%s`",
-		out,
-		sqlAnalysis.Applicability,
-		sqlAnalysis.SQL)
-	_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &out,
+	embed, pages := s.answerEmbed(query, 0)
+	out = ""
+	components := s.answerComponents(id, i.GuildID != "", 0, pages)
+	msg, err := ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &out,
+		Embeds:     &[]*discordgo.MessageEmbed{embed},
+		Components: &components,
 	})
 	if err != nil {
 		log.Println("Error editing response:", err)
+		return
 	}
 
-	resultsTable, err := s.bot.LoadResults(sqlAnalysis.SQL, sqlAnalysis.IsCurrency)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			out = fmt.Sprintf("%s\n\n**No results found for that query.** Try again?", out)
-		} else {
-			out = fmt.Sprintf("%s\n\n```Error: %v```", out, err)
-		}
-		_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &out,
-		})
-		if err != nil {
-			log.Println("Error editing response:", err)
-		}
-		return
+	if startThread {
+		s.startThread(ds, msg, question, table.Name, id)
 	}
+}
 
-	// Store query for subsequent charting and export,
-	id, err := uq.StoreUserQuery(
-		s.db,
-		&uq.UserQuery{
-			i.Member.User.ID,
-			i.GuildID,
-			i.ChannelID,
-			question,
-			sqlAnalysis,
-			resultsTable,
-			time.Time{},
-		})
+// startThread spins an answer off into a thread where plain messages are answered as follow-up
+// questions.
+func (s *BotServer) startThread(ds *discordgo.Session, msg *discordgo.Message, question, tableName string, queryID int64) {
+	name := question
+	// Discord thread names are limited to 100 characters.
+	if len(name) > 100 {
+		name = name[:97] + "..."
+	}
+	thread, err := ds.MessageThreadStartComplex(msg.ChannelID, msg.ID, &discordgo.ThreadStart{
+		Name:                name,
+		AutoArchiveDuration: 1440,
+	})
 	if err != nil {
-		log.Println("Error storing query:", err)
+		log.Println("Error starting thread:", err)
+		return
+	}
+	if err := uq.StoreThread(s.db, &uq.Thread{
+		ThreadID:      thread.ID,
+		TableName:     tableName,
+		LastQueryID:   queryID,
+		LastChannelID: msg.ChannelID,
+		LastMessageID: msg.ID,
+	}); err != nil {
+		log.Println("Error storing thread:", err)
 		return
 	}
-	msg := resultsTable
-	maxLen := 2000 - len(out) - 32
-	if len(resultsTable) > maxLen {
-		msg = resultsTable[:maxLen-3] + "..."
+	if _, err := ds.ChannelMessageSend(
+		thread.ID,
+		"Ask follow-up questions about this answer right here in the thread.",
+	); err != nil {
+		log.Println("Error sending thread intro:", err)
 	}
-	out = fmt.Sprintf("%s\n\nQuery result:\n```%s```\n", out, msg)
+}
+
+// answerComponents returns the rows of buttons attached to every answer: actions on the first row,
+// pagination when the results span more than one page, and feedback last. Export is only offered in
+// guilds, where we know the member publishing the chart.
+func (s *BotServer) answerComponents(id int64, inGuild bool, page, pages int) []discordgo.MessageComponent {
 	buttons := []discordgo.MessageComponent{
 		&discordgo.Button{
 			Emoji: discordgo.ComponentEmoji{
@@ -142,342 +175,136 @@ func (s *BotServer) slashCommandHandler(ds *discordgo.Session, i *discordgo.Inte
 			},
 			Label:    "Generate chart",
 			Style:    discordgo.PrimaryButton,
-			CustomID: fmt.Sprintf("png-%d", id),
+			CustomID: customID("png", id),
 		},
 	}
-	if s.bot.HasGraphStore() {
+	if inGuild && s.bot.HasGraphStore() {
 		buttons = append(buttons, &discordgo.Button{
 			Emoji: discordgo.ComponentEmoji{
 				Name: "🌐",
 			},
 			Label:    "Export to Web",
 			Style:    discordgo.SecondaryButton,
-			CustomID: fmt.Sprintf("export-%d", id),
+			CustomID: customID("export", id),
 		})
 	}
-	_, err = ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &out,
-		Components: &[]discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: buttons,
-			},
+	buttons = append(buttons, &discordgo.Button{
+		Emoji: discordgo.ComponentEmoji{
+			Name: "✏️",
 		},
+		Label:    "Edit SQL",
+		Style:    discordgo.SecondaryButton,
+		CustomID: customID("editsql", id),
 	})
-	if err != nil {
-		log.Println("Error editing response:", err)
-	}
-
-}
-
-func (s *BotServer) generatePNGHandler(ds *discordgo.Session, i *discordgo.InteractionCreate) {
-	var buttonID string
-	if i.Type == discordgo.InteractionMessageComponent && i.MessageComponentData().ComponentType == discordgo.ButtonComponent {
-		if id := i.MessageComponentData().CustomID; strings.HasPrefix(id, "png-") {
-			buttonID = id
-		}
-	}
-	if buttonID == "" {
-		// Not the interaction we are looking for.
-		return
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: buttons,
+		},
 	}
-
-	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
-	}); err != nil {
-		fmt.Println("Error sending deferred response:", err)
-		return
+	if pages > 1 {
+		components = append(components, pageRow(id, page, pages))
 	}
+	return append(components, feedbackRow(id))
+}
 
-	// Disable the button
-	for j, component := range i.Message.Components {
-		if actionRow, ok := component.(*discordgo.ActionsRow); ok {
-			for k, buttonComponent := range actionRow.Components {
-				if button, ok := buttonComponent.(*discordgo.Button); ok {
-					if button.CustomID == buttonID {
-						// Disable the button
-						button.Disabled = true
-						actionRow.Components[k] = button
-						i.Message.Components[j] = actionRow
-						break
-					}
-				}
-
-			}
-		}
-	}
-	if _, err := ds.ChannelMessageEditComplex(
-		&discordgo.MessageEdit{
-			ID:      i.Message.ID,
-			Channel: i.ChannelID,
-			Content: &i.Message.Content,
-			// You can modify the embeds or other properties here as well.
-			// To remove the button, set the Components field to an empty slice.
-			Components: i.Message.Components,
-		}); err != nil {
-		fmt.Println("Error editing message:", err)
-		return
+// feedbackRow returns the thumbs up and thumbs down buttons for an answer.
+func feedbackRow(id int64) discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			&discordgo.Button{
+				Emoji: discordgo.ComponentEmoji{
+					Name: "👍",
+				},
+				Style:    discordgo.SecondaryButton,
+				CustomID: customID("fb", id, "up"),
+			},
+			&discordgo.Button{
+				Emoji: discordgo.ComponentEmoji{
+					Name: "👎",
+				},
+				Style:    discordgo.SecondaryButton,
+				CustomID: customID("fb", id, "down"),
+			},
+		},
 	}
+}
 
-	ctx := context.Background()
-	queryID := strings.TrimPrefix(buttonID, "png-")
-	query, err := uq.GetUserQuery(s.db, queryID)
+// chartAction replies with a chart of the answer whose "Generate chart" button was clicked.
+func (s *BotServer) chartAction(c *component) error {
+	query, err := c.query(s.db, 0)
 	if err != nil {
-		fmt.Println("Error getting query:", err)
-		return
+		return err
 	}
-
-	// Disable the button
-	for j, component := range i.Message.Components {
-		if actionRow, ok := component.(*discordgo.ActionsRow); ok {
-			for k, buttonComponent := range actionRow.Components {
-				if button, ok := buttonComponent.(*discordgo.Button); ok {
-					if button.CustomID == buttonID {
-						// Disable the button
-						button.Disabled = true
-						actionRow.Components[k] = button
-						i.Message.Components[j] = actionRow
-						break
-					}
-				}
-
-			}
-		}
-	}
-	if _, err := ds.ChannelMessageEditComplex(
-		&discordgo.MessageEdit{
-			ID:      i.Message.ID,
-			Channel: i.ChannelID,
-			Content: &i.Message.Content,
-			// You can modify the embeds or other properties here as well.
-			// To remove the button, set the Components field to an empty slice.
-			Components: i.Message.Components,
-		}); err != nil {
-		fmt.Println("Error editing message:", err)
-		return
+	if err := c.deferReply(); err != nil {
+		return err
 	}
+	c.disableButton()
 
-	chartSelected, err := s.bot.SelectChart(ctx, query.Question, query.Results)
+	out := "Here's my attempt at a chart! 📊"
+	embed, dsFile, err := s.chartEmbed(context.Background(), query)
 	if err != nil {
-		fmt.Println("Error selecting chart:", err)
-		return
-	}
-	switch strings.ToLower(chartSelected.Chart) {
-	case "bar":
-		chartHTML, err := viz.GenerateBarChartHTML(
-			chartSelected.Title,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-			false, // not dark mode
-			viz.WithFixedWidth(675),
-			viz.WithFixedHeight(750),
-		)
-		if err != nil {
-			fmt.Println("Error generating HTML:", err)
-			return
-		}
-		pngBytes, err := viz.ScreenshotHTML(
-			ctx,
-			chartHTML,
-			viz.WithWidth(675),
-			viz.WithHeight(750),
-		)
-		if err != nil {
-			fmt.Println("Error generating PNG:", err)
-			return
-		}
-
-		dsFile := &discordgo.File{
-			Name:   "chart.png",
-			Reader: bytes.NewReader(pngBytes),
-		}
-		out := "Here's my attempt at a chart! 📊"
-		if _, err := ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &out,
-			Files:   []*discordgo.File{dsFile},
-		}); err != nil {
-			fmt.Println("Error editing interaction response:", err)
-			return
-		}
-
-	default:
-		out := fmt.Sprintf("Ah you need a %s chart, but I can't make those yet. Soon 😈", chartSelected.Chart)
-		if _, err := ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &out,
-		}); err != nil {
-			fmt.Println("Error editing interaction response:", err)
+		var unsupported *bot.UnsupportedChartError
+		if errors.As(err, &unsupported) {
+			return &userError{msg: fmt.Sprintf("Ah you need a %s chart, but I can't make those yet. Soon 😈", unsupported.Chart)}
 		}
-
-		//case "line chart":
-		//case "pie chart":
-		//case "scatter plot":
-	}
-}
-
-func (s *BotServer) exportToWebHandler(ds *discordgo.Session, i *discordgo.InteractionCreate) {
-	var buttonID string
-	if i.Type == discordgo.InteractionMessageComponent && i.MessageComponentData().ComponentType == discordgo.ButtonComponent {
-		if id := i.MessageComponentData().CustomID; strings.HasPrefix(id, "export") {
-			buttonID = id
-		}
-	}
-	if buttonID == "" {
-		// Not the interaction we are looking for.
-		return
-	}
-	if !s.bot.HasGraphStore() {
-		return
+		return &userError{msg: "Sorry, I couldn't draw a chart for that answer.", err: err}
 	}
-
-	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	if _, err := c.ds.InteractionResponseEdit(c.i.Interaction, &discordgo.WebhookEdit{
+		Content: &out,
+		Embeds:  &[]*discordgo.MessageEmbed{embed},
+		Files:   []*discordgo.File{dsFile},
 	}); err != nil {
-		fmt.Println("Error sending deferred response:", err)
-		return
-	}
-
-	// Disable the button
-	for j, component := range i.Message.Components {
-		if actionRow, ok := component.(*discordgo.ActionsRow); ok {
-			for k, buttonComponent := range actionRow.Components {
-				if button, ok := buttonComponent.(*discordgo.Button); ok {
-					if button.CustomID == buttonID {
-						// Disable the button
-						button.Disabled = true
-						actionRow.Components[k] = button
-						i.Message.Components[j] = actionRow
-						break
-					}
-				}
-
-			}
-		}
-	}
-	if _, err := ds.ChannelMessageEditComplex(
-		&discordgo.MessageEdit{
-			ID:      i.Message.ID,
-			Channel: i.ChannelID,
-			Content: &i.Message.Content,
-			// You can modify the embeds or other properties here as well.
-			// To remove the button, set the Components field to an empty slice.
-			Components: i.Message.Components,
-		}); err != nil {
-		fmt.Println("Error editing message:", err)
-		return
-	}
-
-	ctx := context.Background()
-	queryID := strings.TrimPrefix(buttonID, "export-")
-	query, err := uq.GetUserQuery(s.db, queryID)
-	if err != nil {
-		fmt.Println("Error getting query:", err)
-		return
+		return fmt.Errorf("editing interaction response: %v", err)
 	}
+	return nil
+}
 
+// chartEmbed charts a stored answer as a PNG, returning an embed showing the chart along with the
+// file to attach to the message.
+func (s *BotServer) chartEmbed(ctx context.Context, query *uq.UserQuery) (*discordgo.MessageEmbed, *discordgo.File, error) {
 	chartSelected, err := s.bot.SelectChart(ctx, query.Question, query.Results)
 	if err != nil {
-		fmt.Println("Error selecting chart:", err)
-		return
+		return nil, nil, fmt.Errorf("selecting chart: %v", err)
 	}
-
-	var js, featureImageURL string
-
-	id := citygraph.NewID().String()
-
-	switch strings.ToLower(chartSelected.Chart) {
-	case "bar chart":
-		js, err = viz.GenerateBarChartJS(
-			"#torontobot-chart",
-			chartSelected.Title,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-			viz.WithBreakpointWidth())
-		if err != nil {
-			fmt.Println("Error generating JS:", err)
-			return
-		}
-
-		chartHTML, err := viz.GenerateBarChartHTML(
-			chartSelected.Title,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-			true, //  yes to dark mode
-			viz.WithFixedWidth(800),
-			viz.WithFixedHeight(750),
-		)
-		if err != nil {
-			fmt.Println("Error generating HTML:", err)
-			return
-		}
-		featureImageURL, err = viz.GenerateAndUploadFeatureImage(
-			ctx,
-			id,
-			chartSelected.Title,
-			chartHTML,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-		)
-		if err != nil {
-			fmt.Println("Error generating feature image:", err)
-			return
-		}
-
-	case "pie chart":
-		js, err = viz.GeneratePieChartJS(
-			"#torontobot-chart",
-			chartSelected.Title,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-			viz.WithBreakpointWidth())
-		if err != nil {
-			fmt.Println("Error generating JS:", err)
-			return
-		}
-		id := citygraph.NewID().String()
-
-		chartHTML, err := viz.GeneratePieChartHTML(
-			chartSelected.Title,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-			true, //  yes to dark mode
-			viz.WithFixedWidth(800),
-			viz.WithFixedHeight(750),
-		)
-		if err != nil {
-			fmt.Println("Error generating HTML:", err)
-			return
-		}
-		featureImageURL, err = viz.GenerateAndUploadFeatureImage(
-			ctx,
-			id,
-			chartSelected.Title,
-			chartHTML,
-			chartSelected.Data,
-			chartSelected.ValueIsCurrency,
-		)
-		if err != nil {
-			fmt.Println("Error generating feature image:", err)
-			return
-		}
+	chartHTML, err := chartSelected.HTML(
+		false, // not dark mode
+		viz.WithFixedWidth(675),
+		viz.WithFixedHeight(750),
+	)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	modPath, err := s.bot.SaveToGraph(
+	pngBytes, err := viz.ScreenshotHTML(
 		ctx,
-		id,
-		query.Question,
-		viz.RenderBody(query.Question, query.SQLResponse.Schema, query.SQLResponse.Applicability, query.SQLResponse.SQL),
-		js,
-		featureImageURL,
-		i.Member.User.Username)
+		chartHTML,
+		viz.WithWidth(675),
+		viz.WithHeight(750),
+	)
 	if err != nil {
-		fmt.Println("Error saving to graph:", err)
-		return
+		return nil, nil, fmt.Errorf("generating PNG: %v", err)
 	}
 
-	res := fmt.Sprintf("Published chart at %s\n", s.bot.Hostname+modPath)
-	if _, err := ds.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-		Content: &res,
-	}); err != nil {
-		fmt.Println("Error editing follow-up message:", err)
-		return
+	dsFile := &discordgo.File{
+		Name:   "chart.png",
+		Reader: bytes.NewReader(pngBytes),
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       truncate(chartSelected.Title, maxEmbedTitleLen),
+		Description: truncate(query.Question, maxEmbedDescriptionLen),
+		Color:       embedColor,
+		Image: &discordgo.MessageEmbedImage{
+			URL: "attachment://" + dsFile.Name,
+		},
+	}
+	if table, ok := s.bot.Table(query.TableName); ok {
+		embed.URL = table.Source
+		footer := "Dataset: " + table.Name + " • Source: " + table.Source
+		if refreshed := s.refreshedText(table.Name); refreshed != "" {
+			footer += " • " + refreshed
+		}
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: truncate(footer, maxEmbedFooterLen),
+		}
 	}
+	return embed, dsFile, nil
 }
@@ -0,0 +1,143 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+// discordRequest is a request made to the Discord API.
+type discordRequest struct {
+	method, path string
+	body         map[string]interface{}
+}
+
+// fakeDiscord is an http.RoundTripper standing in for the Discord API, recording the requests made
+// to it and answering each with a message.
+type fakeDiscord struct {
+	mu       sync.Mutex
+	requests []discordRequest
+}
+
+func (f *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := discordRequest{method: req.Method, path: req.URL.Path}
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal(b, &r.body)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id": "2000", "channel_id": "3000"}`)),
+		Request:    req,
+	}, nil
+}
+
+// askInteraction returns a /torontobot ask command asking question in a guild.
+func askInteraction(question string) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "1000",
+		AppID:     "app",
+		Token:     "token",
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   GuildID,
+		ChannelID: "3000",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "4000", Username: "alice"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "torontobot",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+				Name: "ask",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+					Name:  "question",
+					Type:  discordgo.ApplicationCommandOptionString,
+					Value: question,
+				}},
+			}},
+		},
+	}}
+}
+
+func TestSlashCommandAsk(t *testing.T) {
+	db := testutil.DB(t)
+	if _, err := db.Exec(`INSERT INTO service_requests (year, ward) VALUES
+		(2022, 'Davenport'), (2022, 'Davenport'), (2022, 'Davenport'),
+		(2022, 'Spadina-Fort York'), (2022, 'Spadina-Fort York'),
+		(2022, 'Beaches-East York'), (2021, 'Beaches-East York'), (2021, 'Beaches-East York')`); err != nil {
+		t.Fatal(err)
+	}
+	tb, err := bot.New(context.Background(), db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDiscord{}
+	ds.Client = &http.Client{Transport: fake}
+	s := &BotServer{session: ds, bot: tb, db: db}
+	s.actions = s.componentActions()
+
+	question := "Which wards had the most 311 service requests in 2022?"
+	s.slashCommandHandler(ds, askInteraction(question))
+
+	if len(fake.requests) < 3 {
+		t.Fatalf("got %d Discord requests, want a deferred response and edits: %+v", len(fake.requests), fake.requests)
+	}
+	deferred := fake.requests[0]
+	if deferred.method != http.MethodPost || !strings.HasSuffix(deferred.path, "/interactions/1000/token/callback") {
+		t.Errorf("first request was %s %s, want the deferred response", deferred.method, deferred.path)
+	}
+	progress := fake.requests[1]
+	if content, _ := progress.body["content"].(string); !strings.Contains(content, "Executing query `SELECT ward, COUNT(*) AS request_count") {
+		t.Errorf("progress edit = %q, want it to show the query being executed", content)
+	}
+
+	last := fake.requests[len(fake.requests)-1]
+	if last.method != http.MethodPatch || !strings.HasSuffix(last.path, "/webhooks/app/token/messages/@original") {
+		t.Fatalf("last request was %s %s, want an edit of the response", last.method, last.path)
+	}
+	embeds, _ := last.body["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("answer has %d embeds, want 1", len(embeds))
+	}
+	embed := embeds[0].(map[string]interface{})
+	if embed["title"] != question {
+		t.Errorf("embed title = %v, want the question", embed["title"])
+	}
+	description, _ := embed["description"].(string)
+	for _, ward := range []string{"Davenport", "Spadina-Fort York", "Beaches-East York"} {
+		if !strings.Contains(description, ward) {
+			t.Errorf("embed description doesn't contain %s:\n%s", ward, description)
+		}
+	}
+	if components, _ := last.body["components"].([]interface{}); len(components) == 0 {
+		t.Error("answer has no buttons")
+	}
+
+	// The answer is stored for the buttons to act on.
+	query, err := uq.GetUserQuery(db, "1")
+	if err != nil || query == nil {
+		t.Fatalf("getting stored query: %v, %v", query, err)
+	}
+	if query.Question != question || query.TableName != "service_requests" || query.UserID != "4000" {
+		t.Errorf("stored query = %+v", query)
+	}
+}
@@ -3,118 +3,181 @@ package discord
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 
 	"github.com/bwmarrin/discordgo"
 
+	"github.com/geomodulus/torontobot/bot"
 	uq "github.com/geomodulus/torontobot/db"
 )
 
+// maxFollowUpHistory is how many prior exchanges in a thread are given as context to a follow-up.
+const maxFollowUpHistory = 3
+
 func (s *BotServer) respondToDM(ds *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == ds.State.User.ID || m.GuildID != "" {
 		// Don't respond to ourselves. Don't respond to messages in guilds, only DMs.
 		return
 	}
 
-	ctx := context.Background()
-	question := m.Content
-	log.Printf("Received question: %s\n", question)
+	s.answerMessage(ds, m, nil)
+}
 
-	table, err := s.bot.SelectTable(ctx, question)
-	if err != nil {
-		if _, err := ds.ChannelMessageSend(
-			m.ChannelID,
-			fmt.Sprintf("Error selecting table: %v", err),
-		); err != nil {
-			log.Println("Error sending response:", err)
-		}
+// respondInThread treats messages in threads spun off an answer as follow-up questions to the latest
+// answer in the thread.
+func (s *BotServer) respondInThread(ds *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.ID == ds.State.User.ID || m.Author.Bot || m.GuildID == "" || m.Content == "" {
+		// Don't respond to ourselves or other bots. Don't respond to DMs, those are handled above.
 		return
 	}
 
-	sqlAnalysis, err := s.bot.SQLAnalysis(ctx, table, question)
+	thread, err := uq.GetThread(s.db, m.ChannelID)
 	if err != nil {
-		if _, err := ds.ChannelMessageSend(
-			m.ChannelID,
-			fmt.Sprintf("Error analyzing SQL query: %v", err),
-		); err != nil {
-			log.Println("Error sending response:", err)
-		}
+		log.Println("Error getting thread:", err)
+		return
+	}
+	if thread == nil {
+		// Not a TorontoBot thread.
 		return
 	}
 
-	out := ""
-	if sqlAnalysis.MissingData != "" {
-		out = sqlAnalysis.MissingData
-		// Send response
-		if _, err := ds.ChannelMessageSend(m.ChannelID, out); err != nil {
-			log.Println("Error sending response:", err)
-		}
+	prior, err := uq.GetUserQuery(s.db, strconv.FormatInt(thread.LastQueryID, 10))
+	if err != nil || prior == nil {
+		log.Printf("Error getting last query %d in thread %s: %v\n", thread.LastQueryID, thread.ThreadID, err)
 		return
 	}
 
-	out = fmt.Sprintf(
-		"%s\n\nExecuting query `%s`",
-		sqlAnalysis.Applicability,
-		sqlAnalysis.SQL)
-	if _, err := ds.ChannelMessageSend(m.ChannelID, out); err != nil {
-		log.Println("Error sending response:", err)
+	msg, id := s.answerMessage(ds, m, prior)
+	if msg == nil {
+		return
 	}
 
-	resultsTable, err := s.bot.LoadResults(sqlAnalysis.SQL, sqlAnalysis.IsCurrency)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			out = fmt.Sprintf("%s\n\n**No results found for that query.** Try again?", out)
-		} else {
-			out = fmt.Sprintf("%s\n\n```Error: %v```", out, err)
-		}
-		if _, err := ds.ChannelMessageSend(m.ChannelID, out); err != nil {
+	// Chart and export buttons only apply to the latest answer in the thread. Embeds are replaced on
+	// every edit, so the previous answer's are sent back unchanged.
+	if previous, err := ds.ChannelMessage(thread.LastChannelID, thread.LastMessageID); err != nil {
+		log.Println("Error getting previous answer:", err)
+	} else if _, err := ds.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         thread.LastMessageID,
+		Channel:    thread.LastChannelID,
+		Embeds:     previous.Embeds,
+		Components: []discordgo.MessageComponent{feedbackRow(thread.LastQueryID)},
+	}); err != nil {
+		log.Println("Error removing buttons from previous answer:", err)
+	}
+
+	thread.LastQueryID = id
+	thread.LastChannelID = msg.ChannelID
+	thread.LastMessageID = msg.ID
+	if err := uq.StoreThread(s.db, thread); err != nil {
+		log.Println("Error storing thread:", err)
+	}
+}
+
+// answerMessage answers the question in m in the same channel. When prior is set the question is
+// treated as a follow-up: it is asked of the same table with the previous SQL as context. It returns
+// the message containing the results and the ID of the stored query, or nil if no results were
+// posted.
+func (s *BotServer) answerMessage(ds *discordgo.Session, m *discordgo.MessageCreate, prior *uq.UserQuery) (*discordgo.Message, int64) {
+	ctx := context.Background()
+	question := m.Content
+	log.Printf("Received question: %s\n", question)
+
+	send := func(content string) {
+		if _, err := ds.ChannelMessageSend(m.ChannelID, content); err != nil {
 			log.Println("Error sending response:", err)
 		}
-		return
 	}
 
+	req := &bot.AskRequest{
+		Question: question,
+		User:     "discord:" + m.Author.ID,
+		OnAnalysis: func(_ *bot.DataTable, sqlAnalysis *bot.SQLResponse) {
+			send(fmt.Sprintf(
+				"%s\n\nExecuting query `%s`",
+				sqlAnalysis.Applicability,
+				sqlAnalysis.SQL))
+		},
+	}
+	if prior != nil {
+		req.Table, _ = s.bot.Table(prior.TableName)
+		req.History = s.priorExchanges(prior)
+	}
+	answer, err := s.bot.Ask(ctx, req)
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		send(fmt.Sprintf("Sorry, %v.", err))
+		return nil, 0
+	case answer == nil:
+		log.Println("Error answering question:", err)
+		send("⚠️ " + userMessage(err))
+		return nil, 0
+	case errors.Is(err, sql.ErrNoRows):
+		send("**No results found for that query.** Try again?")
+		return nil, 0
+	case err != nil:
+		log.Println("Error answering question:", err)
+		send("⚠️ " + userMessage(err))
+		return nil, 0
+	case answer.SQLResponse.MissingData != "":
+		send(answer.SQLResponse.MissingData)
+		return nil, 0
+	}
+	table, sqlAnalysis, resultsTable := answer.Table, answer.SQLResponse, answer.Results
+
 	// Store query for subsequent charting and export,
-	id, err := uq.StoreUserQuery(
-		s.db,
-		&uq.UserQuery{
-			UserID:      m.Author.ID,
-			ChannelID:   m.ChannelID,
-			Question:    question,
-			SQLResponse: sqlAnalysis,
-			Results:     resultsTable,
-		})
+	query := &uq.UserQuery{
+		UserID:      m.Author.ID,
+		GuildID:     m.GuildID,
+		ChannelID:   m.ChannelID,
+		Question:    question,
+		TableName:   table.Name,
+		SQLResponse: sqlAnalysis,
+		Results:     resultsTable,
+	}
+	if prior != nil {
+		query.ParentID = prior.ID
+	}
+	id, err := uq.StoreUserQuery(s.db, query)
 	if err != nil {
 		log.Println("Error storing query:", err)
-		return
-	}
-	msg := resultsTable
-	maxLen := 2000 - len(out) - 32
-	if len(resultsTable) > maxLen {
-		msg = resultsTable[:maxLen-3] + "..."
-	}
-	out = fmt.Sprintf("Query result:\n```%s```\n", msg)
-	buttons := []discordgo.MessageComponent{
-		&discordgo.Button{
-			Emoji: discordgo.ComponentEmoji{
-				Name: "📊",
-			},
-			Label:    "Generate chart",
-			Style:    discordgo.PrimaryButton,
-			CustomID: fmt.Sprintf("png-%d", id),
-		},
+		send("```Error: could not save query.```")
+		return nil, 0
 	}
-	if _, err := ds.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-		Content: out,
-		Components: []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: buttons,
-			},
-		},
-	}); err != nil {
+	query.ID = id
+
+	embed, pages := s.answerEmbed(query, 0)
+	sent, err := ds.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: s.answerComponents(id, m.GuildID != "", 0, pages),
+	})
+	if err != nil {
 		log.Println("Error sending response:", err)
+		return nil, 0
 	}
-	if err != nil {
-		log.Println("Error editing response:", err)
+	return sent, id
+}
+
+// priorExchanges walks back from the latest query in a conversation, returning up to
+// maxFollowUpHistory exchanges oldest first.
+func (s *BotServer) priorExchanges(latest *uq.UserQuery) []*bot.PriorExchange {
+	var history []*bot.PriorExchange
+	for query := latest; query != nil && len(history) < maxFollowUpHistory; {
+		history = append([]*bot.PriorExchange{{
+			Question: query.Question,
+			SQL:      query.SQLResponse.SQL,
+		}}, history...)
+		if query.ParentID == 0 {
+			break
+		}
+		parent, err := uq.GetUserQuery(s.db, strconv.FormatInt(query.ParentID, 10))
+		if err != nil {
+			log.Println("Error getting parent query:", err)
+			break
+		}
+		query = parent
 	}
+	return history
 }
@@ -0,0 +1,250 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+// WithPublisherRoles limits publishing to the web to members with one of the given role IDs.
+// Exports by anyone else are held for approval by a moderator. Without publisher roles, any member
+// may publish.
+func WithPublisherRoles(roleIDs ...string) Option {
+	return func(s *BotServer) {
+		s.publisherRoles = roleIDs
+	}
+}
+
+// WithModeratorRoles allows members with one of the given role IDs to approve exports held for
+// moderation. Members who can manage the server are always moderators.
+func WithModeratorRoles(roleIDs ...string) Option {
+	return func(s *BotServer) {
+		s.moderatorRoles = roleIDs
+	}
+}
+
+// WithModerationChannel posts exports held for approval to the given channel, rather than the
+// channel they were requested in.
+func WithModerationChannel(channelID string) Option {
+	return func(s *BotServer) {
+		s.moderationChannel = channelID
+	}
+}
+
+// canPublish reports whether a member may publish to the web without approval.
+func (s *BotServer) canPublish(m *discordgo.Member) bool {
+	return len(s.publisherRoles) == 0 || hasAnyRole(m, s.publisherRoles) || s.canModerate(m)
+}
+
+// canModerate reports whether a member may approve or reject exports held for moderation.
+func (s *BotServer) canModerate(m *discordgo.Member) bool {
+	if m == nil {
+		return false
+	}
+	return m.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 ||
+		hasAnyRole(m, s.moderatorRoles)
+}
+
+func hasAnyRole(m *discordgo.Member, roleIDs []string) bool {
+	if m == nil {
+		return false
+	}
+	for _, have := range m.Roles {
+		for _, want := range roleIDs {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exportAction publishes the answer whose "Export to Web" button was clicked as an interactive
+// chart on the web, or queues it for a moderator if the member isn't allowed to publish.
+func (s *BotServer) exportAction(c *component) error {
+	if !s.bot.HasGraphStore() || c.i.Member == nil {
+		return &userError{msg: "Sorry, exporting to the web isn't available here."}
+	}
+	query, err := c.query(s.db, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.deferReply(); err != nil {
+		return err
+	}
+	c.disableButton()
+
+	user := c.i.Member.User
+	if !s.canPublish(c.i.Member) {
+		return s.requestApproval(c, query, user)
+	}
+
+	url, err := s.publish(context.Background(), query, user.ID, user.Username, c.i.GuildID, "")
+	if err != nil {
+		return err
+	}
+	c.reply(fmt.Sprintf("Published chart at %s\n", url))
+	return nil
+}
+
+// requestApproval queues an export and asks moderators to approve or reject it.
+func (s *BotServer) requestApproval(c *component, query *uq.UserQuery, user *discordgo.User) error {
+	id, err := uq.StorePublishRequest(s.db, &uq.PublishRequest{
+		QueryID:   query.ID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		GuildID:   c.i.GuildID,
+		ChannelID: c.i.ChannelID,
+	})
+	if err != nil {
+		return &userError{msg: "Sorry, I couldn't send your chart for approval.", err: err}
+	}
+
+	channelID := s.moderationChannel
+	if channelID == "" {
+		channelID = c.i.ChannelID
+	}
+	embed, _ := s.answerEmbed(query, 0)
+	if _, err := c.ds.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("📝 <@%s> would like to publish this answer to the web. Moderators, approve?", user.ID),
+		Embeds:  []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					&discordgo.Button{
+						Label:    "Approve",
+						Style:    discordgo.SuccessButton,
+						CustomID: customID("publish-approve", id),
+					},
+					&discordgo.Button{
+						Label:    "Reject",
+						Style:    discordgo.DangerButton,
+						CustomID: customID("publish-reject", id),
+					},
+				},
+			},
+		},
+		// Don't ping the requester from the moderation queue.
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}); err != nil {
+		return &userError{msg: "Sorry, I couldn't send your chart for approval.", err: err}
+	}
+
+	c.reply("Thanks! Your chart has been sent to the moderators for approval before it's published.")
+	return nil
+}
+
+// reviewAction approves or rejects an export held for moderation.
+func (s *BotServer) reviewAction(approve bool) componentAction {
+	return func(c *component) error {
+		if !s.canModerate(c.i.Member) {
+			return &userError{msg: "Only moderators can approve or reject exports."}
+		}
+		id, err := c.intArg(0)
+		if err != nil {
+			return err
+		}
+		req, err := uq.GetPublishRequest(s.db, id)
+		if err != nil || req == nil {
+			return &userError{msg: "Sorry, I couldn't find that request anymore.", err: err}
+		}
+		// Moderators of one guild can't review exports queued in another.
+		if req.GuildID != c.i.GuildID {
+			return &userError{msg: "Sorry, that request belongs to another server."}
+		}
+		status := uq.PublishRejected
+		if approve {
+			status = uq.PublishApproved
+		}
+		moderator := c.i.Member.User
+		ok, err := uq.ReviewPublishRequest(s.db, id, status, moderator.ID)
+		if err != nil {
+			return &userError{msg: "Sorry, I couldn't update that request.", err: err}
+		}
+		if !ok {
+			return &userError{msg: "Another moderator has already reviewed this request."}
+		}
+
+		// Publishing takes a while, so acknowledge the click before updating the queue message.
+		if err := c.respond(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredMessageUpdate,
+		}); err != nil {
+			return fmt.Errorf("deferring update: %v", err)
+		}
+		setButtonsDisabled(c.i.Message.Components, true)
+
+		var outcome, notice string
+		if approve {
+			query, err := uq.GetUserQuery(s.db, strconv.FormatInt(req.QueryID, 10))
+			if err == nil && query == nil {
+				err = fmt.Errorf("query %d not found", req.QueryID)
+			}
+			var url string
+			if err == nil {
+				url, err = s.publish(context.Background(), query, req.UserID, req.Username, req.GuildID, moderator.ID)
+			}
+			if err != nil {
+				if err := uq.ReopenPublishRequest(s.db, id); err != nil {
+					log.Println("Error reopening publish request:", err)
+				}
+				return err
+			}
+			outcome = fmt.Sprintf("✅ Approved by <@%s> and published at %s", moderator.ID, url)
+			notice = fmt.Sprintf("<@%s> your chart was approved and published at %s", req.UserID, url)
+		} else {
+			outcome = fmt.Sprintf("❌ Rejected by <@%s>", moderator.ID)
+			notice = fmt.Sprintf("<@%s> sorry, a moderator decided not to publish your chart.", req.UserID)
+		}
+
+		content := c.i.Message.Content + "\n" + outcome
+		if _, err := c.ds.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:              c.i.Message.ID,
+			Channel:         c.i.Message.ChannelID,
+			Content:         &content,
+			Embeds:          c.i.Message.Embeds,
+			Components:      c.i.Message.Components,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			log.Println("Error updating moderation message:", err)
+		}
+		if _, err := c.ds.ChannelMessageSend(req.ChannelID, notice); err != nil {
+			log.Println("Error notifying requester:", err)
+		}
+		return nil
+	}
+}
+
+// publish charts a stored answer and saves it to the graph as a module on the web, recording it in
+// the audit log. approvedBy is the moderator who approved it, if it needed approval. It returns the
+// URL of the published module.
+func (s *BotServer) publish(ctx context.Context, query *uq.UserQuery, userID, username, guildID, approvedBy string) (string, error) {
+	pub, err := s.bot.Publish(ctx, query.Question, query.SQLResponse, query.Results, username)
+	if err != nil {
+		var unsupported *bot.UnsupportedChartError
+		if errors.As(err, &unsupported) {
+			return "", &userError{msg: fmt.Sprintf("Ah you need a %s chart, but I can't publish those yet. Soon 😈", unsupported.Chart)}
+		}
+		return "", &userError{msg: "Sorry, I couldn't publish the chart.", err: err}
+	}
+
+	if err := uq.StorePublication(s.db, &uq.Publication{
+		ModuleID:   pub.ModuleID,
+		URL:        pub.URL,
+		QueryID:    query.ID,
+		UserID:     userID,
+		Username:   username,
+		GuildID:    guildID,
+		ApprovedBy: approvedBy,
+	}); err != nil {
+		// The module is already live, so don't fail the export over the audit log.
+		log.Printf("Error recording publication of module %s: %v\n", pub.ModuleID, err)
+	}
+	return pub.URL, nil
+}
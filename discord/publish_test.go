@@ -0,0 +1,44 @@
+package discord
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func TestReviewActionOtherGuild(t *testing.T) {
+	db := testutil.DB(t)
+	id, err := uq.StorePublishRequest(db, &uq.PublishRequest{QueryID: 1, UserID: "4000", GuildID: "1", ChannelID: "3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &BotServer{db: db, moderatorRoles: []string{"mod"}}
+	cid := customID("publish-approve", id)
+	_, args, _ := parseCustomID(cid)
+	c := &component{
+		i: &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type:    discordgo.InteractionMessageComponent,
+			GuildID: "2",
+			Member:  &discordgo.Member{User: &discordgo.User{ID: "5000"}, Roles: []string{"mod"}},
+		}},
+		id:   cid,
+		args: args,
+	}
+
+	err = s.reviewAction(true)(c)
+	var uerr *userError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("approving from another guild = %v, want a userError", err)
+	}
+	req, err := uq.GetPublishRequest(db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != uq.PublishPending || req.ReviewerID != "" {
+		t.Errorf("request status = %s, reviewer = %q, want it still pending", req.Status, req.ReviewerID)
+	}
+}
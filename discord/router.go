@@ -0,0 +1,320 @@
+package discord
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	// customIDVersion prefixes the CustomID of every button and modal, which take the form
+	// "v1:<action>:<arg>...". Bumping it lets the format change without old messages' buttons
+	// being misread: anything with another version, or from before versioning, is treated as
+	// expired.
+	customIDVersion = "v1"
+	// componentTTL is how long the buttons on a message keep working after it's posted.
+	componentTTL = 14 * 24 * time.Hour
+)
+
+// customID builds a versioned CustomID for an action and its arguments.
+func customID(action string, args ...interface{}) string {
+	parts := []string{customIDVersion, action}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+	return strings.Join(parts, ":")
+}
+
+// parseCustomID splits a CustomID built by customID into its action and arguments. It returns false
+// for CustomIDs of any other version.
+func parseCustomID(id string) (string, []string, bool) {
+	parts := strings.Split(id, ":")
+	if len(parts) < 2 || parts[0] != customIDVersion {
+		return "", nil, false
+	}
+	return parts[1], parts[2:], true
+}
+
+// componentAction handles a button click or modal submission routed to it by action name. An
+// action that returns an error is answered with an error reply, and any buttons it disabled are
+// re-enabled so the user can retry.
+type componentAction func(c *component) error
+
+// component is a button click or modal submission being handled by the router.
+type component struct {
+	ds   *discordgo.Session
+	i    *discordgo.InteractionCreate
+	id   string
+	args []string
+
+	// responded is set once the interaction has been acknowledged, and deferred when that was with
+	// a deferred response that must be edited to reply.
+	responded, deferred bool
+	// disabled lists the buttons disabled while the action runs.
+	disabled []string
+}
+
+// userError is an error with a message meant for the user, wrapping the underlying cause.
+type userError struct {
+	msg string
+	err error
+}
+
+func (e *userError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %v", e.msg, e.err)
+}
+
+func (e *userError) Unwrap() error {
+	return e.err
+}
+
+// componentActions maps each action name used in CustomIDs to its handler.
+func (s *BotServer) componentActions() map[string]componentAction {
+	return map[string]componentAction{
+		"png":             s.chartAction,
+		"export":          s.exportAction,
+		"publish-approve": s.reviewAction(true),
+		"publish-reject":  s.reviewAction(false),
+		"editsql":         s.editSQLAction,
+		"editsql-submit":  s.editSQLSubmitAction,
+		"fb":              s.feedbackAction,
+		"fb-comment":      s.feedbackCommentAction,
+		"page":            s.pageAction,
+	}
+}
+
+// componentRouter dispatches button clicks and modal submissions to their actions by CustomID.
+func (s *BotServer) componentRouter(ds *discordgo.Session, i *discordgo.InteractionCreate) {
+	var id string
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		id = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		id = i.ModalSubmitData().CustomID
+	default:
+		// Not the interaction we are looking for.
+		return
+	}
+
+	c := &component{ds: ds, i: i, id: id}
+	name, args, ok := parseCustomID(id)
+	action, known := s.actions[name]
+	if !ok || !known || c.expired() {
+		c.expire()
+		return
+	}
+	c.args = args
+	if err := action(c); err != nil {
+		log.Printf("Error handling %s: %v\n", id, err)
+		c.fail(err)
+	}
+}
+
+// expired reports whether the message the component belongs to is older than componentTTL.
+func (c *component) expired() bool {
+	if c.i.Message == nil {
+		return false
+	}
+	posted, err := discordgo.SnowflakeTimestamp(c.i.Message.ID)
+	if err != nil {
+		return false
+	}
+	return time.Since(posted) > componentTTL
+}
+
+// expire tells the user the buttons have stopped working and disables them all.
+func (c *component) expire() {
+	respondEphemeral(c.ds, c.i, "These buttons have expired. Ask your question again for a fresh answer.")
+	if c.i.Message != nil && setButtonsDisabled(c.i.Message.Components, true) {
+		c.editComponents()
+	}
+}
+
+// arg returns the nth argument of the CustomID.
+func (c *component) arg(n int) (string, error) {
+	if n >= len(c.args) {
+		return "", fmt.Errorf("missing argument %d in %q", n, c.id)
+	}
+	return c.args[n], nil
+}
+
+// intArg returns the nth argument of the CustomID as an integer.
+func (c *component) intArg(n int) (int64, error) {
+	arg, err := c.arg(n)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing argument %d in %q: %v", n, c.id, err)
+	}
+	return v, nil
+}
+
+// query loads the stored query whose ID is the nth argument of the CustomID.
+func (c *component) query(db *sql.DB, n int) (*uq.UserQuery, error) {
+	id, err := c.arg(n)
+	if err != nil {
+		return nil, err
+	}
+	query, err := uq.GetUserQuery(db, id)
+	if err != nil {
+		return nil, &userError{msg: "Sorry, I couldn't load that answer. Please try again.", err: err}
+	}
+	if query == nil {
+		return nil, &userError{msg: "Sorry, I couldn't find that answer anymore."}
+	}
+	return query, nil
+}
+
+// deferReply acknowledges the interaction with a "thinking" message to be edited once the action
+// completes.
+func (c *component) deferReply() error {
+	if err := c.ds.InteractionRespond(c.i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		return fmt.Errorf("sending deferred response: %v", err)
+	}
+	c.responded, c.deferred = true, true
+	return nil
+}
+
+// respond acknowledges the interaction with the given response.
+func (c *component) respond(resp *discordgo.InteractionResponse) error {
+	if err := c.ds.InteractionRespond(c.i.Interaction, resp); err != nil {
+		return err
+	}
+	c.responded = true
+	return nil
+}
+
+// reply sends content in whichever way the interaction still allows: by editing a deferred
+// response, as an ephemeral follow-up, or as an ephemeral response.
+func (c *component) reply(content string) {
+	var err error
+	switch {
+	case c.deferred:
+		_, err = c.ds.InteractionResponseEdit(c.i.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		})
+	case c.responded:
+		_, err = c.ds.FollowupMessageCreate(c.i.Interaction, true, &discordgo.WebhookParams{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+	default:
+		err = c.ds.InteractionRespond(c.i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		c.responded = err == nil
+	}
+	if err != nil {
+		log.Println("Error replying to interaction:", err)
+	}
+}
+
+// fail replies with an error and restores any buttons disabled by the action so it can be retried.
+func (c *component) fail(err error) {
+	c.restoreButtons()
+	c.reply("⚠️ " + userMessage(err))
+}
+
+// userMessage returns the message to show a user for an error: the message of a userError, or a
+// generic apology for anything else, so internal errors aren't shown.
+func userMessage(err error) string {
+	var uerr *userError
+	if errors.As(err, &uerr) {
+		return uerr.msg
+	}
+	return "Sorry, something went wrong. Please try again."
+}
+
+// disableButton disables the clicked button while the action runs, so it isn't clicked twice.
+func (c *component) disableButton() {
+	if c.i.Message == nil || !setButtonsDisabled(c.i.Message.Components, true, c.id) {
+		return
+	}
+	c.disabled = append(c.disabled, c.id)
+	c.editComponents()
+}
+
+// restoreButtons re-enables any buttons disabled by disableButton.
+func (c *component) restoreButtons() {
+	if len(c.disabled) == 0 || !setButtonsDisabled(c.i.Message.Components, false, c.disabled...) {
+		return
+	}
+	c.disabled = nil
+	c.editComponents()
+}
+
+// editComponents saves changes made to the components of the interaction's message.
+func (c *component) editComponents() {
+	if _, err := c.ds.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      c.i.Message.ID,
+		Channel: c.i.Message.ChannelID,
+		Content: &c.i.Message.Content,
+		// Embeds and components are replaced on every edit, so both must be sent.
+		Embeds:     c.i.Message.Embeds,
+		Components: c.i.Message.Components,
+	}); err != nil {
+		log.Println("Error editing message components:", err)
+	}
+}
+
+// setButtonsDisabled sets whether the buttons with the given CustomIDs are disabled, or every button
+// if none are given. It reports whether any button changed.
+func setButtonsDisabled(components []discordgo.MessageComponent, disabled bool, customIDs ...string) bool {
+	matches := func(id string) bool {
+		if len(customIDs) == 0 {
+			return true
+		}
+		for _, customID := range customIDs {
+			if id == customID {
+				return true
+			}
+		}
+		return false
+	}
+
+	changed := false
+	for _, component := range components {
+		var rowComponents []discordgo.MessageComponent
+		switch row := component.(type) {
+		case *discordgo.ActionsRow:
+			rowComponents = row.Components
+		case discordgo.ActionsRow:
+			rowComponents = row.Components
+		}
+		for k, rowComponent := range rowComponents {
+			switch button := rowComponent.(type) {
+			case *discordgo.Button:
+				if matches(button.CustomID) && button.Disabled != disabled {
+					button.Disabled = disabled
+					changed = true
+				}
+			case discordgo.Button:
+				if matches(button.CustomID) && button.Disabled != disabled {
+					button.Disabled = disabled
+					rowComponents[k] = button
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
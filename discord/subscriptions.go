@@ -0,0 +1,402 @@
+package discord
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/schedule"
+)
+
+const (
+	// subscriptionPollInterval is how often the scheduler checks for subscriptions to run.
+	subscriptionPollInterval = time.Minute
+	// minSubscriptionInterval keeps schedules from flooding a channel.
+	minSubscriptionInterval = time.Hour
+	// maxDiffLines is the most changes listed when comparing a run to the previous one.
+	maxDiffLines = 15
+)
+
+// subscriptionCommandHandler handles the subscribe, subscriptions and unsubscribe subcommands.
+func (s *BotServer) subscriptionCommandHandler(ds *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	subcommand, options := commandOptions(i.ApplicationCommandData())
+	switch subcommand {
+	case "subscribe":
+		s.subscribe(ds, i, options)
+	case "subscriptions":
+		s.listSubscriptions(ds, i)
+	case "unsubscribe":
+		s.unsubscribe(ds, i, options)
+	default:
+		// Not the interaction we are looking for.
+	}
+}
+
+func (s *BotServer) subscribe(ds *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	var (
+		queryID  int64
+		spec     string
+		onIngest bool
+	)
+	for _, option := range options {
+		switch option.Name {
+		case "query":
+			queryID = option.IntValue()
+		case "schedule":
+			spec = strings.TrimSpace(option.StringValue())
+		case "on_ingest":
+			onIngest = option.BoolValue()
+		}
+	}
+	if spec == "" && !onIngest {
+		respondEphemeral(ds, i, "Give me a `schedule`, set `on_ingest`, or both, so I know when to re-run the query.")
+		return
+	}
+
+	query, err := uq.GetUserQuery(s.db, strconv.FormatInt(queryID, 10))
+	if err != nil {
+		log.Println("Error getting query:", err)
+	}
+	if query == nil || !canSubscribe(query, i.GuildID, interactionUser(i).ID) {
+		respondEphemeral(ds, i, fmt.Sprintf("I couldn't find query #%d. The query number is shown at the bottom of each answer.", queryID))
+		return
+	}
+
+	sub := &uq.Subscription{
+		QueryID:     query.ID,
+		UserID:      interactionUser(i).ID,
+		GuildID:     i.GuildID,
+		ChannelID:   i.ChannelID,
+		Schedule:    spec,
+		OnIngest:    onIngest,
+		LastResults: query.Results,
+	}
+	if spec != "" {
+		sched, err := schedule.Parse(spec)
+		if err != nil {
+			respondEphemeral(ds, i, fmt.Sprintf("I couldn't understand that schedule: %v", err))
+			return
+		}
+		sub.NextRunAt = sched.Next(time.Now().UTC())
+		if sub.NextRunAt.IsZero() {
+			respondEphemeral(ds, i, fmt.Sprintf("The schedule `%s` never runs.", spec))
+			return
+		}
+		if sched.MinInterval() < minSubscriptionInterval {
+			respondEphemeral(ds, i, fmt.Sprintf("Scheduled reports can run at most every %v.", minSubscriptionInterval))
+			return
+		}
+	}
+	if onIngest {
+		if sub.TableFingerprint, err = s.bot.TableFingerprint(query.TableName); err != nil {
+			log.Println("Error fingerprinting table:", err)
+			respondEphemeral(ds, i, "Sorry, I can't tell when the data behind that query changes.")
+			return
+		}
+	}
+
+	id, err := uq.StoreSubscription(s.db, sub)
+	if err != nil {
+		log.Println("Error storing subscription:", err)
+		respondEphemeral(ds, i, "Sorry, I couldn't save that subscription.")
+		return
+	}
+	sub.ID = id
+
+	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("Subscribed this channel to *%s*: %s. Cancel with `/torontobot unsubscribe id:%d`.",
+				query.Question, describeSubscription(sub), id),
+		},
+	}); err != nil {
+		log.Println("Error responding to subscribe:", err)
+	}
+}
+
+// canSubscribe reports whether a user in a guild may subscribe to a stored query. Queries asked in a
+// guild may only be subscribed to from that guild, and queries asked outside one, such as in DMs,
+// only by whoever asked them. Queries from the HTTP API, MCP and the console have no Discord user,
+// so they can't be subscribed to at all.
+func canSubscribe(query *uq.UserQuery, guildID, userID string) bool {
+	if query.GuildID != "" {
+		return query.GuildID == guildID
+	}
+	return userID != "" && query.UserID == userID
+}
+
+func (s *BotServer) listSubscriptions(ds *discordgo.Session, i *discordgo.InteractionCreate) {
+	subs, err := uq.ChannelSubscriptions(s.db, i.ChannelID)
+	if err != nil {
+		log.Println("Error listing subscriptions:", err)
+		respondEphemeral(ds, i, "Sorry, I couldn't load this channel's subscriptions.")
+		return
+	}
+	if len(subs) == 0 {
+		respondEphemeral(ds, i, "This channel has no subscriptions. Add one with `/torontobot subscribe`.")
+		return
+	}
+
+	lines := []string{"**Subscriptions in this channel**"}
+	for _, sub := range subs {
+		question := fmt.Sprintf("query #%d", sub.QueryID)
+		if query, err := uq.GetUserQuery(s.db, strconv.FormatInt(sub.QueryID, 10)); err == nil && query != nil {
+			question = query.Question
+		}
+		lines = append(lines, fmt.Sprintf("`#%d` *%s*: %s, added by <@%s>", sub.ID, question, describeSubscription(sub), sub.UserID))
+	}
+	respondEphemeral(ds, i, truncate(strings.Join(lines, "\n"), 2000))
+}
+
+func (s *BotServer) unsubscribe(ds *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	var id int64
+	for _, option := range options {
+		if option.Name == "id" {
+			id = option.IntValue()
+		}
+	}
+	sub, err := uq.GetSubscription(s.db, id)
+	if err != nil {
+		log.Println("Error getting subscription:", err)
+	}
+	if sub == nil || sub.ChannelID != i.ChannelID {
+		respondEphemeral(ds, i, fmt.Sprintf("There's no subscription #%d in this channel.", id))
+		return
+	}
+	// Anyone who can manage the channel may cancel a subscription, as well as whoever created it.
+	if sub.UserID != interactionUser(i).ID && (i.Member == nil || i.Member.Permissions&discordgo.PermissionManageChannels == 0) {
+		respondEphemeral(ds, i, "Only the person who created a subscription, or a channel manager, can cancel it.")
+		return
+	}
+	if err := uq.DeleteSubscription(s.db, id); err != nil {
+		log.Println("Error deleting subscription:", err)
+		respondEphemeral(ds, i, "Sorry, I couldn't cancel that subscription.")
+		return
+	}
+	if err := ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("Cancelled subscription #%d.", id),
+		},
+	}); err != nil {
+		log.Println("Error responding to unsubscribe:", err)
+	}
+}
+
+// describeSubscription summarizes when a subscription runs.
+func describeSubscription(sub *uq.Subscription) string {
+	var when []string
+	if sub.Schedule != "" {
+		when = append(when, fmt.Sprintf("on schedule `%s`, next <t:%d:R>", sub.Schedule, sub.NextRunAt.Unix()))
+	}
+	if sub.OnIngest {
+		when = append(when, "whenever the data is refreshed")
+	}
+	return strings.Join(when, " and ")
+}
+
+// RunScheduler runs subscriptions as they come due until ctx is cancelled.
+func (s *BotServer) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(subscriptionPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.runDueSubscriptions(ctx, now)
+		}
+	}
+}
+
+func (s *BotServer) runDueSubscriptions(ctx context.Context, now time.Time) {
+	subs, err := uq.AllSubscriptions(s.db)
+	if err != nil {
+		log.Println("Error listing subscriptions:", err)
+		return
+	}
+	for _, sub := range subs {
+		query, err := uq.GetUserQuery(s.db, strconv.FormatInt(sub.QueryID, 10))
+		if err != nil || query == nil {
+			log.Printf("Error getting query %d for subscription %d: %v\n", sub.QueryID, sub.ID, err)
+			continue
+		}
+
+		var reason string
+		if sub.Schedule != "" && !sub.NextRunAt.IsZero() && !now.Before(sub.NextRunAt) {
+			reason = "Scheduled report"
+			sched, err := schedule.Parse(sub.Schedule)
+			if err != nil {
+				log.Printf("Error parsing schedule for subscription %d: %v\n", sub.ID, err)
+				continue
+			}
+			sub.NextRunAt = sched.Next(now.UTC())
+		}
+		if sub.OnIngest {
+			fingerprint, err := s.bot.TableFingerprint(query.TableName)
+			if err != nil {
+				log.Printf("Error fingerprinting table for subscription %d: %v\n", sub.ID, err)
+			} else if fingerprint != sub.TableFingerprint {
+				reason = fmt.Sprintf("`%s` was refreshed", query.TableName)
+				sub.TableFingerprint = fingerprint
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		s.runSubscription(ctx, sub, query, reason)
+		sub.LastRunAt = now
+		if err := uq.RecordSubscriptionRun(s.db, sub); err != nil {
+			log.Println("Error recording subscription run:", err)
+		}
+	}
+}
+
+// runSubscription re-runs a subscription's query and posts the refreshed results, the changes since
+// the previous run and a chart to its channel. It updates sub.LastResults on success.
+func (s *BotServer) runSubscription(ctx context.Context, sub *uq.Subscription, query *uq.UserQuery, reason string) {
+	ds := s.session
+	heading := fmt.Sprintf("🔁 %s for subscription #%d", reason, sub.ID)
+
+	results, err := s.bot.LoadResults(query.SQLResponse.SQL, query.SQLResponse.IsCurrency)
+	if err != nil {
+		out := fmt.Sprintf("%s: *%s*\n\n**No results found this time.**", heading, query.Question)
+		if err != sql.ErrNoRows {
+			log.Printf("Error running subscription #%d: %v\n", sub.ID, err)
+			out = fmt.Sprintf("%s: *%s*\n\n⚠️ %s", heading, query.Question, userMessage(err))
+		}
+		if _, err := ds.ChannelMessageSend(sub.ChannelID, out); err != nil {
+			log.Println("Error sending subscription results:", err)
+		}
+		return
+	}
+
+	// Store the refreshed answer so it can be charted, exported and rated like any other.
+	refreshed := &uq.UserQuery{
+		UserID:      sub.UserID,
+		GuildID:     sub.GuildID,
+		ChannelID:   sub.ChannelID,
+		Question:    query.Question,
+		TableName:   query.TableName,
+		SQLResponse: query.SQLResponse,
+		Results:     results,
+	}
+	id, err := uq.StoreUserQuery(s.db, refreshed)
+	if err != nil {
+		log.Println("Error storing query:", err)
+		return
+	}
+	refreshed.ID = id
+
+	embed, pages := s.answerEmbed(refreshed, 0)
+	embed.Fields = append([]*discordgo.MessageEmbedField{{
+		Name:  "Changes since last run",
+		Value: truncate(strings.Join(diffResults(sub.LastResults, results), "\n"), maxEmbedFieldLen),
+	}}, embed.Fields...)
+	if _, err := ds.ChannelMessageSendComplex(sub.ChannelID, &discordgo.MessageSend{
+		Content:    heading,
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: s.answerComponents(id, sub.GuildID != "", 0, pages),
+	}); err != nil {
+		log.Println("Error sending subscription results:", err)
+		return
+	}
+	sub.LastResults = results
+
+	chart, file, err := s.chartEmbed(ctx, refreshed)
+	if err != nil {
+		log.Printf("Error charting subscription %d: %v\n", sub.ID, err)
+		return
+	}
+	if _, err := ds.ChannelMessageSendComplex(sub.ChannelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{chart},
+		Files:  []*discordgo.File{file},
+	}); err != nil {
+		log.Println("Error sending subscription chart:", err)
+	}
+}
+
+// diffResults describes how a rendered results table changed. Rows are matched on every column but
+// the last, whose values are compared; a single row is compared column by column.
+func diffResults(previous, current string) []string {
+	before, after := parseResultsTable(previous), parseResultsTable(current)
+	if len(before.header) < 2 || len(after.header) < 2 {
+		return []string{"Previous results aren't available to compare."}
+	}
+	if before.header[1] != after.header[1] {
+		return []string{"The columns have changed since the last run."}
+	}
+
+	var changes []string
+	if len(before.rows) == 1 && len(after.rows) == 1 {
+		header := cells(after.header[1])
+		old, cur := cells(before.rows[0]), cells(after.rows[0])
+		for j := range header {
+			if j < len(old) && j < len(cur) && old[j] != cur[j] {
+				changes = append(changes, fmt.Sprintf("%s: %s → %s", columnType.ReplaceAllString(header[j], ""), old[j], cur[j]))
+			}
+		}
+	} else {
+		oldValues, oldKeys := keyedRows(before.rows)
+		newValues, newKeys := keyedRows(after.rows)
+		for _, key := range newKeys {
+			old, ok := oldValues[key]
+			switch {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("+ %s", joinKeyValue(key, newValues[key])))
+			case old != newValues[key]:
+				changes = append(changes, fmt.Sprintf("%s: %s → %s", key, old, newValues[key]))
+			}
+		}
+		for _, key := range oldKeys {
+			if _, ok := newValues[key]; !ok {
+				changes = append(changes, fmt.Sprintf("- %s", joinKeyValue(key, oldValues[key])))
+			}
+		}
+	}
+
+	if len(changes) == 0 {
+		return []string{"No changes since the last run."}
+	}
+	if len(changes) > maxDiffLines {
+		more := len(changes) - maxDiffLines
+		changes = append(changes[:maxDiffLines], fmt.Sprintf("…and %d more", more))
+	}
+	return changes
+}
+
+// keyedRows maps each row's leading columns to its last column, returning the keys in row order.
+func keyedRows(rows []string) (map[string]string, []string) {
+	values := map[string]string{}
+	var keys []string
+	for _, row := range rows {
+		c := cells(row)
+		key, value := strings.Join(c[:len(c)-1], " · "), c[len(c)-1]
+		if len(c) == 1 {
+			key, value = c[0], ""
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return values, keys
+}
+
+func joinKeyValue(key, value string) string {
+	if value == "" {
+		return key
+	}
+	return key + ": " + value
+}
@@ -0,0 +1,29 @@
+package discord
+
+import (
+	"testing"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+func TestCanSubscribe(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		query           *uq.UserQuery
+		guildID, userID string
+		want            bool
+	}{
+		{"same guild", &uq.UserQuery{GuildID: "1", UserID: "10"}, "1", "20", true},
+		{"other guild", &uq.UserQuery{GuildID: "1", UserID: "10"}, "2", "10", false},
+		{"guild query from a DM", &uq.UserQuery{GuildID: "1", UserID: "10"}, "", "10", false},
+		{"own DM query", &uq.UserQuery{UserID: "10"}, "", "10", true},
+		{"own DM query from a guild", &uq.UserQuery{UserID: "10"}, "1", "10", true},
+		{"someone else's DM query", &uq.UserQuery{UserID: "10"}, "1", "20", false},
+		{"API query", &uq.UserQuery{UserID: "api:reporter"}, "1", "10", false},
+		{"query with no user", &uq.UserQuery{}, "1", "", false},
+	} {
+		if got := canSubscribe(test.query, test.guildID, test.userID); got != test.want {
+			t.Errorf("%s: canSubscribe = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// compareRows reports whether two result sets hold the same rows, in any order, with numbers equal
+// to within a relative tolerance. Column names are ignored, since the LLM names its own aliases.
+// When they differ, the reason explains how.
+func compareRows(expected, actual [][]interface{}, tolerance float64) (ok bool, reason string) {
+	if len(expected) != len(actual) {
+		return false, fmt.Sprintf("expected %d rows, got %d", len(expected), len(actual))
+	}
+	if len(expected) > 0 && len(expected[0]) != len(actual[0]) {
+		return false, fmt.Sprintf("expected %d columns, got %d", len(expected[0]), len(actual[0]))
+	}
+
+	matched := make([]bool, len(actual))
+	for _, want := range expected {
+		found := false
+		for i, got := range actual {
+			if !matched[i] && rowsEqual(want, got, tolerance) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, fmt.Sprintf("missing row %s", formatRow(want))
+		}
+	}
+	return true, ""
+}
+
+func rowsEqual(a, b []interface{}, tolerance float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !valuesEqual(normalize(a[i]), normalize(b[i]), tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// valuesEqual compares normalized values. Numbers are equal if they differ by no more than
+// tolerance times the larger of their magnitudes, or tolerance itself for numbers below 1.
+func valuesEqual(a, b interface{}, tolerance float64) bool {
+	af, aIsNum := a.(float64)
+	bf, bIsNum := b.(float64)
+	if aIsNum && bIsNum {
+		scale := math.Max(1, math.Max(math.Abs(af), math.Abs(bf)))
+		return math.Abs(af-bf) <= tolerance*scale
+	}
+	return a == b
+}
+
+// normalize converts values from the database or a suite file to comparable types: numbers to
+// float64, text to trimmed strings and times to SQLite's text format.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []byte:
+		return strings.TrimSpace(string(v))
+	case string:
+		return strings.TrimSpace(v)
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04:05")
+	default:
+		return v
+	}
+}
+
+func formatRow(row []interface{}) string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = fmt.Sprintf("%v", normalize(v))
+	}
+	return "(" + strings.Join(values, ", ") + ")"
+}
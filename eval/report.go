@@ -0,0 +1,247 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// Report is the outcome of an eval run. Reports are saved as JSON so later runs can be diffed
+// against them.
+type Report struct {
+	StartedAt time.Time      `json:"started_at"`
+	Model     string         `json:"model"`
+	Suites    []*SuiteReport `json:"suites"`
+}
+
+// SuiteReport is the outcome of running one suite.
+type SuiteReport struct {
+	Table   string        `json:"table"`
+	Version int           `json:"version"`
+	Cases   []*CaseResult `json:"cases"`
+}
+
+// CaseResult is the outcome of asking one question.
+type CaseResult struct {
+	Question string `json:"question"`
+	// Table is the table the question was routed to, and Routed whether it was the suite's table.
+	Table   string `json:"table,omitempty"`
+	Routed  bool   `json:"routed"`
+	SQL     string `json:"sql,omitempty"`
+	Correct bool   `json:"correct"`
+	// Reason explains why the answer wasn't correct.
+	Reason    string `json:"reason,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Usage     Usage  `json:"usage"`
+}
+
+// Summary totals the results of a set of cases.
+type Summary struct {
+	Cases, Correct, Routed int
+	LatencyMS              int64
+	Usage                  Usage
+}
+
+func (s *Summary) add(c *CaseResult) {
+	s.Cases++
+	if c.Correct {
+		s.Correct++
+	}
+	if c.Routed {
+		s.Routed++
+	}
+	s.LatencyMS += c.LatencyMS
+	s.Usage.PromptTokens += c.Usage.PromptTokens
+	s.Usage.CompletionTokens += c.Usage.CompletionTokens
+}
+
+// Accuracy returns the fraction of questions answered correctly.
+func (s Summary) Accuracy() float64 {
+	return ratio(s.Correct, s.Cases)
+}
+
+// RoutingAccuracy returns the fraction of questions routed to the right table.
+func (s Summary) RoutingAccuracy() float64 {
+	return ratio(s.Routed, s.Cases)
+}
+
+// MeanLatency returns the mean time taken to answer a question.
+func (s Summary) MeanLatency() time.Duration {
+	if s.Cases == 0 {
+		return 0
+	}
+	return time.Duration(s.LatencyMS/int64(s.Cases)) * time.Millisecond
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// Summary totals the results of the suite.
+func (s *SuiteReport) Summary() Summary {
+	var sum Summary
+	for _, c := range s.Cases {
+		sum.add(c)
+	}
+	return sum
+}
+
+// Summary totals the results of every suite.
+func (r *Report) Summary() Summary {
+	var sum Summary
+	for _, s := range r.Suites {
+		for _, c := range s.Cases {
+			sum.add(c)
+		}
+	}
+	return sum
+}
+
+func (r *Report) suite(table string) *SuiteReport {
+	for _, s := range r.Suites {
+		if s.Table == table {
+			return s
+		}
+	}
+	return nil
+}
+
+// Save writes the report to a JSON file.
+func (r *Report) Save(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// LoadReport reads a report saved by Save.
+func LoadReport(path string) (*Report, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	return &r, nil
+}
+
+// Write writes a summary of the report to w, with the change in accuracy since baseline and the
+// questions which regressed or were fixed if baseline is set.
+func (r *Report) Write(w io.Writer, baseline *Report) error {
+	tw := table.NewWriter()
+	tw.Style().Format.Footer = text.FormatDefault
+	header := table.Row{"Table", "Version", "Cases", "Accuracy", "Routing", "Mean latency", "Tokens"}
+	if baseline != nil {
+		header = append(header, "Change")
+	}
+	tw.AppendHeader(header)
+	row := func(name string, version string, sum Summary, prev *Summary) table.Row {
+		row := table.Row{
+			name,
+			version,
+			sum.Cases,
+			fmt.Sprintf("%.1f%%", sum.Accuracy()*100),
+			fmt.Sprintf("%.1f%%", sum.RoutingAccuracy()*100),
+			sum.MeanLatency(),
+			sum.Usage.Total(),
+		}
+		if baseline != nil {
+			change := "new"
+			if prev != nil {
+				change = fmt.Sprintf("%+.1f%%", (sum.Accuracy()-prev.Accuracy())*100)
+			}
+			row = append(row, change)
+		}
+		return row
+	}
+	for _, s := range r.Suites {
+		var prev *Summary
+		version := fmt.Sprint(s.Version)
+		if baseline != nil {
+			if ps := baseline.suite(s.Table); ps != nil {
+				sum := ps.Summary()
+				prev = &sum
+				if ps.Version != s.Version {
+					version = fmt.Sprintf("%d (was %d)", s.Version, ps.Version)
+				}
+			}
+		}
+		tw.AppendRow(row(s.Table, version, s.Summary(), prev))
+	}
+	var prev *Summary
+	if baseline != nil {
+		sum := baseline.Summary()
+		prev = &sum
+	}
+	tw.AppendFooter(row("Total", "", r.Summary(), prev))
+	if _, err := fmt.Fprintf(w, "Model: %s\n%s\n", r.Model, tw.Render()); err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, s := range r.Suites {
+		for _, c := range s.Cases {
+			if !c.Correct {
+				failed = append(failed, fmt.Sprintf("[%s] %s\n    %s", s.Table, c.Question, c.Reason))
+			}
+		}
+	}
+	if err := writeList(w, "Incorrect answers", failed); err != nil {
+		return err
+	}
+	if baseline == nil {
+		return nil
+	}
+
+	var regressed, fixed []string
+	for _, s := range r.Suites {
+		ps := baseline.suite(s.Table)
+		if ps == nil {
+			continue
+		}
+		was := map[string]bool{}
+		for _, c := range ps.Cases {
+			was[c.Question] = c.Correct
+		}
+		for _, c := range s.Cases {
+			correct, ok := was[c.Question]
+			switch {
+			case !ok:
+			case correct && !c.Correct:
+				regressed = append(regressed, fmt.Sprintf("[%s] %s", s.Table, c.Question))
+			case !correct && c.Correct:
+				fixed = append(fixed, fmt.Sprintf("[%s] %s", s.Table, c.Question))
+			}
+		}
+	}
+	if err := writeList(w, "Regressed since baseline", regressed); err != nil {
+		return err
+	}
+	return writeList(w, "Fixed since baseline", fixed)
+}
+
+func writeList(w io.Writer, title string, items []string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "\n%s:\n", title); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, err := fmt.Fprintf(w, "  %s\n", item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package eval
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+)
+
+// Run asks the questions of each suite in turn, comparing the answers with the golden results.
+// Questions are asked one at a time so that latency and token usage, counted by usage if it's
+// set, can be attributed to each.
+func Run(ctx context.Context, tb *bot.TorontoBot, usage *UsageCounter, suites []*Suite) *Report {
+	report := &Report{StartedAt: time.Now(), Model: tb.Model()}
+	for _, suite := range suites {
+		sr := &SuiteReport{Table: suite.Table, Version: suite.Version}
+		for i, c := range suite.Cases {
+			log.Printf("[%s %d/%d] %s\n", suite.Table, i+1, len(suite.Cases), c.Question)
+			sr.Cases = append(sr.Cases, runCase(ctx, tb, usage, suite, c))
+		}
+		report.Suites = append(report.Suites, sr)
+	}
+	return report
+}
+
+func runCase(ctx context.Context, tb *bot.TorontoBot, usage *UsageCounter, suite *Suite, c *Case) *CaseResult {
+	res := &CaseResult{Question: c.Question}
+	var before Usage
+	if usage != nil {
+		before = usage.Usage()
+	}
+	start := time.Now()
+	answer, err := tb.Ask(ctx, &bot.AskRequest{Question: c.Question})
+	res.LatencyMS = time.Since(start).Milliseconds()
+	if usage != nil {
+		res.Usage = usage.Usage().sub(before)
+	}
+
+	if answer == nil {
+		res.Reason = err.Error()
+		return res
+	}
+	res.Table = answer.Table.Name
+	res.Routed = res.Table == suite.Table
+	res.SQL = answer.SQLResponse.SQL
+	if answer.SQLResponse.MissingData != "" {
+		res.Reason = "no query: " + answer.SQLResponse.MissingData
+		return res
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		res.Reason = fmt.Sprintf("running query: %v", err)
+		return res
+	}
+
+	// The rendered results are formatted for people, so run the query again for raw values.
+	actual, err := loadRows(tb, res.SQL)
+	if err != nil {
+		res.Reason = fmt.Sprintf("loading rows: %v", err)
+		return res
+	}
+	expected := c.Expected
+	if expected == nil {
+		if expected, err = loadRows(tb, c.SQL); err != nil {
+			res.Reason = fmt.Sprintf("running reference query: %v", err)
+			return res
+		}
+	}
+	res.Correct, res.Reason = compareRows(expected, actual, suite.tolerance(c))
+	return res
+}
+
+// loadRows runs a query, returning no rows rather than an error if it has no results.
+func loadRows(tb *bot.TorontoBot, sqlQuery string) ([][]interface{}, error) {
+	_, rows, err := tb.LoadRows(sqlQuery)
+	if errors.Is(err, sql.ErrNoRows) {
+		return [][]interface{}{}, nil
+	}
+	return rows, err
+}
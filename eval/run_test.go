@@ -0,0 +1,120 @@
+package eval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+// testData is a little of every table the suites ask about.
+var testData = []string{
+	`INSERT INTO service_requests (year, ward, service_request_type) VALUES
+		(2022, 'Davenport', 'Pothole'), (2022, 'Davenport', 'Pothole'), (2022, 'Davenport', 'Pothole'),
+		(2022, 'Davenport', 'Graffiti'), (2022, 'Spadina-Fort York', 'Pothole'), (2022, 'Spadina-Fort York', 'Graffiti'),
+		(2022, 'Spadina-Fort York', 'Noise'), (2022, 'Beaches-East York', 'Graffiti'), (2022, 'Beaches-East York', 'Pothole'),
+		(2022, 'Etobicoke North', 'Noise'), (2021, 'Davenport', 'Noise'), (2021, 'Etobicoke North', 'Pothole'),
+		(2023, 'Davenport', 'Pothole')`,
+	`INSERT INTO ase_tickets (site_code, location, enforcement_start_date, enforcement_end_date, month, year, ticket_count, estimated_fine) VALUES
+		('A1', 'Jane St near Driftwood Ave', '2022-01-01', '2022-06-30', 1, 2022, 800, 72000),
+		('A1', 'Jane St near Driftwood Ave', '2022-01-01', '2022-06-30', 2, 2022, 100, 9000),
+		('A2', 'Lawrence Ave E near Pharmacy Ave', '2022-01-01', '2022-06-30', 1, 2022, 500, 45000),
+		('A3', 'Bloor St W near Kipling Ave', '2022-01-01', '2022-06-30', 1, 2022, 300, 27000),
+		('A1', 'Jane St near Driftwood Ave', '2023-01-01', '2023-06-30', 1, 2023, 650, 58500),
+		('A2', 'Lawrence Ave E near Pharmacy Ave', '2023-01-01', '2023-06-30', 1, 2023, 420, 37800),
+		('A2', 'Lawrence Ave E near Pharmacy Ave', '2023-01-01', '2023-06-30', 2, 2023, 390, 35100),
+		('A3', 'Bloor St W near Kipling Ave', '2023-01-01', '2023-06-30', 3, 2023, 275, 24750)`,
+	`INSERT INTO condominium_apartment_price (record_period, record_start_month, record_end_month, year, geolocation, price_index) VALUES
+		('Q1', 1, 3, 2022, 'Toronto', 312.4), ('Q2', 4, 6, 2022, 'Toronto', 318.9),
+		('Q3', 7, 9, 2022, 'Toronto', 305.2), ('Q4', 10, 12, 2022, 'Toronto', 298.7),
+		('Q1', 1, 3, 2023, 'Toronto', 301.5), ('Q1', 1, 3, 2023, 'Vaughan', 344.1),
+		('Q1', 1, 3, 2023, 'Mississauga', 289.6), ('Q1', 1, 3, 2022, 'Vancouver', 352.0),
+		('Q2', 4, 6, 2022, 'Vancouver', 356.3), ('Q3', 7, 9, 2022, 'Vancouver', 349.8),
+		('Q4', 10, 12, 2022, 'Vancouver', 347.5), ('Q1', 1, 3, 2023, 'Vancouver', 361.8)`,
+	`INSERT INTO operating_budget (program, service, activity, entry_type, category, year, amount) VALUES
+		('Toronto Police Service', 'Policing', 'Patrol', 'expense', 'Salaries', 2022, 1150000000),
+		('Toronto Police Service', 'Policing', 'Patrol', 'revenue', 'User Fees', 2022, 95000000),
+		('Toronto Police Service', 'Policing', 'Patrol', 'expense', 'Salaries', 2023, 1190000000),
+		('Toronto Transit Commission', 'Transit', 'Operations', 'expense', 'Operations', 2023, 2100000000),
+		('Toronto Transit Commission', 'Transit', 'Operations', 'revenue', 'Fares', 2023, 1200000000),
+		('Fire Services', 'Fire', 'Suppression', 'expense', 'Salaries', 2022, 505000000),
+		('Fire Services', 'Fire', 'Suppression', 'expense', 'Salaries', 2023, 520000000),
+		('Toronto Paramedic Services', 'Paramedics', 'Response', 'expense', 'Salaries', 2022, 298000000),
+		('Toronto Paramedic Services', 'Paramedics', 'Response', 'expense', 'Salaries', 2023, 310000000),
+		('Children''s Services', 'Child Care', 'Subsidies', 'expense', 'Grants', 2023, 880000000),
+		('Shelter, Support & Housing Administration', 'Shelters', 'Beds', 'expense', 'Operations', 2023, 790000000),
+		('Toronto Public Library', 'Libraries', 'Branches', 'expense', 'Salaries', 2023, 230000000),
+		('Parks, Forestry & Recreation', 'Parks', 'Maintenance', 'expense', 'Operations', 2023, 560000000),
+		('Transportation Services', 'Roads', 'Maintenance', 'expense', 'Operations', 2023, 440000000),
+		('Solid Waste Management Services', 'Waste', 'Collection', 'expense', 'Operations', 2023, 150000000),
+		('Toronto Water', 'Water', 'Treatment', 'revenue', 'User Fees', 2022, 1400000000)`,
+}
+
+func TestRun(t *testing.T) {
+	db := testutil.DB(t)
+	for _, stmt := range testData {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := LoadSuites("suites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := bot.New(context.Background(), db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Datastore tables are queried live from the open data portal, so their suites only run there.
+	var suites []*Suite
+	for _, suite := range all {
+		if table, ok := tb.Table(suite.Table); ok && !table.Live() {
+			suites = append(suites, suite)
+		}
+	}
+
+	// The recorded answers are right, except for the net police budget, where the model left out
+	// revenue.
+	wrong := map[string]bool{"What is the net budget for Toronto Police Service in 2022?": true}
+	report := Run(context.Background(), tb, nil, suites)
+	var cases int
+	for _, sr := range report.Suites {
+		for _, c := range sr.Cases {
+			cases++
+			if !c.Routed {
+				t.Errorf("%q was routed to %s, want %s", c.Question, c.Table, sr.Table)
+			}
+			if c.Correct == wrong[c.Question] {
+				t.Errorf("%q correct = %t (%s), want %t", c.Question, c.Correct, c.Reason, !wrong[c.Question])
+			}
+		}
+	}
+	if cases == 0 {
+		t.Error("no cases were run")
+	}
+}
+
+func TestExamplesHaveCases(t *testing.T) {
+	suites, err := LoadSuites("suites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	questions := map[string]bool{}
+	for _, suite := range suites {
+		for _, c := range suite.Cases {
+			questions[suite.Table+"\n"+c.Question] = true
+		}
+	}
+	tables, err := bot.LoadTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		for _, example := range table.Examples {
+			if !questions[table.Name+"\n"+example] {
+				t.Errorf("example %q for %s has no eval case", example, table.Name)
+			}
+		}
+	}
+}
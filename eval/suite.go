@@ -0,0 +1,110 @@
+// Package eval measures how well TorontoBot answers questions, by running suites of questions with
+// known answers through the same pipeline as every frontend and comparing the results.
+package eval
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rolldever/go-json5"
+)
+
+// DefaultTolerance is the relative difference allowed between numbers in results unless a suite or
+// case sets its own.
+const DefaultTolerance = 0.001
+
+// Suite is a versioned set of questions about one table. Bump the version when changing the cases,
+// so that runs before and after aren't compared as equals.
+type Suite struct {
+	Table   string `json:"table"`
+	Version int    `json:"version"`
+	// Tolerance, if set, overrides DefaultTolerance for every case in the suite.
+	Tolerance float64 `json:"tolerance"`
+	Cases     []*Case `json:"cases"`
+}
+
+// Case is a question and its golden answer.
+type Case struct {
+	Question string `json:"question"`
+	// SQL is a reference query that answers the question correctly. Its results are the golden
+	// answer unless Expected is set, and the fake LLM answers with it.
+	SQL string `json:"sql"`
+	// Expected, if set, pins the golden answer rather than running SQL against the current data.
+	Expected [][]interface{} `json:"expected"`
+	// Tolerance, if set, overrides the suite's tolerance.
+	Tolerance float64 `json:"tolerance"`
+}
+
+// LoadSuites loads suites from .json5 files, or from every .json5 file in directories, sorted by
+// table.
+func LoadSuites(paths ...string) ([]*Suite, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json5"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var suites []*Suite
+	for _, file := range files {
+		suite, err := loadSuite(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %v", file, err)
+		}
+		suites = append(suites, suite)
+	}
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("no suites found in %v", paths)
+	}
+	sort.Slice(suites, func(i, j int) bool {
+		return suites[i].Table < suites[j].Table
+	})
+	return suites, nil
+}
+
+func loadSuite(file string) (*Suite, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var suite Suite
+	if err := json5.Unmarshal(b, &suite); err != nil {
+		return nil, err
+	}
+	if suite.Table == "" {
+		return nil, fmt.Errorf("missing table")
+	}
+	if suite.Version == 0 {
+		return nil, fmt.Errorf("missing version")
+	}
+	for i, c := range suite.Cases {
+		if c.Question == "" || c.SQL == "" {
+			return nil, fmt.Errorf("case %d needs a question and reference sql", i+1)
+		}
+	}
+	return &suite, nil
+}
+
+// tolerance returns the tolerance for a case of the suite.
+func (s *Suite) tolerance(c *Case) float64 {
+	switch {
+	case c.Tolerance > 0:
+		return c.Tolerance
+	case s.Tolerance > 0:
+		return s.Tolerance
+	default:
+		return DefaultTolerance
+	}
+}
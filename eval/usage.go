@@ -0,0 +1,75 @@
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Usage is a count of OpenAI tokens.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Total returns the total tokens used.
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u Usage) sub(v Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens - v.PromptTokens,
+		CompletionTokens: u.CompletionTokens - v.CompletionTokens,
+	}
+}
+
+// UsageCounter is an http.RoundTripper which counts the tokens reported in OpenAI responses, for
+// the client the bot is created with.
+type UsageCounter struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	usage Usage
+}
+
+// NewUsageCounter counts the tokens used by requests sent with next, or http.DefaultTransport if nil.
+func NewUsageCounter(next http.RoundTripper) *UsageCounter {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &UsageCounter{next: next}
+}
+
+func (c *UsageCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var counted struct {
+		Usage Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &counted); err == nil {
+		c.mu.Lock()
+		c.usage.PromptTokens += counted.Usage.PromptTokens
+		c.usage.CompletionTokens += counted.Usage.CompletionTokens
+		c.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// Usage returns the tokens used so far.
+func (c *UsageCounter) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
@@ -1,49 +1,62 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
-	"log"
-	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/xuri/excelize/v2"
+
+	"github.com/geomodulus/torontobot/opendata"
 )
 
-var aseTicketsFiles = map[int]string{
-	2023: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/537923d1-a6c8-4b9c-9d55-fa47d9d7ddab/resource/a388bc08-622c-4647-bad8-ecdb7e62090a/download/Automated%20Speed%20Enforcement%20-%20Monthly%20Charges.xlsx",
-	2022: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/537923d1-a6c8-4b9c-9d55-fa47d9d7ddab/resource/a388bc08-622c-4647-bad8-ecdb7e62090a/download/Automated%20Speed%20Enforcement%20-%20Monthly%20Charges.xlsx",
-	2021: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/537923d1-a6c8-4b9c-9d55-fa47d9d7ddab/resource/a388bc08-622c-4647-bad8-ecdb7e62090a/download/Automated%20Speed%20Enforcement%20-%20Monthly%20Charges.xlsx",
-	2020: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/537923d1-a6c8-4b9c-9d55-fa47d9d7ddab/resource/a388bc08-622c-4647-bad8-ecdb7e62090a/download/Automated%20Speed%20Enforcement%20-%20Monthly%20Charges.xlsx",
+func init() {
+	register(aseTickets{})
 }
 
-func processASETicketsYear(db *sql.DB, year int, url string) error {
-	// Prepare the SQL statement for inserting datka
-	stmt, err := db.Prepare(`
-INSERT INTO ase_tickets
-(site_code, location, enforcement_start_date, enforcement_end_date, month, year, ticket_count, estimated_fine)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
+var aseTicketsPackage = &ckanPackage{
+	ID: opendata.ASEChargesDataset,
+	// A single spreadsheet holds every year since the cameras were installed.
+	Name:      regexp.MustCompile(`(?i)monthly charges`),
+	FirstYear: 2020,
+	Format:    "XLSX",
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to make HTTP request: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: %d %s", resp.StatusCode, resp.Status)
+// aseTickets are the monthly charges laid by each Automated Speed Enforcement camera, published as a
+// single spreadsheet covering every year.
+type aseTickets struct{}
+
+func (aseTickets) Name() string { return "ase-tickets" }
+
+func (aseTickets) Package() string {
+	return aseTicketsPackage.ID
+}
+
+func (aseTickets) Schema() *Schema {
+	return &Schema{
+		Table:   "ase_tickets",
+		Columns: []string{"site_code", "location", "enforcement_start_date", "enforcement_end_date", "month", "year", "ticket_count", "estimated_fine"},
+		Key:     []string{"site_code", "enforcement_start_date", "year", "month"},
 	}
-	defer resp.Body.Close()
+}
 
+func (aseTickets) Resources() ([]*Resource, error) {
+	return aseTicketsPackage.resources()
+}
+
+func (aseTickets) Fetch(res *Resource) (*Download, error) {
+	return fetchURL(res)
+}
+
+func (aseTickets) Parse(year int, data []byte) ([][]interface{}, error) {
 	// Open the XLSX file
-	file, err := excelize.OpenReader(resp.Body)
+	file, err := excelize.OpenReader(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to open XLSX file: %v", err)
+		return nil, fmt.Errorf("failed to open XLSX file: %v", err)
 	}
 
 	// Get rows in the sheet with name 'Charges by Site and Month', as it is the only sheet in the file.
@@ -52,12 +65,11 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	rows, err := file.GetRows(useSheet)
 
 	if err != nil {
-		return fmt.Errorf("failed to get rows from XLSX file: %v, have sheetlist %+v", err, file.GetSheetList())
+		return nil, fmt.Errorf("failed to get rows from XLSX file: %v, have sheetlist %+v", err, file.GetSheetList())
 	}
 
-	log.Println("for year", year, "importing", len(rows)-1, "rows")
-
 	// Iterate through the rows, skipping the header row
+	var records [][]interface{}
 	var siteCodeIdx, locationIdx, enforcementStartDateIdx, enforcementEndDateIdx = -1, -1, -1, -1
 	ticketNumberIdxMap := make(map[int]int)
 	estimated_avg_fine_per_ticket := 180 //NOTE: this is an estimated value
@@ -153,8 +165,7 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 						month := int(oneMonthLater.Month())
 						estimated_fine := ticket_number * estimated_avg_fine_per_ticket
 
-						// Execute the prepared statement with the row data
-						if _, err = stmt.Exec(
+						records = append(records, []interface{}{
 							site_code,
 							location,
 							enforcement_start_date,
@@ -162,9 +173,8 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 							month,
 							year,
 							ticket_number,
-							estimated_fine); err != nil {
-							return fmt.Errorf("failed to insert data in row %d: %v", i+1, err)
-						}
+							estimated_fine,
+						})
 					}
 				}
 			}
@@ -172,7 +182,9 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		}
 
 	}
-	fmt.Printf("%d ASE tickets imported.\n", year)
+	return records, nil
+}
 
-	return nil
+func (d aseTickets) Load(tx *sql.Tx, year int, rows [][]interface{}) error {
+	return replaceYear(tx, d.Schema(), year, rows)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geomodulus/torontobot/opendata"
+)
+
+// ckan is the open data portal datasets are discovered in.
+var ckan = opendata.NewClient()
+
+// ckanPackage describes how to discover a dataset's resources in its open data portal package, so
+// new yearly files are picked up as they're published.
+type ckanPackage struct {
+	ID string
+	// Name matches the names of the resources to ingest. If it has a submatch, that's the year the
+	// resource holds; if not, the resource holds every year from FirstYear to the current one.
+	Name      *regexp.Regexp
+	FirstYear int
+	// Format is the format of the resources, e.g. "XLSX".
+	Format string
+}
+
+// resources returns the package's resources matching Name and Format, one per year, oldest first.
+// Where two resources hold the same year, the most recently modified is used.
+func (p *ckanPackage) resources() ([]*Resource, error) {
+	pkg, err := ckan.PackageShow(context.Background(), p.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting package %s: %v", p.ID, err)
+	}
+
+	byYear := map[int]*Resource{}
+	for _, pr := range pkg.Resources {
+		if !strings.EqualFold(pr.Format, p.Format) {
+			continue
+		}
+		m := p.Name.FindStringSubmatch(pr.Name)
+		if m == nil {
+			continue
+		}
+		modified := time.Time(pr.LastModified)
+		if modified.IsZero() {
+			modified = time.Time(pkg.LastRefreshed)
+		}
+		years, err := p.years(m)
+		if err != nil {
+			log.Printf("Skipping resource %q of %s: %v", pr.Name, p.ID, err)
+			continue
+		}
+		for _, year := range years {
+			if prev, ok := byYear[year]; ok && prev.Modified.After(modified) {
+				continue
+			}
+			byYear[year] = &Resource{Year: year, URL: pr.URL, Hash: pr.Hash, Modified: modified}
+		}
+	}
+
+	resources := make([]*Resource, 0, len(byYear))
+	for _, res := range byYear {
+		resources = append(resources, res)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Year < resources[j].Year
+	})
+	return resources, nil
+}
+
+// years returns the years held by a resource whose name matched.
+func (p *ckanPackage) years(match []string) ([]int, error) {
+	if len(match) > 1 {
+		year, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid year %q", match[1])
+		}
+		return []int{year}, nil
+	}
+	var years []int
+	for year := p.FirstYear; year <= time.Now().Year(); year++ {
+		years = append(years, year)
+	}
+	return years, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/geomodulus/torontobot/opendata"
+)
+
+// testCKAN points discovery at a stand-in CKAN portal answering package_show with the packages in
+// testdata/ckan, for the rest of the test.
+func testCKAN(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/3/action/package_show" {
+			http.NotFound(w, r)
+			return
+		}
+		pkg, err := os.ReadFile(filepath.Join("testdata", "ckan", filepath.Base(r.URL.Query().Get("id"))+".json"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success": false, "error": {"__type": "Not Found Error", "message": "Not found"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(pkg)
+	}))
+	t.Cleanup(srv.Close)
+	prev := ckan
+	ckan = opendata.NewClient(opendata.WithBaseURL(srv.URL), opendata.WithRetries(0, 0))
+	t.Cleanup(func() { ckan = prev })
+}
+
+func TestCKANPackageResources(t *testing.T) {
+	testCKAN(t)
+	const download = "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/"
+	ase := download + "ase/resource/ase-monthly-charges/download/ase-monthly-charges.xlsx"
+	var aseYears []*Resource
+	for year := 2020; year <= time.Now().Year(); year++ {
+		aseYears = append(aseYears, &Resource{Year: year, URL: ase, Modified: time.Date(2024, 1, 10, 8, 30, 0, 0, time.UTC)})
+	}
+
+	for _, test := range []struct {
+		dataset string
+		want    []*Resource
+	}{{
+		// The CSV copy and readme are left out, and the 2023 spreadsheet's modified time falls back to
+		// the package's.
+		dataset: "operating-budget",
+		want: []*Resource{{
+			Year:     2022,
+			URL:      download + "budget/resource/operating-budget-2022/download/approved-operating-budget-summary-2022.xlsx",
+			Modified: time.Date(2022, 3, 1, 9, 0, 0, 0, time.UTC),
+		}, {
+			Year:     2023,
+			URL:      download + "budget/resource/operating-budget-2023/download/approved-operating-budget-summary-2023.xlsx",
+			Hash:     "3a1f0c",
+			Modified: time.Date(2024, 2, 15, 14, 2, 11, 0, time.UTC),
+		}},
+	}, {
+		// One spreadsheet holds every year since the first.
+		dataset: "ase-tickets",
+		want:    aseYears,
+	}, {
+		// The newer of the two 2023 files is used, and the summary and data dictionary are left out.
+		dataset: "311-service-requests",
+		want: []*Resource{{
+			Year:     2018,
+			URL:      download + "311/resource/sr2018/download/311-service-requests-2018.zip",
+			Modified: time.Date(2019, 1, 5, 6, 0, 0, 0, time.UTC),
+		}, {
+			Year:     2023,
+			URL:      download + "311/resource/sr2023/download/sr2023.zip",
+			Modified: time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC),
+		}},
+	}} {
+		t.Run(test.dataset, func(t *testing.T) {
+			got, err := datasets[test.dataset].Resources()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d resources, want %d: %+v", len(got), len(test.want), got)
+			}
+			for i, want := range test.want {
+				if *got[i] != *want {
+					t.Errorf("resource %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCKANPackageNotFound(t *testing.T) {
+	testCKAN(t)
+	p := &ckanPackage{ID: "no-such-package", Name: serviceRequestsPackage.Name, Format: "ZIP"}
+	if _, err := p.resources(); err == nil {
+		t.Errorf("resources of a missing package returned no error")
+	}
+}
@@ -1,18 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/csv"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+func init() {
+	register(condoApartmentPrice{})
+}
+
 var condoApartmentFiles = map[int]string{
 	2023: "https://www150.statcan.gc.ca/t1/tbl1/en/dtl!downloadDbLoadingData-nonTraduit.action?pid=1810027302&latestN=0&startDate=20230101&endDate=20231001&csvLocale=en&selectedMembers=%5B%5B%5D%5D&checkedLevels=0D1",
 	2022: "https://www150.statcan.gc.ca/t1/tbl1/en/dtl!downloadDbLoadingData-nonTraduit.action?pid=1810027302&latestN=0&startDate=20220101&endDate=20221001&csvLocale=en&selectedMembers=%5B%5B%5D%5D&checkedLevels=0D1",
@@ -38,64 +39,44 @@ func convertToQuarter(dateTime time.Time) (string, error) {
 	}
 }
 
-func processCondoApartmentPriceYear(db *sql.DB, year int, url string) error {
+// condoApartmentPrice is Statistics Canada's quarterly New Condominium Apartment Price Index, downloaded
+// a year at a time.
+type condoApartmentPrice struct{}
 
-	// Prepare the SQL statement for inserting datka
-	stmt, err := db.Prepare(`
-INSERT INTO condominium_apartment_price
-(record_period, record_start_month, record_end_month, year, geolocation, price_index)
-VALUES (?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
+func (condoApartmentPrice) Name() string { return "condo-apartment-price" }
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to make HTTP request: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: %d %s", resp.StatusCode, resp.Status)
-	}
-	defer resp.Body.Close()
-
-	// Fetch attachment
-	contentDisposition := resp.Header.Get("Content-Disposition")
-	filename := "downloaded.csv"
-	if strings.HasPrefix(contentDisposition, "attachment;filename=\"") {
-		filename = strings.TrimPrefix(contentDisposition, "attachment;filename=\"")
-		filename = strings.TrimSuffix(filename, "\"")
-	}
+func (condoApartmentPrice) Package() string {
+	return ""
+}
 
-	// Create file locally
-	file, err := os.Create(filename)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return fmt.Errorf("failed to create local csv file: %v", err)
+func (condoApartmentPrice) Schema() *Schema {
+	return &Schema{
+		Table:   "condominium_apartment_price",
+		Columns: []string{"record_period", "record_start_month", "record_end_month", "year", "geolocation", "price_index"},
+		Key:     []string{"geolocation", "year", "record_period"},
 	}
-	defer file.Close()
+}
 
-	// Copy the CSV content from response body to the local file
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return fmt.Errorf("failed to copy to CSV file: %v", err)
-	}
+func (condoApartmentPrice) Resources() ([]*Resource, error) {
+	return yearResources(condoApartmentFiles), nil
+}
+
+func (condoApartmentPrice) Fetch(res *Resource) (*Download, error) {
+	return fetchURL(res)
+}
 
-	// Open the CSV file
-	f, err := os.Open(filename)
-	csvReader := csv.NewReader(f)
+func (condoApartmentPrice) Parse(year int, data []byte) ([][]interface{}, error) {
+	csvReader := csv.NewReader(bytes.NewReader(data))
 	csvReader.LazyQuotes = true
 
 	// Read the CSV file
 	records, err := csvReader.ReadAll()
-
 	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %v", err)
+		return nil, fmt.Errorf("failed to read CSV file: %v", err)
 	}
 
 	// Iterate through the rows, skipping the header row
+	var rows [][]interface{}
 	// record_period, record_start_month, record_end_month, year, geolocation, price_index
 	var recordStartMonthIdx, geolocationIdx, condoApartmentPriceValueIdx = -1, -1, -1
 	// "REF_DATE","GEO","DGUID","UOM","UOM_ID","SCALAR_FACTOR","SCALAR_ID","VECTOR","COORDINATE","VALUE","STATUS","SYMBOL","TERMINATED","DECIMALS"
@@ -166,25 +147,19 @@ VALUES (?, ?, ?, ?, ?, ?)
 
 		// fmt.Println("add r", record_period, record_start_month, record_end_month, geolocation, year, price_index)
 
-		// Execute the prepared statement with the row data
-		if _, err = stmt.Exec(
+		rows = append(rows, []interface{}{
 			record_period,
 			record_start_month,
 			record_end_month,
 			year,
 			geolocation,
-			price_index); err != nil {
-			return fmt.Errorf("failed to insert data in row %d: %v", i+1, err)
-		}
+			price_index,
+		})
 	}
 
-	// Delete the CSV file after use
-	e := os.Remove(filename)
-	if e != nil {
-		return fmt.Errorf("failed to delete CSV file: %v", err)
-	}
-
-	fmt.Printf("%d condo apartment price imported.\n", year)
+	return rows, nil
+}
 
-	return nil
+func (d condoApartmentPrice) Load(tx *sql.Tx, year int, rows [][]interface{}) error {
+	return replaceYear(tx, d.Schema(), year, rows)
 }
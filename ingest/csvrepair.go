@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxStrayDelimiters is how many stray delimiters a record may have and still be repaired.
+const maxStrayDelimiters = 3
+
+// quarantineDir is where quarantine reports of repaired and skipped CSV records are written.
+var quarantineDir = "quarantine"
+
+// CSVColumn describes what's known about a column of a CSV file, for repairing its records.
+type CSVColumn struct {
+	// Valid, if set, reports whether a value fits the column. Repairs which would leave a value that
+	// doesn't aren't made.
+	Valid func(string) bool
+	// Known, if set, is the column's vocabulary of known values. Repairs which join fields into a known
+	// value are preferred. It can be filled in from the file itself with Learn.
+	Known map[string]bool
+}
+
+// CSVRepairer repairs CSV records whose fields were split by delimiters which should have been quoted,
+// e.g. a division of "Parks, Forestry & Recreation" written without quotes.
+//
+// Records with as many fields as the header pass through untouched. Records with more are repaired by
+// joining adjacent fields back together, in the one way which leaves every column valid and best fits
+// what's known about it: joining into a known value counts most, then joining a field that starts with a
+// space, as text after a stray ", " does. Records which can't be repaired that way are quarantined,
+// left out of the data. Every repaired and quarantined record is written to a quarantine report.
+type CSVRepairer struct {
+	// Name names the report, e.g. "311-service-requests-2023".
+	Name    string
+	Columns []CSVColumn
+
+	header      []string
+	report      *csv.Writer
+	file        *os.File
+	repaired    int
+	quarantined int
+}
+
+// Learn adds the values of a record which needs no repair to the vocabularies of the columns which
+// have them, so records split elsewhere in the file can be repaired into values seen unsplit.
+func (r *CSVRepairer) Learn(record []string) {
+	if len(record) != len(r.Columns) {
+		return
+	}
+	for i, column := range r.Columns {
+		if column.Known != nil {
+			column.Known[record[i]] = true
+		}
+	}
+}
+
+// learns reports whether any of the repairer's columns has a vocabulary to learn.
+func (r *CSVRepairer) learns() bool {
+	for _, column := range r.Columns {
+		if column.Known != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckHeader checks that a CSV file's header has a column for each of the repairer's columns.
+func (r *CSVRepairer) CheckHeader(header []string) error {
+	if len(header) != len(r.Columns) {
+		return fmt.Errorf("expected %d columns, but header has %d: %q", len(r.Columns), len(header), header)
+	}
+	r.header = append([]string(nil), header...)
+	return nil
+}
+
+// Repair returns the record, repaired if need be, or nil if it's been quarantined. line is the line the
+// record starts on, for the report.
+func (r *CSVRepairer) Repair(line int, record []string) ([]string, error) {
+	if len(record) == len(r.Columns) {
+		return record, nil
+	}
+	repaired, reason := r.rejoin(record)
+	if repaired == nil {
+		r.quarantined++
+		return nil, r.write(line, "quarantined", reason, record, nil)
+	}
+	r.repaired++
+	return repaired, r.write(line, "repaired", reason, record, repaired)
+}
+
+// rejoin finds the best way of joining the fields of a record with too many, returning the repaired
+// record and how it was repaired, or nil and why it can't be.
+func (r *CSVRepairer) rejoin(record []string) ([]string, string) {
+	extra := len(record) - len(r.Columns)
+	switch {
+	case extra < 0:
+		return nil, fmt.Sprintf("%d fields, too few for %d columns", len(record), len(r.Columns))
+	case extra > maxStrayDelimiters:
+		return nil, fmt.Sprintf("%d fields, too many to repair for %d columns", len(record), len(r.Columns))
+	}
+
+	var (
+		best      []string
+		bestScore int
+		bestJoins []int
+		tied      bool
+		// fits is whether any way of joining the fields fits the columns, even without evidence for it.
+		fits bool
+	)
+	// joins[i] is how many extra fields are joined into column i.
+	joins := make([]int, len(r.Columns))
+	var try func(col, left int)
+	try = func(col, left int) {
+		if col == len(r.Columns)-1 {
+			joins[col] = left
+			repaired, score, ok := r.score(record, joins)
+			fits = fits || ok
+			switch {
+			case !ok || score == 0:
+			case score > bestScore:
+				best, bestScore, bestJoins, tied = repaired, score, append([]int(nil), joins...), false
+			case score == bestScore:
+				tied = true
+			}
+			return
+		}
+		for n := 0; n <= left; n++ {
+			joins[col] = n
+			try(col+1, left-n)
+		}
+	}
+	try(0, extra)
+
+	switch {
+	case best == nil && fits:
+		return nil, fmt.Sprintf("%d fields, and no way of joining them into %d columns is known to be right", len(record), len(r.Columns))
+	case best == nil:
+		return nil, fmt.Sprintf("%d fields, and no way of joining them fits the %d columns", len(record), len(r.Columns))
+	case tied:
+		return nil, fmt.Sprintf("%d fields, and more than one way of joining them fits the %d columns", len(record), len(r.Columns))
+	}
+	var joined []string
+	for col, n := range bestJoins {
+		if n > 0 {
+			joined = append(joined, r.header[col])
+		}
+	}
+	return best, "joined fields in " + strings.Join(joined, ", ")
+}
+
+// score joins the fields of a record as joins says, returning the result and how well it fits the
+// columns, or false if it doesn't fit them at all. Joins with no evidence for them score 0, which is
+// never good enough.
+func (r *CSVRepairer) score(record []string, joins []int) ([]string, int, bool) {
+	repaired := make([]string, len(r.Columns))
+	var score, i int
+	for col, column := range r.Columns {
+		fields := record[i : i+1+joins[col]]
+		i += len(fields)
+		value := strings.Join(fields, ",")
+		if column.Valid != nil && !column.Valid(value) {
+			return nil, 0, false
+		}
+		if len(fields) > 1 {
+			if column.Known[value] {
+				score += 2 * (len(fields) - 1)
+			}
+			for _, field := range fields[1:] {
+				if strings.HasPrefix(field, " ") {
+					score++
+				}
+			}
+		}
+		repaired[col] = value
+	}
+	return repaired, score, true
+}
+
+// write adds a record to the quarantine report, creating it if need be.
+func (r *CSVRepairer) write(line int, action, reason string, record, repaired []string) error {
+	if r.report == nil {
+		if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+			return fmt.Errorf("failed to create quarantine directory: %v", err)
+		}
+		f, err := os.Create(filepath.Join(quarantineDir, r.Name+".csv"))
+		if err != nil {
+			return fmt.Errorf("failed to create quarantine report: %v", err)
+		}
+		r.file, r.report = f, csv.NewWriter(f)
+		r.report.Write([]string{"line", "action", "reason", "record", "repaired"})
+	}
+	var repairedRecord string
+	if repaired != nil {
+		repairedRecord = joinRecord(repaired)
+	}
+	return r.report.Write([]string{fmt.Sprint(line), action, reason, joinRecord(record), repairedRecord})
+}
+
+// Close finishes the quarantine report, if there is one, logging how many records were repaired or
+// quarantined.
+func (r *CSVRepairer) Close() error {
+	if r.report == nil {
+		return nil
+	}
+	r.report.Flush()
+	err := r.report.Error()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.report = nil
+	log.Printf("%s: repaired %d records and quarantined %d, see %s\n", r.Name, r.repaired, r.quarantined, r.file.Name())
+	return err
+}
+
+// joinRecord writes a record as a line of CSV, quoting fields as needed.
+func joinRecord(record []string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(record)
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
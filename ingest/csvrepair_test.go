@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRepairer returns a repairer for records of a date, a division and a note, with the given
+// divisions known.
+func testRepairer(t *testing.T, divisions ...string) *CSVRepairer {
+	t.Helper()
+	known := map[string]bool{}
+	for _, d := range divisions {
+		known[d] = true
+	}
+	r := &CSVRepairer{
+		Name: "test",
+		Columns: []CSVColumn{
+			{Valid: func(v string) bool { return strings.HasPrefix(v, "2023-") }},
+			{Known: known},
+			{},
+		},
+	}
+	if err := r.CheckHeader([]string{"Date", "Division", "Note"}); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCSVRepairerRepair(t *testing.T) {
+	quarantineDir = t.TempDir()
+	for _, test := range []struct {
+		name   string
+		record []string
+		// want is the repaired record, or nil if it should be quarantined.
+		want   []string
+		reason string
+	}{{
+		name:   "complete",
+		record: []string{"2023-01-01", "Transportation Services", "pothole"},
+		want:   []string{"2023-01-01", "Transportation Services", "pothole"},
+	}, {
+		name:   "known value",
+		record: []string{"2023-01-01", "Parks", "Forestry & Recreation", "tree"},
+		want:   []string{"2023-01-01", "Parks,Forestry & Recreation", "tree"},
+		reason: "joined fields in Division",
+	}, {
+		name:   "leading space",
+		record: []string{"2023-01-01", "Transfer", " Disposal & Operations", "bin"},
+		want:   []string{"2023-01-01", "Transfer, Disposal & Operations", "bin"},
+		reason: "joined fields in Division",
+	}, {
+		name:   "known value beats leading space",
+		record: []string{"2023-01-01", "Parks", "Forestry & Recreation", " tree", " branch"},
+		want:   []string{"2023-01-01", "Parks,Forestry & Recreation", " tree, branch"},
+		reason: "joined fields in Division, Note",
+	}, {
+		name:   "no evidence",
+		record: []string{"2023-01-01", "Solid Waste", "Management", "bin"},
+		reason: "no way of joining them into 3 columns is known to be right",
+	}, {
+		name:   "tied",
+		record: []string{"2023-01-01", "Solid Waste", " Management", " bin"},
+		reason: "more than one way of joining them fits",
+	}, {
+		name:   "invalid",
+		record: []string{"Jan 1", " 2023", "Solid Waste", "bin"},
+		reason: "no way of joining them fits the 3 columns",
+	}, {
+		name:   "too few",
+		record: []string{"2023-01-01", "Solid Waste"},
+		reason: "2 fields, too few for 3 columns",
+	}, {
+		name:   "too many",
+		record: []string{"2023-01-01", "a", " b", " c", " d", " e", " f"},
+		reason: "7 fields, too many to repair for 3 columns",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			r := testRepairer(t, "Parks,Forestry & Recreation")
+			got, err := r.Repair(1, test.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Repair(%q) = %q, want %q", test.record, got, test.want)
+			}
+			if test.reason == "" {
+				return
+			}
+			_, reason := r.rejoin(test.record)
+			if !strings.Contains(reason, test.reason) {
+				t.Errorf("Repair(%q) reason %q, want it to contain %q", test.record, reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestCSVRepairerLearn(t *testing.T) {
+	r := testRepairer(t)
+	split := []string{"2023-01-01", "Parks", "Forestry & Recreation", "tree"}
+	if got, _ := r.rejoin(split); got != nil {
+		t.Fatalf("rejoin(%q) before learning = %q, want nil", split, got)
+	}
+
+	r.Learn([]string{"2023-01-02", "Parks,Forestry & Recreation", "leaves"})
+	// Records which need repair aren't learned from.
+	r.Learn([]string{"2023-01-03", "Solid", "Waste", "bin"})
+	if r.Columns[1].Known["Solid"] {
+		t.Errorf("learned a value from a record with too many fields")
+	}
+
+	want := []string{"2023-01-01", "Parks,Forestry & Recreation", "tree"}
+	if got, _ := r.rejoin(split); !reflect.DeepEqual(got, want) {
+		t.Errorf("rejoin(%q) after learning = %q, want %q", split, got, want)
+	}
+}
+
+func TestCSVRepairerReport(t *testing.T) {
+	quarantineDir = filepath.Join(t.TempDir(), "quarantine")
+	r := testRepairer(t)
+
+	// Records which need no repair don't start a report.
+	if _, err := r.Repair(2, []string{"2023-01-01", "Transportation Services", "pothole"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(quarantineDir); !os.IsNotExist(err) {
+		t.Fatalf("quarantine directory created without any repairs: %v", err)
+	}
+
+	for i, record := range [][]string{
+		{"2023-01-01", "Transfer", " Disposal & Operations", "bin"},
+		{"2023-01-01", "Solid Waste"},
+	} {
+		if _, err := r.Repair(i+3, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing again is harmless.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(quarantineDir, "test.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"line", "action", "reason", "record", "repaired"},
+		{"3", "repaired", "joined fields in Division", `2023-01-01,Transfer," Disposal & Operations",bin`, `2023-01-01,"Transfer, Disposal & Operations",bin`},
+		{"4", "quarantined", "2 fields, too few for 3 columns", "2023-01-01,Solid Waste", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %q, want %q", got, want)
+	}
+	if r.repaired != 1 || r.quarantined != 1 {
+		t.Errorf("repaired %d and quarantined %d records, want 1 of each", r.repaired, r.quarantined)
+	}
+}
+
+func TestStreamZippedCSV(t *testing.T) {
+	quarantineDir = t.TempDir()
+	// The first record's division is only known from the quoted one later in the file.
+	content := "Date,Division,Note\n" +
+		"2023-01-01,Parks,Forestry & Recreation,tree\n" +
+		"2023-01-02,Solid Waste,bin\n" +
+		"2023-01-03,\"Parks,Forestry & Recreation\",leaves\n" +
+		"2023-01-04,Solid Waste\n"
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("sr2023.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := testRepairer(t)
+	var got [][]string
+	data := buf.Bytes()
+	if err := StreamZippedCSV(bytes.NewReader(data), int64(len(data)), r, func(record []string) error {
+		got = append(got, append([]string(nil), record...))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"2023-01-01", "Parks,Forestry & Recreation", "tree"},
+		{"2023-01-02", "Solid Waste", "bin"},
+		{"2023-01-03", "Parks,Forestry & Recreation", "leaves"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+	if r.repaired != 1 || r.quarantined != 1 {
+		t.Errorf("repaired %d and quarantined %d records, want 1 of each", r.repaired, r.quarantined)
+	}
+}
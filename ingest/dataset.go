@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+// Dataset is a source of open data loaded into a table of the database. Each dataset lives in its own
+// file and registers itself from init, so adding one doesn't touch anything else.
+type Dataset interface {
+	// Name is the name the dataset is ingested by on the command line, e.g. "operating-budget".
+	Name() string
+	// Package is the ID of the open data portal package the dataset is published in, or "" if it
+	// isn't published there.
+	Package() string
+	// Schema describes the table the dataset is loaded into.
+	Schema() *Schema
+	// Resources returns the files the dataset is published as, one per year.
+	Resources() ([]*Resource, error)
+	// Fetch downloads a resource.
+	Fetch(res *Resource) (*Download, error)
+	// Parse parses a year's resource into rows of values for the schema's columns.
+	Parse(year int, data []byte) ([][]interface{}, error)
+	// Load writes a year's rows to the database, replacing any loaded before, in the transaction the
+	// year is ingested in.
+	Load(tx *sql.Tx, year int, rows [][]interface{}) error
+}
+
+// Schema describes the table a dataset is loaded into. Tables are created by the migrations in
+// db/migrations, and must have a year column.
+type Schema struct {
+	Table string
+	// Columns are the columns loaded, in the order Parse returns their values.
+	Columns []string
+	// Key, if set, is the natural key of a row, with a unique index on it. Rows repeated in the source
+	// data are updated rather than inserted twice. Datasets whose rows can legitimately repeat have
+	// no key.
+	Key []string
+}
+
+// Resource is the file a year of a dataset is published as.
+type Resource struct {
+	Year int
+	URL  string
+	// Hash and Modified are what the open data portal publishes about the resource, for datasets
+	// discovered there.
+	Hash     string
+	Modified time.Time
+	// Previous is the last successful ingest of the resource, when refreshing. Resources unchanged
+	// since aren't loaded again.
+	Previous *uq.IngestRun
+}
+
+// errUnchanged is returned when a resource hasn't changed since it was last ingested.
+var errUnchanged = errors.New("unchanged since last ingested")
+
+// unchanged reports whether the portal's metadata shows the resource hasn't changed since it was
+// last ingested.
+func (res *Resource) unchanged() bool {
+	prev := res.Previous
+	switch {
+	case prev == nil || prev.URL != res.URL:
+		return false
+	case res.Hash != "":
+		return res.Hash == prev.SourceHash
+	case !res.Modified.IsZero():
+		return res.Modified.Before(prev.StartedAt)
+	}
+	return false
+}
+
+// Download is a fetched resource.
+type Download struct {
+	Data []byte
+	// File, if set, is the temporary file the resource was spooled to instead of being read into Data,
+	// and Size its size. It's removed by Close.
+	File *os.File
+	Size int64
+	// SHA256 is the hex checksum of the resource.
+	SHA256 string
+	// ETag and LastModified are the HTTP headers the resource was served with, if any.
+	ETag         string
+	LastModified string
+}
+
+// Close removes the file the download was spooled to, if any.
+func (dl *Download) Close() error {
+	if dl.File == nil {
+		return nil
+	}
+	dl.File.Close()
+	return os.Remove(dl.File.Name())
+}
+
+var datasets = map[string]Dataset{}
+
+// register makes a dataset available to ingest. It's called from the init function of each dataset's
+// file.
+func register(d Dataset) {
+	if _, ok := datasets[d.Name()]; ok {
+		log.Fatalf("Dataset %q registered twice", d.Name())
+	}
+	datasets[d.Name()] = d
+}
+
+// registeredDatasets returns every registered dataset, sorted by name.
+func registeredDatasets() []Dataset {
+	list := make([]Dataset, 0, len(datasets))
+	for _, d := range datasets {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// yearResources returns resources for a map of years to URLs, oldest first.
+func yearResources(files map[int]string) []*Resource {
+	var resources []*Resource
+	for year, url := range files {
+		resources = append(resources, &Resource{Year: year, URL: url})
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Year < resources[j].Year
+	})
+	return resources
+}
+
+// ingest fetches, parses and loads a dataset, for one year or every year if year is 0.
+func ingest(db *sql.DB, d Dataset, year int) error {
+	resources, err := d.Resources()
+	if err != nil {
+		return fmt.Errorf("listing resources: %v", err)
+	}
+	f := &fetcher{d: d}
+	defer f.close()
+	found := false
+	for _, res := range resources {
+		if year != 0 && res.Year != year {
+			continue
+		}
+		found = true
+		if err := ingestResource(db, f, res); err != nil {
+			return fmt.Errorf("processing %d: %v", res.Year, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("no data for year %d", year)
+	}
+	return nil
+}
+
+// ingestResource ingests a year of a dataset, recording the run in the ingest_runs ledger whether it
+// succeeds or not. If the resource has a previous ingest and hasn't changed since, it isn't loaded
+// again.
+func ingestResource(db *sql.DB, f *fetcher, res *Resource) error {
+	d := f.d
+	run := &uq.IngestRun{
+		Dataset:    d.Name(),
+		TableName:  d.Schema().Table,
+		Year:       res.Year,
+		URL:        res.URL,
+		SourceHash: res.Hash,
+		StartedAt:  time.Now(),
+	}
+	err := loadResource(db, f, res, run)
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt)
+	switch {
+	case errors.Is(err, errUnchanged):
+		run.Status = uq.IngestUnchanged
+		fmt.Printf("%d %s unchanged.\n", res.Year, d.Name())
+		err = nil
+	case err != nil:
+		run.Status = uq.IngestFailed
+		run.Error = err.Error()
+	default:
+		run.Status = uq.IngestSucceeded
+	}
+	if _, rerr := uq.RecordIngestRun(db, run); rerr != nil {
+		log.Printf("Error recording ingest run: %v", rerr)
+	}
+	return err
+}
+
+// loadResource fetches, parses and loads a year of a dataset, filling in what it learns about the run.
+func loadResource(db *sql.DB, f *fetcher, res *Resource, run *uq.IngestRun) error {
+	d := f.d
+	cp, err := uq.GetIngestCheckpoint(db, d.Name(), res.Year)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	if cp != nil {
+		// The year was left part loaded, so it's loaded again whether or not it's changed since it was
+		// last loaded in full.
+		res.Previous = nil
+	}
+	if res.unchanged() {
+		return errUnchanged
+	}
+	log.Printf("Fetching %s for %d from %s\n", d.Name(), res.Year, res.URL)
+	dl, err := f.fetch(res)
+	if err != nil {
+		return err
+	}
+	run.SHA256, run.ETag, run.LastModified = dl.SHA256, dl.ETag, dl.LastModified
+	if res.Previous != nil && res.Previous.SHA256 == run.SHA256 {
+		return errUnchanged
+	}
+
+	if sd, ok := d.(StreamingDataset); ok {
+		return streamResource(db, sd, res, dl, cp, run)
+	}
+
+	rows, err := d.Parse(res.Year, dl.Data)
+	if err != nil {
+		return err
+	}
+	log.Println("for year", res.Year, "importing", len(rows), "rows")
+
+	// Load the year in one transaction, so a failure leaves what was loaded before untouched and
+	// ingest can simply be run again.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := d.Load(tx, res.Year, rows); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", d.Schema().Table)).Scan(&run.TableRows); err != nil {
+		return fmt.Errorf("failed to count rows: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %v", err)
+	}
+	run.RowsLoaded = len(rows)
+	fmt.Printf("%d %s imported.\n", res.Year, d.Name())
+	return nil
+}
+
+// fetcher fetches the resources of a dataset, keeping the last one downloaded so years published in
+// the same file, which come one after another, download it once.
+type fetcher struct {
+	d  Dataset
+	dl *Download
+	// url is the URL dl was downloaded from.
+	url string
+}
+
+// fetch downloads a resource, unless it was the last one downloaded.
+func (f *fetcher) fetch(res *Resource) (*Download, error) {
+	if f.dl != nil && f.url == res.URL {
+		return f.dl, nil
+	}
+	f.close()
+	dl, err := f.d.Fetch(res)
+	if err != nil {
+		return nil, err
+	}
+	f.dl, f.url = dl, res.URL
+	return dl, nil
+}
+
+// close closes the last download, if there is one.
+func (f *fetcher) close() {
+	if f.dl != nil {
+		f.dl.Close()
+		f.dl = nil
+	}
+}
+
+// fetchURL downloads a resource, for datasets published as plain downloads. If the resource has a
+// previous ingest, the request is conditional on it having changed since, returning errUnchanged if
+// it hasn't.
+func fetchURL(res *Resource) (*Download, error) {
+	resp, err := getURL(res)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP response: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return &Download{
+		Data:         data,
+		Size:         int64(len(data)),
+		SHA256:       hex.EncodeToString(sum[:]),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// getURL requests a resource, conditionally on it having changed since its previous ingest if it has
+// one. The caller must close the response body.
+func getURL(res *Resource) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, res.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prev := res.Previous; prev != nil && prev.URL == res.URL {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, errUnchanged
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status: %d %s", resp.StatusCode, resp.Status)
+	}
+	return resp, nil
+}
+
+// replaceYear replaces a year of a dataset's table with rows: it deletes the year's rows, then inserts
+// the new ones, upserting on the schema's natural key if it has one.
+func replaceYear(tx *sql.Tx, schema *Schema, year int, rows [][]interface{}) error {
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE year = ?", schema.Table), year); err != nil {
+		return fmt.Errorf("failed to delete %d rows: %v", year, err)
+	}
+
+	stmt, err := tx.Prepare(insertQuery(schema, 1))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for i, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			return fmt.Errorf("failed to insert data in row %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// insertQuery returns a statement inserting n rows of a schema's columns, upserting on the schema's
+// natural key if it has one.
+func insertQuery(schema *Schema, n int) string {
+	values := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(schema.Columns)), ", ") + ")"
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES %s",
+		schema.Table,
+		strings.Join(schema.Columns, ", "),
+		strings.TrimSuffix(strings.Repeat(values+", ", n), ", "),
+	)
+	if len(schema.Key) > 0 {
+		query += upsertClause(schema)
+	}
+	return query
+}
+
+// upsertClause updates the existing row when an insert conflicts on a dataset's key.
+func upsertClause(schema *Schema) string {
+	var updates []string
+	for _, col := range schema.Columns {
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", col, col))
+	}
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(schema.Key, ", "), strings.Join(updates, ", "))
+}
+
+// copyQuery builds an INSERT copying every row of another table with the same columns into a
+// dataset's table, updating rather than repeating rows with the same key.
+func copyQuery(schema *Schema, from string) string {
+	cols := strings.Join(schema.Columns, ", ")
+	// The WHERE clause keeps SQLite from parsing ON CONFLICT as part of the SELECT.
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE true", schema.Table, cols, cols, from)
+	if len(schema.Key) > 0 {
+		query += upsertClause(schema)
+	}
+	return query
+}
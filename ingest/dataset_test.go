@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+// sharedFileDataset loads a count for each year of a file which holds every year, like the ASE
+// charges spreadsheet.
+type sharedFileDataset struct {
+	url   string
+	years []int
+}
+
+func (sharedFileDataset) Name() string    { return "shared-file" }
+func (sharedFileDataset) Package() string { return "" }
+
+func (sharedFileDataset) Schema() *Schema {
+	return &Schema{Table: "numbers", Columns: []string{"n", "year"}}
+}
+
+func (d sharedFileDataset) Resources() ([]*Resource, error) {
+	var resources []*Resource
+	for _, year := range d.years {
+		resources = append(resources, &Resource{Year: year, URL: d.url})
+	}
+	return resources, nil
+}
+
+func (sharedFileDataset) Fetch(res *Resource) (*Download, error) {
+	return fetchURL(res)
+}
+
+func (sharedFileDataset) Parse(year int, data []byte) ([][]interface{}, error) {
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return [][]interface{}{{n, year}}, nil
+}
+
+func (d sharedFileDataset) Load(tx *sql.Tx, year int, rows [][]interface{}) error {
+	return replaceYear(tx, d.Schema(), year, rows)
+}
+
+func TestIngestSharedFile(t *testing.T) {
+	var downloads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		w.Write([]byte("42"))
+	}))
+	defer srv.Close()
+
+	db := testutil.DB(t)
+	if _, err := db.Exec("CREATE TABLE numbers (n INTEGER, year INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	d := sharedFileDataset{url: srv.URL + "/charges.xlsx", years: []int{2020, 2021, 2022}}
+	if err := ingest(db, d, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("downloaded the file %d times for %d years, want once", n, len(d.years))
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers WHERE n = 42"); n != len(d.years) {
+		t.Errorf("loaded %d years, want %d", n, len(d.years))
+	}
+
+	// A refresh asks once whether the file has changed.
+	atomic.StoreInt32(&downloads, 0)
+	if err := refresh(db, d); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("refresh downloaded the file %d times for %d years, want once", n, len(d.years))
+	}
+}
@@ -3,14 +3,29 @@ package main
 import (
 	"database/sql"
 	"flag"
-	_ "github.com/mattn/go-sqlite3"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	_ "github.com/mattn/go-sqlite3"
+
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/opendata"
 )
 
 func main() {
 	dbFile := flag.String("db-file", "../db/toronto.db", "Database file for tabular city data")
 	year := flag.Int("year", 0, "Year to process")
+	ckanURL := flag.String("ckan-url", opendata.DefaultBaseURL, "Base URL of the CKAN open data portal datasets are discovered in")
+	flag.StringVar(&quarantineDir, "quarantine-dir", quarantineDir, "Directory to write reports of repaired and quarantined CSV records to")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage())
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	ckan = opendata.NewClient(opendata.WithBaseURL(*ckanURL))
 
 	// Connect to the SQLite database
 	db, err := sql.Open("sqlite3", *dbFile)
@@ -19,113 +34,76 @@ func main() {
 	}
 	defer db.Close()
 
-	switch flag.Arg(0) {
-	case "311-service-requests":
-		if *year != 0 {
-			url, ok := serviceRequestsFiles[*year]
-			if !ok {
-				log.Fatalf("No data for year %d", *year)
-			}
-			if err := process311ServiceRequestsYear(db, *year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", *year, err)
-			}
-		} else {
-			for year, url := range serviceRequestsFiles {
-				if err := process311ServiceRequestsYear(db, year, url); err != nil {
-					log.Fatalf("Error processing %d: %v", year, err)
-				}
-			}
+	switch name := flag.Arg(0); name {
+	case "list":
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"Dataset", "Table", "Years", "Last refreshed"})
+		for _, d := range registeredDatasets() {
+			years := "unknown"
+			if resources, err := d.Resources(); err == nil && len(resources) > 0 {
+				years = fmt.Sprintf("%d-%d", resources[0].Year, resources[len(resources)-1].Year)
+			}
+			refreshed, err := uq.LastRefreshed(db, d.Schema().Table)
+			if err != nil {
+				log.Fatalf("Error reading ingest runs: %v", err)
+			}
+			lastRefreshed := "never"
+			if !refreshed.IsZero() {
+				lastRefreshed = refreshed.Local().Format("2006-01-02 15:04")
+			}
+			tw.AppendRow(table.Row{d.Name(), d.Schema().Table, years, lastRefreshed})
 		}
-	case "operating-budget":
-		if *year != 0 {
-			url, ok := operatingBudgetFiles[*year]
-			if !ok {
-				log.Fatalf("No data for year %d", *year)
-			}
-			if err := processOperatingBudgetYear(db, *year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", *year, err)
-			}
-		} else {
-			for year, url := range operatingBudgetFiles {
-				if err := processOperatingBudgetYear(db, year, url); err != nil {
-					log.Fatalf("Error processing %d: %v", year, err)
-				}
-			}
-		}
-	case "ase-tickets":
-		if *year != 0 {
-			url, ok := aseTicketsFiles[*year]
-			if !ok {
-				log.Fatalf("No data for year %d", *year)
-			}
-			if err := processASETicketsYear(db, *year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", *year, err)
-			}
-		} else {
-			for year, url := range aseTicketsFiles {
-				if err := processASETicketsYear(db, year, url); err != nil {
-					log.Fatalf("Error processing %d: %v", year, err)
-				}
-			}
-		}
-	case "condo-apartment-price":
-		if *year != 0 {
-			url, ok := condoApartmentFiles[*year]
-			if !ok {
-				log.Fatalf("No data for year %d", *year)
-			}
-			if err := processCondoApartmentPriceYear(db, *year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", *year, err)
-			}
-		} else {
-			for year, url := range condoApartmentFiles {
-				if err := processCondoApartmentPriceYear(db, year, url); err != nil {
-					log.Fatalf("Error processing %d: %v", year, err)
-				}
-			}
+		fmt.Println(tw.Render())
+
+	case "refresh":
+		if err := refreshAll(db); err != nil {
+			log.Fatal(err)
 		}
 
 	case "all":
-		for year, url := range operatingBudgetFiles {
-			if err := processOperatingBudgetYear(db, year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", year, err)
+		for _, d := range registeredDatasets() {
+			if err := ingest(db, d, 0); err != nil {
+				log.Fatalf("Error ingesting %s: %v", d.Name(), err)
 			}
 		}
 
-		for year, url := range serviceRequestsFiles {
-			if err := process311ServiceRequestsYear(db, year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", year, err)
-			}
+	default:
+		d, ok := datasets[name]
+		if !ok {
+			flag.Usage()
+			os.Exit(1)
 		}
-
-		for year, url := range aseTicketsFiles {
-			if err := processASETicketsYear(db, year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", year, err)
-			}
+		if err := ingest(db, d, *year); err != nil {
+			log.Fatalf("Error ingesting %s: %v", name, err)
 		}
+	}
+}
 
-		for year, url := range condoApartmentFiles {
-			if err := processCondoApartmentPriceYear(db, year, url); err != nil {
-				log.Fatalf("Error processing %d: %v", year, err)
-			}
-		}
-	default:
-		log.Fatal(`# TorontoBot Ingest
+func usage() string {
+	var names []string
+	for i, d := range registeredDatasets() {
+		names = append(names, fmt.Sprintf("  %d. %s", i+1, d.Name()))
+	}
+	return fmt.Sprintf(`# TorontoBot Ingest
 
-There are two supported datasets: 
-  1. 311-service-requests
-  2. operating-budget
-  3. ase-tickets
-  4. condo-apartment-price
+There are %d supported datasets:
+%s
 
-To ingest either one, pass the dataset name as an argument to this program. For example:
-  ./ingest 311-service-requests
+To ingest one, pass the dataset name as an argument to this program. For example:
+  ./ingest operating-budget
 
 You can scope to a single year by passing the --year flag. For example:
   ./ingest --year 2017 311-service-requests
 
+To list the datasets with the years available for each, run:
+  ./ingest list
+
 You can ingest all years for all datasets (warning: takes a while) by running:
   ./ingest all
-`)
-	}
+
+To ingest only what has changed since it was last ingested, run:
+  ./ingest refresh
+
+Flags:
+`, len(names), strings.Join(names, "\n"))
 }
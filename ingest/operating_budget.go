@@ -1,54 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
-	"log"
-	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/xuri/excelize/v2"
+
+	"github.com/geomodulus/torontobot/opendata"
 )
 
-var operatingBudgetFiles = map[int]string{
-	2023: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/a6f7a8e8-e497-4f77-9881-daba429ea981/download/approved-operating-budget-summary-2023.xlsx",
-	2022: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/9e5f9a63-fdeb-46e4-9f5f-8143038de56d/download/approved-operating-budget-summary-2022.xlsx",
-	2021: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/930502fa-87f1-4d71-8b71-4435df594b38/download/approved-operating-budget-summary-2021.xlsx",
-	2020: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/4e58558a-4773-4cd0-a16a-de481f25cb4d/download/approved-operating-budget-summary-2020.xlsx",
-	2019: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/27bc496e-1507-4d2e-b9f8-4392b1d44b5c/download/approved-operating-budget-summary-2019.xlsx",
-	2018: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/8ff2e379-2bbf-43ab-83eb-2caa7717d85b/download/approved-operating-budget-summary-2018.xlsx",
-	2017: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/393dffb4-2f7a-4ff3-8443-e44796f08782/download/approved-operating-budget-summary-2017.xlsx",
-	2016: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/d55a2458-f116-456e-a3be-4a0d867fa190/download/approved-operating-budget-summary-2016.xlsx",
-	2015: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/4a87ff46-996b-4768-b833-69e023b1b4c4/download/approved-operating-budget-summary-2015.xlsx",
-	2014: "https://ckan0.cf.opendata.inter.prod-toronto.ca/dataset/2c90a5d3-5598-4c02-abf2-169456c8f1f1/resource/7e23b244-91a7-4e70-9baf-be231ff58d59/download/approved-operating-budget-summary-2014.xlsx",
+func init() {
+	register(operatingBudget{})
 }
 
-func processOperatingBudgetYear(db *sql.DB, year int, url string) error {
-	// Prepare the SQL statement for inserting datka
-	stmt, err := db.Prepare(`
-INSERT INTO operating_budget
-(program, service, activity, entry_type, category, subcategory, item, year, amount)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
+var operatingBudgetPackage = &ckanPackage{
+	ID: opendata.OperatingBudgetDataset,
+	// e.g. "approved-operating-budget-summary-2023"
+	Name:   regexp.MustCompile(`(?i)operating.budget.*?(\d{4})$`),
+	Format: "XLSX",
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to make HTTP request: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected HTTP status: %d %s", resp.StatusCode, resp.Status)
+// operatingBudget is the City of Toronto's approved operating budget, published as a spreadsheet each
+// year.
+type operatingBudget struct{}
+
+func (operatingBudget) Name() string { return "operating-budget" }
+
+func (operatingBudget) Package() string {
+	return operatingBudgetPackage.ID
+}
+
+func (operatingBudget) Schema() *Schema {
+	return &Schema{
+		Table:   "operating_budget",
+		Columns: []string{"program", "service", "activity", "entry_type", "category", "subcategory", "item", "year", "amount"},
 	}
-	defer resp.Body.Close()
+}
 
+func (operatingBudget) Resources() ([]*Resource, error) {
+	return operatingBudgetPackage.resources()
+}
+
+func (operatingBudget) Fetch(res *Resource) (*Download, error) {
+	return fetchURL(res)
+}
+
+func (operatingBudget) Parse(year int, data []byte) ([][]interface{}, error) {
 	// Open the XLSX file
-	file, err := excelize.OpenReader(resp.Body)
+	file, err := excelize.OpenReader(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to open XLSX file: %v", err)
+		return nil, fmt.Errorf("failed to open XLSX file: %v", err)
 	}
 
 	// Get rows in the sheet with year as name.
@@ -63,11 +68,11 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	}
 	rows, err := file.GetRows(useSheet)
 	if err != nil {
-		return fmt.Errorf("failed to get rows from XLSX file: %v, have sheetlist %+v", err, file.GetSheetList())
+		return nil, fmt.Errorf("failed to get rows from XLSX file: %v, have sheetlist %+v", err, file.GetSheetList())
 	}
 
-	log.Println("for year", year, "importing", len(rows)-1, "rows")
 	// Iterate through the rows, skipping the header row
+	var records [][]interface{}
 	var programIdx, serviceIdx, activityIdx, entryTypeIdx, categoryIdx, subcategoryIdx, itemIdx, amountIdx = -1, -1, -1, -1, -1, -1, -1, -1
 	for i, row := range rows {
 		if i == 0 {
@@ -95,10 +100,10 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 			}
 			continue
 		}
-		if row[programIdx] == "0" {
+		if programIdx == -1 || entryTypeIdx == -1 || amountIdx == -1 {
 			continue
 		}
-		if programIdx == -1 || entryTypeIdx == -1 || amountIdx == -1 {
+		if row[programIdx] == "0" {
 			continue
 		}
 		program := strings.TrimSpace(row[programIdx])
@@ -126,7 +131,7 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		amountStr = strings.ReplaceAll(amountStr, ")", "")
 		amount, err := strconv.ParseFloat(amountStr, 64)
 		if err != nil {
-			return fmt.Errorf("failed to parse amount in row %d at idx %d: %v\n%+v", i+1, amountIdx, err, row)
+			return nil, fmt.Errorf("failed to parse amount in row %d at idx %d: %v\n%+v", i+1, amountIdx, err, row)
 		}
 
 		switch strings.ToLower(row[entryTypeIdx]) {
@@ -136,11 +141,10 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		case "expenses":
 			entryType = "expense"
 		default:
-			return fmt.Errorf("unknown entry type in row %d (expected revenues or expenses): %s\n%+v", i+1, row[entryTypeIdx], row)
+			return nil, fmt.Errorf("unknown entry type in row %d (expected revenues or expenses): %s\n%+v", i+1, row[entryTypeIdx], row)
 		}
 
-		// Execute the prepared statement with the row data
-		if _, err = stmt.Exec(
+		records = append(records, []interface{}{
 			program,
 			service,
 			activity,
@@ -149,11 +153,12 @@ VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 			subcategory,
 			item,
 			year,
-			amount); err != nil {
-			return fmt.Errorf("failed to insert data in row %d: %v", i+1, err)
-		}
+			amount,
+		})
 	}
-	fmt.Printf("%d operating budget imported.\n", year)
+	return records, nil
+}
 
-	return nil
+func (d operatingBudget) Load(tx *sql.Tx, year int, rows [][]interface{}) error {
+	return replaceYear(tx, d.Schema(), year, rows)
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+// refresh ingests the years of a dataset which have changed since they were last ingested. Changes are
+// detected from what the open data portal publishes about each resource, for datasets discovered
+// there, then from the HTTP validators and checksum recorded in the ingest_runs ledger.
+func refresh(db *sql.DB, d Dataset) error {
+	resources, err := d.Resources()
+	if err != nil {
+		return fmt.Errorf("listing resources: %v", err)
+	}
+	f := &fetcher{d: d}
+	defer f.close()
+	for _, res := range resources {
+		if res.Previous, err = uq.LastIngest(db, d.Name(), res.Year); err != nil {
+			return fmt.Errorf("reading ingest runs: %v", err)
+		}
+		if err := ingestResource(db, f, res); err != nil {
+			return fmt.Errorf("processing %d: %v", res.Year, err)
+		}
+	}
+	return nil
+}
+
+// refreshAll refreshes every registered dataset, carrying on past failures so one unavailable
+// dataset doesn't hold up the rest.
+func refreshAll(db *sql.DB) error {
+	var failed int
+	for _, d := range registeredDatasets() {
+		if err := refresh(db, d); err != nil {
+			log.Printf("Error refreshing %s: %v", d.Name(), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d datasets failed to refresh", failed, len(datasets))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	// insertBatchRows is how many rows are inserted by each statement of a streamed load.
+	insertBatchRows = 100
+	// checkpointRows is how many rows a streamed load commits to its staging table at a time,
+	// recording a checkpoint.
+	checkpointRows = 50000
+	// progressInterval is how often a streamed load logs its progress.
+	progressInterval = 10 * time.Second
+)
+
+// StreamingDataset is a Dataset too large to hold in memory. Its resources are spooled to a temporary
+// file by Fetch, then streamed into the database in batches rather than going through Parse and Load.
+//
+// Streamed loads go into a staging table, committing every checkpointRows rows with a checkpoint in
+// ingest_checkpoints, and replace the year's rows in the dataset's table with the staged ones in a
+// single transaction at the end. Until then the table is untouched, so a failed load leaves the year as
+// it was. If the load is interrupted, the next ingest of the year resumes from the checkpoint if the
+// resource is the same file, or starts again if not.
+type StreamingDataset interface {
+	Dataset
+	// Stream reads a year's resource of the given size from r, calling emit with each row in turn.
+	Stream(year int, r io.ReaderAt, size int64, emit func(row []interface{}) error) error
+}
+
+// spoolURL downloads a resource to a temporary file rather than into memory, making the same
+// conditional request as fetchURL.
+func spoolURL(res *Resource) (*Download, error) {
+	resp, err := getURL(res)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	f, err := os.CreateTemp("", "ingest-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	dl := &Download{
+		File:         f,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	h := sha256.New()
+	if dl.Size, err = io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		dl.Close()
+		return nil, fmt.Errorf("failed to read HTTP response: %v", err)
+	}
+	dl.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return dl, nil
+}
+
+// streamResource streams a year of a dataset from its spooled resource into the database, resuming
+// from cp if it's a checkpoint of the same file.
+func streamResource(db *sql.DB, d StreamingDataset, res *Resource, dl *Download, cp *uq.IngestCheckpoint, run *uq.IngestRun) error {
+	if dl.File == nil {
+		return fmt.Errorf("%s must be spooled to a file to be streamed", d.Name())
+	}
+	schema := d.Schema()
+	l := &batchLoader{
+		db:      db,
+		schema:  schema,
+		staging: stagingSchema(schema, res.Year),
+		cp:      &uq.IngestCheckpoint{Dataset: d.Name(), Year: res.Year, SHA256: dl.SHA256},
+		fresh:   true,
+	}
+	if cp != nil && cp.SHA256 == dl.SHA256 {
+		staged, err := tableExists(db, l.staging.Table)
+		if err != nil {
+			return err
+		}
+		if staged {
+			log.Printf("Resuming %s for %d from row %d\n", d.Name(), res.Year, cp.Offset)
+			l.cp.Offset, l.fresh = cp.Offset, false
+		}
+	}
+	defer l.rollback()
+
+	src := &countingReaderAt{r: dl.File}
+	start := time.Now()
+	p := &progress{label: fmt.Sprintf("%d %s", res.Year, d.Name()), src: src, size: dl.Size, start: start, last: start}
+	skip, n := l.cp.Offset, 0
+	err := d.Stream(res.Year, src, dl.Size, func(row []interface{}) error {
+		n++
+		p.update(n)
+		if n <= skip {
+			return nil
+		}
+		return l.add(row)
+	})
+	if err != nil {
+		return err
+	}
+	if n < skip {
+		return fmt.Errorf("resource has %d rows, fewer than the %d already loaded", n, skip)
+	}
+	if err := l.finish(); err != nil {
+		return err
+	}
+	run.RowsLoaded, run.TableRows = n, l.tableRows
+	fmt.Printf("%d %s imported.\n", res.Year, d.Name())
+	return nil
+}
+
+// stagingSchema returns the schema of the table a year of a streamed load is staged in.
+func stagingSchema(schema *Schema, year int) *Schema {
+	staging := *schema
+	staging.Table = fmt.Sprintf("%s_staging_%d", schema.Table, year)
+	return &staging
+}
+
+// tableExists reports whether the database has a table of the given name.
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %v", name, err)
+	}
+	return n > 0, nil
+}
+
+// batchLoader inserts the rows of a streamed load into its staging table in batches, committing them
+// with a checkpoint every checkpointRows rows.
+type batchLoader struct {
+	db *sql.DB
+	// schema is the dataset's table, and staging the table the load is staged in.
+	schema, staging *Schema
+	// cp is the checkpoint of the rows committed so far.
+	cp *uq.IngestCheckpoint
+	// fresh is whether the load starts from scratch, so the staging table must be created anew.
+	fresh bool
+
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	// batch holds the values of rows not yet inserted, and pending counts the rows inserted or
+	// batched since the last checkpoint.
+	batch     []interface{}
+	pending   int
+	tableRows int
+}
+
+// begin starts the transaction the next rows are loaded in.
+func (l *batchLoader) begin() error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	l.tx = tx
+	if l.fresh {
+		if err := l.createStaging(); err != nil {
+			return err
+		}
+		l.fresh = false
+	}
+	if l.stmt, err = tx.Prepare(insertQuery(l.staging, insertBatchRows)); err != nil {
+		return fmt.Errorf("failed to prepare insert: %v", err)
+	}
+	return nil
+}
+
+// createStaging creates an empty staging table with the columns of the dataset's table, and a unique
+// index on its key if it has one, replacing what was staged by any earlier load.
+func (l *batchLoader) createStaging() error {
+	stmts := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", l.staging.Table),
+		fmt.Sprintf("CREATE TABLE %s AS SELECT %s FROM %s WHERE 0", l.staging.Table, strings.Join(l.schema.Columns, ", "), l.schema.Table),
+	}
+	if len(l.schema.Key) > 0 {
+		stmts = append(stmts, fmt.Sprintf("CREATE UNIQUE INDEX %s_key ON %s (%s)", l.staging.Table, l.staging.Table, strings.Join(l.schema.Key, ", ")))
+	}
+	for _, stmt := range stmts {
+		if _, err := l.tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create staging table: %v", err)
+		}
+	}
+	return nil
+}
+
+func (l *batchLoader) add(row []interface{}) error {
+	if l.tx == nil {
+		if err := l.begin(); err != nil {
+			return err
+		}
+	}
+	l.batch = append(l.batch, row...)
+	l.pending++
+	if len(l.batch) == insertBatchRows*len(l.schema.Columns) {
+		if err := l.flush(); err != nil {
+			return err
+		}
+	}
+	if l.pending == checkpointRows {
+		return l.checkpoint()
+	}
+	return nil
+}
+
+// flush inserts the batched rows.
+func (l *batchLoader) flush() error {
+	if len(l.batch) == 0 {
+		return nil
+	}
+	var err error
+	rows := len(l.batch) / len(l.schema.Columns)
+	if rows == insertBatchRows {
+		_, err = l.stmt.Exec(l.batch...)
+	} else {
+		_, err = l.tx.Exec(insertQuery(l.staging, rows), l.batch...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert rows %d to %d: %v", l.cp.Offset+l.pending-rows+1, l.cp.Offset+l.pending, err)
+	}
+	l.batch = l.batch[:0]
+	return nil
+}
+
+// checkpoint commits the rows staged so far along with a checkpoint of them.
+func (l *batchLoader) checkpoint() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	l.cp.Offset += l.pending
+	if err := uq.SaveIngestCheckpoint(l.tx, l.cp); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	if err := l.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %v", err)
+	}
+	l.tx, l.pending = nil, 0
+	return nil
+}
+
+// finish replaces the year's rows in the dataset's table with the staged ones, dropping the staging
+// table and removing the load's checkpoint.
+func (l *batchLoader) finish() error {
+	if l.tx == nil {
+		if err := l.begin(); err != nil {
+			return err
+		}
+	}
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if _, err := l.tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE year = ?", l.schema.Table), l.cp.Year); err != nil {
+		return fmt.Errorf("failed to delete %d rows: %v", l.cp.Year, err)
+	}
+	if _, err := l.tx.Exec(copyQuery(l.schema, l.staging.Table)); err != nil {
+		return fmt.Errorf("failed to copy staged rows: %v", err)
+	}
+	if _, err := l.tx.Exec(fmt.Sprintf("DROP TABLE %s", l.staging.Table)); err != nil {
+		return fmt.Errorf("failed to drop staging table: %v", err)
+	}
+	if err := l.tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", l.schema.Table)).Scan(&l.tableRows); err != nil {
+		return fmt.Errorf("failed to count rows: %v", err)
+	}
+	if err := uq.DeleteIngestCheckpoint(l.tx, l.cp.Dataset, l.cp.Year); err != nil {
+		return fmt.Errorf("failed to delete checkpoint: %v", err)
+	}
+	if err := l.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %v", err)
+	}
+	l.tx = nil
+	return nil
+}
+
+// rollback abandons the rows staged since the last checkpoint, if the load didn't finish.
+func (l *batchLoader) rollback() {
+	if l.tx != nil {
+		l.tx.Rollback()
+	}
+}
+
+// countingReaderAt tracks how far into a spooled resource the last read reached, to report progress.
+// Resources are read through in order, though perhaps more than once.
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n = off + int64(n)
+	return n, err
+}
+
+// progress logs how far a streamed load has got every progressInterval.
+type progress struct {
+	label string
+	src   *countingReaderAt
+	size  int64
+	start time.Time
+	last  time.Time
+}
+
+func (p *progress) update(rows int) {
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	pct := 100 * float64(p.src.n) / float64(p.size)
+	if pct > 100 {
+		pct = 100
+	}
+	log.Printf("%s: %d rows read (%.0f%%), %.0f rows/s\n", p.label, rows, pct, float64(rows)/now.Sub(p.start).Seconds())
+}
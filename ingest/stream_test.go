@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+var errStreamFailed = errors.New("stream failed")
+
+// countingDataset streams the numbers 1 to rows into the numbers table, failing once it reaches
+// failAt if that's set.
+type countingDataset struct {
+	rows, failAt int
+}
+
+func (countingDataset) Name() string    { return "numbers" }
+func (countingDataset) Package() string { return "" }
+
+func (countingDataset) Schema() *Schema {
+	return &Schema{Table: "numbers", Columns: []string{"n", "year"}}
+}
+
+func (countingDataset) Resources() ([]*Resource, error)        { return nil, nil }
+func (countingDataset) Fetch(res *Resource) (*Download, error) { return nil, nil }
+
+func (countingDataset) Parse(year int, data []byte) ([][]interface{}, error) { return nil, nil }
+
+func (countingDataset) Load(tx *sql.Tx, year int, rows [][]interface{}) error { return nil }
+
+func (d countingDataset) Stream(year int, r io.ReaderAt, size int64, emit func(row []interface{}) error) error {
+	for n := 1; n <= d.rows; n++ {
+		if n == d.failAt {
+			return errStreamFailed
+		}
+		if err := emit([]interface{}{n, year}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testDownload returns a download spooled to a file, with the given checksum.
+func testDownload(t *testing.T, sha string) *Download {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return &Download{File: f, Size: 1, SHA256: sha}
+}
+
+func queryInt(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+func TestStreamResource(t *testing.T) {
+	db := testutil.DB(t)
+	if _, err := db.Exec("CREATE TABLE numbers (n INTEGER, year INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	// The year was loaded in full before, and another year is loaded too.
+	if _, err := db.Exec("INSERT INTO numbers (n, year) VALUES (1, 2023), (2, 2023), (3, 2023), (1, 2022)"); err != nil {
+		t.Fatal(err)
+	}
+	res := &Resource{Year: 2023}
+	rows := checkpointRows*2 + 123
+
+	cp, err := uq.GetIngestCheckpoint(db, "numbers", 2023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = streamResource(db, countingDataset{rows: rows, failAt: checkpointRows + 100}, res, testDownload(t, "a"), cp, &uq.IngestRun{})
+	if !errors.Is(err, errStreamFailed) {
+		t.Fatalf("streamResource returned %v, want %v", err, errStreamFailed)
+	}
+	// The failed load is staged up to its checkpoint, leaving the table as it was.
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers WHERE year = 2023"); n != 3 {
+		t.Errorf("failed load left %d rows for 2023, want the 3 loaded before", n)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers_staging_2023"); n != checkpointRows {
+		t.Errorf("failed load staged %d rows, want %d", n, checkpointRows)
+	}
+	cp, err = uq.GetIngestCheckpoint(db, "numbers", 2023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Offset != checkpointRows || cp.SHA256 != "a" {
+		t.Fatalf("checkpoint after failed load = %+v, want offset %d of a", cp, checkpointRows)
+	}
+
+	// Resuming with the same file loads the rest, and replaces the year's rows with the staged ones.
+	run := &uq.IngestRun{}
+	if err := streamResource(db, countingDataset{rows: rows}, res, testDownload(t, "a"), cp, run); err != nil {
+		t.Fatal(err)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers WHERE year = 2023"); n != rows {
+		t.Errorf("resumed load left %d rows for 2023, want %d", n, rows)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(DISTINCT n) FROM numbers WHERE year = 2023"); n != rows {
+		t.Errorf("resumed load left %d distinct rows for 2023, want %d", n, rows)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers WHERE year = 2022"); n != 1 {
+		t.Errorf("load of 2023 left %d rows for 2022, want 1", n)
+	}
+	if run.RowsLoaded != rows || run.TableRows != rows+1 {
+		t.Errorf("run loaded %d rows leaving %d, want %d leaving %d", run.RowsLoaded, run.TableRows, rows, rows+1)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'numbers_staging_2023'"); n != 0 {
+		t.Errorf("staging table left after load")
+	}
+	if cp, err := uq.GetIngestCheckpoint(db, "numbers", 2023); err != nil || cp != nil {
+		t.Errorf("checkpoint after load = %+v, %v, want none", cp, err)
+	}
+}
+
+func TestStreamResourceChanged(t *testing.T) {
+	db := testutil.DB(t)
+	if _, err := db.Exec("CREATE TABLE numbers (n INTEGER, year INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	res := &Resource{Year: 2023}
+	err := streamResource(db, countingDataset{rows: checkpointRows + 10, failAt: checkpointRows + 5}, res, testDownload(t, "a"), nil, &uq.IngestRun{})
+	if !errors.Is(err, errStreamFailed) {
+		t.Fatalf("streamResource returned %v, want %v", err, errStreamFailed)
+	}
+	cp, err := uq.GetIngestCheckpoint(db, "numbers", 2023)
+	if err != nil || cp == nil {
+		t.Fatalf("no checkpoint after failed load: %v", err)
+	}
+
+	// A checkpoint of another file is started again from scratch.
+	if err := streamResource(db, countingDataset{rows: 10}, res, testDownload(t, "b"), cp, &uq.IngestRun{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := queryInt(t, db, "SELECT COUNT(*) FROM numbers WHERE year = 2023"); n != 10 {
+		t.Errorf("load of changed file left %d rows for 2023, want 10", n)
+	}
+}
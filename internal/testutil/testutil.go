@@ -0,0 +1,75 @@
+// Package testutil has helpers shared by the tests of several packages: a database with the
+// migrations applied, and an OpenAI client replaying the cassette of recorded responses.
+package testutil
+
+import (
+	"database/sql"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/geomodulus/torontobot/cassette"
+)
+
+var record = flag.Bool("record", false, "Record the OpenAI cassette in testdata/cassette using $OPENAI_API_KEY, rather than replaying it")
+
+// root returns the repository's root directory, so helpers work from any package's tests.
+func root() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// DB returns a database with the migrations in db/migrations applied.
+func DB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	files, err := filepath.Glob(filepath.Join(root(), "db", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := func(file string) int {
+		n, _ := strconv.Atoi(strings.SplitN(filepath.Base(file), "_", 2)[0])
+		return n
+	}
+	sort.Slice(files, func(i, j int) bool { return version(files[i]) < version(files[j]) })
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(migration)); err != nil {
+			t.Fatalf("applying %s: %v", file, err)
+		}
+	}
+	return db
+}
+
+// AI returns an OpenAI client which replays the cassette in testdata/cassette, or records it when
+// the tests are run with -record.
+func AI(t *testing.T) *openai.Client {
+	t.Helper()
+	mode, token := cassette.Replay, "test"
+	if *record {
+		mode, token = cassette.Record, os.Getenv("OPENAI_API_KEY")
+	}
+	transport, err := cassette.New(filepath.Join(root(), "testdata", "cassette"), mode, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := openai.DefaultConfig(token)
+	config.HTTPClient = &http.Client{Transport: transport}
+	return openai.NewClientWithConfig(config)
+}
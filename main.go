@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -19,9 +20,16 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/geomodulus/citygraph"
+	"github.com/geomodulus/torontobot/api"
 	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/cassette"
 	uq "github.com/geomodulus/torontobot/db"
 	"github.com/geomodulus/torontobot/discord"
+	"github.com/geomodulus/torontobot/eval"
+	"github.com/geomodulus/torontobot/mcp"
+	"github.com/geomodulus/torontobot/opendata"
+	"github.com/geomodulus/torontobot/schedule"
+	"github.com/geomodulus/torontobot/slack"
 	"github.com/geomodulus/torontobot/viz"
 )
 
@@ -31,10 +39,53 @@ func main() {
 	dbFile := flag.String("db-file", "./db/toronto.db", "Database file for tabular city data")
 	discordBotToken := flag.String("discord-bot-token", "", "Token for accessing Discord API")
 	openaiToken := flag.String("openai-token", "", "Token for accessing OpenAI API")
-	headless := flag.Bool("headless", false, "Run in headless mode (no stdin, only Discord bot)")
+	headless := flag.Bool("headless", false, "Run in headless mode (no stdin, only Discord and Slack bots and HTTP API)")
 	hostname := flag.String("host", "https://torontoverse.com", "host and scheme for torontoverse server")
+	httpAddr := flag.String("http-addr", "", "Address to serve the HTTP JSON API and web UI on, e.g. :8080")
+	apiKeys := flag.String("api-keys", "", "Comma-separated name:key pairs of API keys accepted by the HTTP API")
+	apiInsecure := flag.Bool("api-insecure", false, "Serve the HTTP API to anyone, rate limited by IP address, when no --api-keys are given")
+	rateLimit := flag.Int("rate-limit", 30, "Questions each user may ask per hour across Discord and the HTTP API (0 for no limit)")
+	publisherRoles := flag.String("publisher-roles", "", "Comma-separated Discord role IDs allowed to publish to the web without approval (default: everyone)")
+	moderatorRoles := flag.String("moderator-roles", "", "Comma-separated Discord role IDs allowed to approve exports to the web")
+	mcpMode := flag.String("mcp", "", "Serve the Model Context Protocol: \"stdio\" instead of the REPL, or \"http\" at /mcp on --http-addr")
+	slackBotToken := flag.String("slack-bot-token", "", "Bot token for accessing the Slack API; requests from Slack are served under /slack/ on --http-addr")
+	slackSigningSecret := flag.String("slack-signing-secret", "", "Signing secret for verifying requests from Slack")
+	slackAPIURL := flag.String("slack-api-url", slack.DefaultAPIURL, "Base URL of the Slack Web API")
+	slackPublishers := flag.String("slack-publishers", "", "Comma-separated Slack user IDs allowed to publish to the web without approval")
+	slackModerators := flag.String("slack-moderators", "", "Comma-separated Slack user IDs allowed to publish to the web and approve exports (default: exporting from Slack is off without publishers or moderators)")
+	slackModerationChannel := flag.String("slack-moderation-channel", "", "Slack channel ID where exports awaiting approval are posted (default: where they were requested)")
+	refreshSchedule := flag.String("refresh-schedule", "", "Cron schedule (UTC) on which to refresh changed datasets in headless mode, e.g. \"0 6 * * *\"")
+	ingestCmd := flag.String("ingest-cmd", "./ingest/ingest", "Ingest program run to refresh datasets on --refresh-schedule")
+	ckanURL := flag.String("ckan-url", opendata.DefaultBaseURL, "Base URL of the CKAN open data portal whose datastore is queried for datastore tables")
+	datastoreTTL := flag.Duration("datastore-cache-ttl", 15*time.Minute, "How long results of datastore table queries are cached")
+	moderationChannel := flag.String("moderation-channel", "", "Discord channel ID where exports awaiting approval are posted (default: where they were requested)")
 
+	format := flag.String("format", "table", "Output format for the ask, sql and datasets commands: table, csv or json")
+	chartFile := flag.String("chart", "", "PNG file to chart the answer of the ask command to")
+	outFile := flag.String("out", "-", "File to write batch results to as JSON lines (default: stdout), or to save an eval run to")
+	concurrency := flag.Int("concurrency", 4, fmt.Sprintf("Questions answered at once by the batch command, from 1 to %d", maxBatchConcurrency))
+	baselineFile := flag.String("baseline", "", "Saved eval run to compare the eval command's results with")
+	minAccuracy := flag.Float64("min-accuracy", 0, "Accuracy from 0 to 1 below which the eval command fails")
+	cassetteDir := flag.String("cassette", "", "Directory to record OpenAI requests to or replay them from, for deterministic offline runs")
+	cassetteMode := flag.String("cassette-mode", "replay", "Whether to \"record\" OpenAI requests to --cassette or \"replay\" them")
+	fakeLLM := flag.Bool("fake-llm", false, "Replay the model answers to eval suites recorded in testdata/cassette instead of calling OpenAI, e.g. for CI")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	args := parseArgs()
+	cmd := ""
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	arg := func(n int, name string) string {
+		if len(args) <= n {
+			log.Fatalf("Missing %s for %s command", name, cmd)
+		}
+		return args[n]
+	}
 
 	ctx := context.Background()
 
@@ -48,7 +99,18 @@ func main() {
 		store = &citygraph.Store{GraphClient: citygraph.NewClient(graphConn)}
 	}
 
-	ai := openai.NewClient(*openaiToken)
+	aiConfig := openai.DefaultConfig(*openaiToken)
+	if *cassetteDir != "" {
+		mode, err := cassette.ParseMode(*cassetteMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport, err := cassette.New(*cassetteDir, mode, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		aiConfig.HTTPClient = &http.Client{Transport: transport}
+	}
 
 	// Connect to the SQLite database
 	db, err := sql.Open("sqlite3", *dbFile)
@@ -57,13 +119,160 @@ func main() {
 	}
 	defer db.Close()
 
-	tb, err := bot.New(ctx, db, ai, store, *hostname)
+	// Commands that don't need the LLM run before connecting to it.
+	var question, questionsFile string
+	var suites []*eval.Suite
+	var usage *eval.UsageCounter
+	switch cmd {
+	case "sql":
+		redirectStdout()
+		runSQL(db, arg(1, "query"), *format)
+		return
+	case "datasets":
+		redirectStdout()
+		listDatasets(db, *format)
+		return
+	case "ask":
+		question = arg(1, "question")
+		redirectStdout()
+	case "batch":
+		questionsFile = arg(1, "questions file")
+		redirectStdout()
+	case "eval":
+		redirectStdout()
+		paths := args[1:]
+		if len(paths) == 0 {
+			paths = []string{"eval/suites"}
+		}
+		if suites, err = eval.LoadSuites(paths...); err != nil {
+			log.Fatalf("Error loading eval suites: %v", err)
+		}
+		if *fakeLLM {
+			transport, err := cassette.New("testdata/cassette", cassette.Replay, nil)
+			if err != nil {
+				log.Fatalf("Error opening recorded answers: %v", err)
+			}
+			aiConfig.HTTPClient = &http.Client{Transport: transport}
+		}
+		usage = eval.NewUsageCounter(aiConfig.HTTPClient.Transport)
+		aiConfig.HTTPClient = &http.Client{Transport: usage}
+	case "serve":
+		*headless = true
+	case "":
+	default:
+		flag.Usage()
+		log.Fatalf("Unknown command %q", cmd)
+	}
+
+	tb, err := bot.New(ctx, db, openai.NewClientWithConfig(aiConfig), store, *hostname)
 	if err != nil {
 		log.Fatalf("Error creating bot: %s", err)
 	}
 
+	if *rateLimit > 0 {
+		tb.Limiter = bot.NewRateLimiter(*rateLimit, time.Hour)
+	}
+	tb.Datastore = bot.NewDatastore(opendata.NewClient(opendata.WithBaseURL(*ckanURL)), *datastoreTTL)
+
+	switch cmd {
+	case "ask":
+		runAsk(ctx, tb, question, *format, *chartFile)
+		return
+	case "batch":
+		runBatch(ctx, tb, questionsFile, *outFile, *concurrency)
+		return
+	case "eval":
+		runEval(ctx, tb, usage, suites, *baselineFile, *outFile, *minAccuracy)
+		return
+	}
+
+	switch *mcpMode {
+	case "", "http":
+	case "stdio":
+		// The protocol owns stdout, so send anything else printed there to stderr.
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		if err := mcp.NewServer(db, tb).ServeStdio(ctx, os.Stdin, stdout); err != nil {
+			log.Fatalf("Error serving MCP: %s", err)
+		}
+		return
+	default:
+		log.Fatalf("Invalid --mcp %q, expected stdio or http", *mcpMode)
+	}
+	if *mcpMode == "http" && *httpAddr == "" {
+		log.Fatal("--mcp http needs --http-addr")
+	}
+
+	if *slackBotToken != "" && (*httpAddr == "" || *slackSigningSecret == "") {
+		log.Fatal("Slack needs --http-addr to receive requests and --slack-signing-secret to verify them")
+	}
+
+	if *httpAddr != "" {
+		keys := map[string]string{}
+		for _, pair := range splitList(*apiKeys) {
+			name, key, ok := strings.Cut(pair, ":")
+			if !ok || name == "" || key == "" {
+				log.Fatalf("Invalid API key %q, expected name:key", pair)
+			}
+			keys[key] = name
+		}
+		var apiOptions []api.Option
+		switch {
+		case len(keys) > 0:
+		case *apiInsecure:
+			log.Println("Warning: no API keys given and --api-insecure is set, so the HTTP API is open to anyone")
+			apiOptions = append(apiOptions, api.WithInsecure())
+		default:
+			log.Println("No API keys given, so the HTTP API rejects every request (pass --api-insecure to open it)")
+		}
+		apiHandler := api.NewServer(db, tb, keys, apiOptions...)
+		mux := http.NewServeMux()
+		mux.Handle("/", apiHandler.Handler())
+		if *mcpMode == "http" {
+			mux.Handle("/mcp", apiHandler.Authenticated(mcp.NewServer(db, tb).Handler(api.RequestUser)))
+		}
+		if *slackBotToken != "" {
+			slackBotServer, err := slack.OpenBotServer(
+				ctx,
+				db,
+				*slackBotToken,
+				*slackSigningSecret,
+				tb,
+				slack.WithAPIURL(*slackAPIURL),
+				slack.WithPublishers(splitList(*slackPublishers)...),
+				slack.WithModerators(splitList(*slackModerators)...),
+				slack.WithModerationChannel(*slackModerationChannel),
+			)
+			if err != nil {
+				log.Fatalf("Error opening Slack bot server: %s", err)
+			}
+			mux.Handle("/slack/", slackBotServer.Handler())
+			fmt.Println("TorontoBot is now live on Slack.")
+		}
+		apiServer := &http.Server{
+			Addr:    *httpAddr,
+			Handler: mux,
+		}
+		go func() {
+			if err := apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error serving HTTP API: %s", err)
+			}
+		}()
+		defer apiServer.Close()
+
+		fmt.Printf("TorontoBot API is listening on %s.\n", *httpAddr)
+	}
+
+	var discordBotServer *discord.BotServer
 	if *discordBotToken != "" {
-		discordBotServer, err := discord.OpenBotServer(db, *discordBotToken, tb)
+		discordBotServer, err = discord.OpenBotServer(
+			db,
+			*discordBotToken,
+			tb,
+			discord.WithPublisherRoles(splitList(*publisherRoles)...),
+			discord.WithModeratorRoles(splitList(*moderatorRoles)...),
+			discord.WithModerationChannel(*moderationChannel),
+		)
 		if err != nil {
 			log.Fatalf("Error opening Discord bot server: %s", err)
 		}
@@ -74,6 +283,21 @@ func main() {
 
 	if *headless {
 		// Run in headless mode
+		// Run scheduled reports and subscriptions while the Discord bot is live.
+		schedCtx, cancelSched := context.WithCancel(ctx)
+		defer cancelSched()
+		if discordBotServer != nil {
+			go discordBotServer.RunScheduler(schedCtx)
+		}
+		if *refreshSchedule != "" {
+			sched, err := schedule.Parse(*refreshSchedule)
+			if err != nil {
+				log.Fatalf("Invalid --refresh-schedule: %v", err)
+			}
+			go runRefreshes(schedCtx, sched, *ingestCmd, *dbFile)
+			fmt.Printf("Refreshing datasets on schedule %q.\n", sched)
+		}
+
 		// Listen for termination signal
 		term := make(chan os.Signal, 1)
 		signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
@@ -82,10 +306,15 @@ func main() {
 		<-term
 	} else {
 
-		rl, err := readline.New(">> ")
+		con := newConsole(db, tb)
+		rl, err := readline.NewEx(&readline.Config{
+			Prompt:       ">> ",
+			AutoComplete: con,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
+		fmt.Println("Ask a question, or type :help for commands.")
 		// loop to read commands and print output
 		for {
 			question, err := rl.Readline()
@@ -95,49 +324,56 @@ func main() {
 			if strings.TrimSpace(question) == "" {
 				continue
 			}
-			table, err := tb.SelectTable(ctx, question)
-			if err != nil {
-				fmt.Println("Error selecting table:", err)
-				continue
-			}
-			fmt.Printf("Selected table: %q\n", table.Name)
-			sqlAnalysis, err := tb.SQLAnalysis(ctx, table, question)
-			if err != nil {
-				fmt.Println("Error analyzing SQL query:", err)
+			if strings.HasPrefix(strings.TrimSpace(question), ":") {
+				con.command(ctx, question)
 				continue
 			}
-
-			if sqlAnalysis.MissingData != "" {
-				fmt.Printf("%s\n", sqlAnalysis.MissingData)
-				continue
+			con.history = append(con.history, question)
+			answer, err := tb.Ask(ctx, &bot.AskRequest{
+				Question: question,
+				Table:    con.table,
+				OnAnalysis: func(table *bot.DataTable, sqlAnalysis *bot.SQLResponse) {
+					fmt.Printf("Selected table: %q\n", table.Name)
+					fmt.Printf(
+						"%s\n\n%s\n\nSQL: %q\n",
+						sqlAnalysis.Schema,
+						sqlAnalysis.Applicability,
+						sqlAnalysis.SQL)
+				},
+			})
+			if answer != nil && answer.SQLResponse.SQL != "" {
+				con.last = &result{
+					Question: question,
+					Table:    answer.Table.Name,
+					SQL:      answer.SQLResponse.SQL,
+					results:  answer.Results,
+				}
 			}
-
-			fmt.Printf(
-				"%s\n\n%s\n\nSQL: %q\n",
-				sqlAnalysis.Schema,
-				sqlAnalysis.Applicability,
-				sqlAnalysis.SQL)
-
-			resultsTable, err := tb.LoadResults(sqlAnalysis.SQL, sqlAnalysis.IsCurrency)
 			if err != nil {
-				if err == sql.ErrNoRows {
+				switch {
+				case answer == nil:
+					fmt.Println("Error", err)
+				case err == sql.ErrNoRows:
 					fmt.Println("No results found.")
-				} else {
+				default:
 					fmt.Println("Error executing SQL query:", err)
 				}
 				continue
 			}
+			if answer.SQLResponse.MissingData != "" {
+				fmt.Printf("%s\n", answer.SQLResponse.MissingData)
+				continue
+			}
+			table, sqlAnalysis, resultsTable := answer.Table, answer.SQLResponse, answer.Results
+
 			// Store query for subsequent charting and export,
 			_, err = uq.StoreUserQuery(
 				db,
 				&uq.UserQuery{
-					"",
-					"",
-					"",
-					question,
-					sqlAnalysis,
-					resultsTable,
-					time.Time{},
+					Question:    question,
+					TableName:   table.Name,
+					SQLResponse: sqlAnalysis,
+					Results:     resultsTable,
 				})
 			if err != nil {
 				log.Println("Error storing query:", err)
@@ -341,3 +577,14 @@ func main() {
 		}
 	}
 }
+
+// splitList splits a comma-separated flag value, ignoring empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,462 @@
+// Package mcp serves TorontoBot's datasets to other AI agents over the Model Context Protocol, as
+// tools for exploring and querying them and as resources describing them. See
+// https://modelcontextprotocol.io/specification
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/jsonschema"
+)
+
+const (
+	// protocolVersion is the latest version of the protocol supported.
+	protocolVersion = "2025-06-18"
+	// maxRows is the most result rows returned by a tool call, to keep results within an agent's
+	// context.
+	maxRows = 200
+	// queryTimeout bounds how long a tool call's query may run.
+	queryTimeout = 10 * time.Second
+	// datasetURIPrefix prefixes the URIs of dataset resources, which end with the table name.
+	datasetURIPrefix = "torontobot://datasets/"
+)
+
+// supportedVersions are the protocol versions the server can speak.
+var supportedVersions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Server answers MCP requests. Questions asked with the ask tool go through the same pipeline,
+// storage and rate limiting as other frontends.
+type Server struct {
+	db  *sql.DB
+	bot *bot.TorontoBot
+}
+
+func NewServer(db *sql.DB, tb *bot.TorontoBot) *Server {
+	return &Server{db: db, bot: tb}
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// isNotification reports whether the request expects no response.
+func (r *request) isNotification() bool {
+	return len(r.ID) == 0
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// handleMessage handles a single JSON-RPC message from a client on behalf of user, who is rate
+// limited when asking questions. It returns nil for notifications and responses, which aren't
+// answered.
+func (s *Server) handleMessage(ctx context.Context, user string, msg []byte) *response {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{codeParseError, "invalid JSON-RPC message"}}
+	}
+	if req.Method == "" {
+		// A response to a request we never send, or something else we don't understand.
+		if req.isNotification() {
+			return nil
+		}
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{codeInvalidRequest, "missing method"}}
+	}
+	if req.isNotification() {
+		// Notifications such as notifications/initialized need no action.
+		return nil
+	}
+
+	result, err := s.handle(ctx, user, &req)
+	resp := &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			log.Printf("Error handling MCP %s: %v\n", req.Method, err)
+			rpcErr = &rpcError{Code: -32603, Message: "internal error"}
+		}
+		resp.Result, resp.Error = nil, rpcErr
+	}
+	return resp
+}
+
+func (s *Server) handle(ctx context.Context, user string, req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize(req.Params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": tools}, nil
+	case "tools/call":
+		return s.callTool(ctx, user, req.Params)
+	case "resources/list":
+		return s.listResources(), nil
+	case "resources/read":
+		return s.readResource(req.Params)
+	default:
+		return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+	}
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{codeInvalidParams, "invalid initialize params"}
+	}
+	version := protocolVersion
+	if supportedVersions[p.ProtocolVersion] {
+		version = p.ProtocolVersion
+	}
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools":     map[string]interface{}{},
+			"resources": map[string]interface{}{},
+		},
+		"serverInfo": map[string]string{
+			"name":    "torontobot",
+			"version": "1.0.0",
+		},
+		"instructions": "TorontoBot answers questions about City of Toronto open data. Use list_datasets and " +
+			"describe_dataset to find the right table, then run_sql to query it, or ask to have TorontoBot " +
+			"pick the table and write the SQL for you.",
+	}, nil
+}
+
+// tool describes a tool in tools/list.
+type tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema *jsonschema.Definition `json:"inputSchema"`
+}
+
+var tools = []*tool{
+	{
+		Name:        "list_datasets",
+		Description: "List the City of Toronto datasets available to query, with a description of each.",
+		InputSchema: &jsonschema.Definition{Type: jsonschema.Object, Properties: map[string]*jsonschema.Definition{}},
+	},
+	{
+		Name: "describe_dataset",
+		Description: "Describe a dataset's SQLite table: its schema, the allowed values of enumerated columns, " +
+			"hints about what values mean, instructions for querying it and example questions.",
+		InputSchema: &jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]*jsonschema.Definition{
+				"name": {Type: jsonschema.String, Description: "Table name, as returned by list_datasets."},
+			},
+			Required: []string{"name"},
+		},
+	},
+	{
+		Name: "run_sql",
+		Description: fmt.Sprintf("Run a read-only SQLite SELECT query against the datasets, returning columns "+
+			"and up to %d rows.", maxRows),
+		InputSchema: &jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]*jsonschema.Definition{
+				"sql": {Type: jsonschema.String, Description: "A single SELECT statement, optionally with a WITH clause."},
+			},
+			Required: []string{"sql"},
+		},
+	},
+	{
+		Name: "ask",
+		Description: "Ask TorontoBot a question in plain English. It selects a dataset, writes and runs SQL, " +
+			"and returns the SQL, its reasoning and the results.",
+		InputSchema: &jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]*jsonschema.Definition{
+				"question": {Type: jsonschema.String, Description: "Question about City of Toronto open data."},
+			},
+			Required: []string{"question"},
+		},
+	},
+}
+
+// toolResult is the result of a tool call. Errors running a tool are reported in the result, rather
+// than as JSON-RPC errors, so the agent can see them and adjust.
+type toolResult struct {
+	Content []*content `json:"content"`
+	IsError bool       `json:"isError,omitempty"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func textResult(text string) *toolResult {
+	return &toolResult{Content: []*content{{Type: "text", Text: text}}}
+}
+
+func errorResult(format string, args ...interface{}) *toolResult {
+	result := textResult(fmt.Sprintf(format, args...))
+	result.IsError = true
+	return result
+}
+
+// internalError logs an error doing something and reports it to the client without the details,
+// which are no help to an agent.
+func internalError(doing string, err error) *toolResult {
+	log.Printf("Error %s: %v\n", doing, err)
+	return errorResult("Sorry, something went wrong %s. Please try again.", doing)
+}
+
+func jsonResult(v interface{}) (*toolResult, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return textResult(string(data)), nil
+}
+
+func (s *Server) callTool(ctx context.Context, user string, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Name      string `json:"name"`
+		Arguments struct {
+			Name     string `json:"name"`
+			SQL      string `json:"sql"`
+			Question string `json:"question"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{codeInvalidParams, "invalid tools/call params"}
+	}
+	args := p.Arguments
+
+	switch p.Name {
+	case "list_datasets":
+		type dataset struct {
+			Name          string     `json:"name"`
+			Description   string     `json:"description"`
+			LastRefreshed *time.Time `json:"last_refreshed,omitempty"`
+		}
+		datasets := []*dataset{}
+		for _, table := range s.bot.Tables() {
+			ds := &dataset{Name: table.Name, Description: table.Desc}
+			refreshed, err := s.bot.LastRefreshed(table.Name)
+			if err != nil {
+				log.Println("Error getting last refresh:", err)
+			}
+			ds.LastRefreshed = timeOrNil(refreshed)
+			datasets = append(datasets, ds)
+		}
+		return jsonResult(datasets)
+
+	case "describe_dataset":
+		table, ok := s.bot.Table(args.Name)
+		if !ok {
+			return errorResult("No dataset named %q. Use list_datasets to see the available datasets.", args.Name), nil
+		}
+		return jsonResult(table)
+
+	case "run_sql":
+		if strings.TrimSpace(args.SQL) == "" {
+			return errorResult("The sql argument is required."), nil
+		}
+		columns, rows, err := s.loadRows(ctx, args.SQL)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return errorResult("The query took longer than %v. Try a simpler query.", queryTimeout), nil
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			// Errors in the query itself help the agent fix it.
+			return errorResult("Error running query: %v", err), nil
+		}
+		return jsonResult(newRows(columns, rows))
+
+	case "ask":
+		return s.ask(ctx, user, strings.TrimSpace(args.Question))
+
+	default:
+		return nil, &rpcError{codeInvalidParams, fmt.Sprintf("unknown tool %q", p.Name)}
+	}
+}
+
+// rowsResult is the result of running a query.
+type rowsResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+func newRows(columns []string, rows [][]interface{}) *rowsResult {
+	result := &rowsResult{Columns: columns, Rows: rows}
+	if result.Columns == nil {
+		result.Columns = []string{}
+	}
+	if result.Rows == nil {
+		result.Rows = [][]interface{}{}
+	}
+	if len(result.Rows) > maxRows {
+		result.Rows, result.Truncated = result.Rows[:maxRows], true
+	}
+	return result
+}
+
+// loadRows runs a read-only query within queryTimeout, loading one row more than maxRows so that
+// newRows can tell the results were truncated.
+func (s *Server) loadRows(ctx context.Context, sqlQuery string) ([]string, [][]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	columns, rows, err := s.bot.LoadRowsContext(ctx, sqlQuery, maxRows+1)
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return columns, rows, err
+}
+
+func (s *Server) ask(ctx context.Context, user, question string) (interface{}, error) {
+	if question == "" {
+		return errorResult("The question argument is required."), nil
+	}
+	log.Printf("Received MCP question from %s: %s\n", user, question)
+	answer, err := s.bot.Ask(ctx, &bot.AskRequest{
+		Question: question,
+		User:     user,
+	})
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		return errorResult("Sorry, %v.", err), nil
+	case answer == nil:
+		return internalError("answering the question", err), nil
+	}
+
+	result := struct {
+		ID            int64  `json:"id,omitempty"`
+		Table         string `json:"table"`
+		Applicability string `json:"applicability,omitempty"`
+		MissingData   string `json:"missing_data,omitempty"`
+		SQL           string `json:"sql,omitempty"`
+		// LastRefreshed is when the table's data was last ingested, if known.
+		LastRefreshed *time.Time `json:"last_refreshed,omitempty"`
+		*rowsResult
+	}{
+		Table:         answer.Table.Name,
+		Applicability: answer.SQLResponse.Applicability,
+		MissingData:   answer.SQLResponse.MissingData,
+		SQL:           answer.SQLResponse.SQL,
+		LastRefreshed: timeOrNil(answer.LastRefreshed),
+		rowsResult:    newRows(nil, nil),
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows), result.MissingData != "":
+		return jsonResult(result)
+	case err != nil:
+		return internalError("running the query", err), nil
+	}
+
+	// Store query for subsequent charting and export,
+	if result.ID, err = uq.StoreUserQuery(s.db, &uq.UserQuery{
+		UserID:      user,
+		ChannelID:   "mcp",
+		Question:    question,
+		TableName:   answer.Table.Name,
+		SQLResponse: answer.SQLResponse,
+		Results:     answer.Results,
+	}); err != nil {
+		log.Println("Error storing query:", err)
+	}
+
+	// The rendered results are formatted for people, so run the query again for raw values.
+	columns, rows, err := s.loadRows(ctx, answer.SQLResponse.SQL)
+	if err != nil {
+		return internalError("loading the results", err), nil
+	}
+	result.rowsResult = newRows(columns, rows)
+	return jsonResult(result)
+}
+
+type resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+func (s *Server) listResources() interface{} {
+	resources := []*resource{}
+	for _, table := range s.bot.Tables() {
+		resources = append(resources, &resource{
+			URI:         datasetURIPrefix + table.Name,
+			Name:        table.Name,
+			Description: table.Desc,
+			MimeType:    "application/json",
+		})
+	}
+	return map[string]interface{}{"resources": resources}
+}
+
+func (s *Server) readResource(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &rpcError{codeInvalidParams, "invalid resources/read params"}
+	}
+	name := strings.TrimPrefix(p.URI, datasetURIPrefix)
+	table, ok := s.bot.Table(name)
+	if !strings.HasPrefix(p.URI, datasetURIPrefix) || !ok {
+		// The spec's error code for a resource that isn't found.
+		return nil, &rpcError{-32002, fmt.Sprintf("resource %q not found", p.URI)}
+	}
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"contents": []map[string]string{{
+			"uri":      p.URI,
+			"mimeType": "application/json",
+			"text":     string(data),
+		}},
+	}, nil
+}
+
+// timeOrNil returns a pointer to t, or nil if it's the zero time, so it's omitted from JSON.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
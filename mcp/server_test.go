@@ -0,0 +1,228 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	db := testutil.DB(t)
+	if _, err := db.Exec(`INSERT INTO service_requests (year, ward, service_request_type) VALUES
+		(2022, 'Davenport', 'Pothole'), (2022, 'Spadina-Fort York', 'Graffiti'), (2023, 'Davenport', 'Pothole')`); err != nil {
+		t.Fatal(err)
+	}
+	tb, err := bot.New(context.Background(), db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(db, tb)
+}
+
+// call makes a request of the server, decoding its result into result, and returns any JSON-RPC
+// error.
+func call(t *testing.T, s *Server, method string, params, result interface{}) *rpcError {
+	t.Helper()
+	msg, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := s.handleMessage(context.Background(), "", msg)
+	if resp == nil {
+		t.Fatalf("%s got no response", method)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	data, err := json.Marshal(resp.Result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		t.Fatalf("decoding %s result: %v", method, err)
+	}
+	return nil
+}
+
+// callTool calls a tool, returning its result.
+func callTool(t *testing.T, s *Server, name string, args map[string]string) *toolResult {
+	t.Helper()
+	var result toolResult
+	if err := call(t, s, "tools/call", map[string]interface{}{"name": name, "arguments": args}, &result); err != nil {
+		t.Fatalf("calling %s: %v", name, err)
+	}
+	if len(result.Content) != 1 {
+		t.Fatalf("%s returned %d content items, want 1", name, len(result.Content))
+	}
+	return &result
+}
+
+func TestHandshake(t *testing.T) {
+	s := testServer(t)
+	in := strings.Join([]string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26", "capabilities": {}, "clientInfo": {"name": "test", "version": "1"}}}`,
+		`{"jsonrpc": "2.0", "method": "notifications/initialized"}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "tools/list"}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "nonsense"}`,
+		`not json`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	type stdioResponse struct {
+		ID     json.RawMessage `json:"id"`
+		Result struct {
+			ProtocolVersion string  `json:"protocolVersion"`
+			Tools           []*tool `json:"tools"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}
+	var responses []*stdioResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var resp stdioResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, &resp)
+	}
+	// The notification isn't answered.
+	if len(responses) != 4 {
+		t.Fatalf("got %d responses, want 4:\n%s", len(responses), out.String())
+	}
+	if v := responses[0].Result.ProtocolVersion; v != "2025-03-26" {
+		t.Errorf("negotiated protocol version %q, want the client's", v)
+	}
+	var names []string
+	for _, tool := range responses[1].Result.Tools {
+		names = append(names, tool.Name)
+	}
+	if got, want := strings.Join(names, ","), "list_datasets,describe_dataset,run_sql,ask"; got != want {
+		t.Errorf("tools = %s, want %s", got, want)
+	}
+	if err := responses[2].Error; err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("unknown method got error %+v, want method not found", err)
+	}
+	if err := responses[3].Error; err == nil || err.Code != codeParseError || string(responses[3].ID) != "null" {
+		t.Errorf("invalid JSON got error %+v and ID %s, want a parse error for a null ID", err, responses[3].ID)
+	}
+}
+
+func TestInitializeUnsupportedVersion(t *testing.T) {
+	s := testServer(t)
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := call(t, s, "initialize", map[string]string{"protocolVersion": "1999-01-01"}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ProtocolVersion != protocolVersion {
+		t.Errorf("protocol version = %q, want the latest, %q", result.ProtocolVersion, protocolVersion)
+	}
+}
+
+func TestRunSQL(t *testing.T) {
+	s := testServer(t)
+	var rows rowsResult
+	result := callTool(t, s, "run_sql", map[string]string{"sql": "SELECT ward, COUNT(*) AS n FROM service_requests GROUP BY ward ORDER BY n DESC, ward"})
+	if result.IsError {
+		t.Fatalf("run_sql failed: %s", result.Content[0].Text)
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].Text), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(rows.Columns, rows.Rows); got != "[ward n] [[Davenport 2] [Spadina-Fort York 1]]" || rows.Truncated {
+		t.Errorf("rows = %s, truncated = %t", got, rows.Truncated)
+	}
+
+	// Endless results are cut off at maxRows.
+	result = callTool(t, s, "run_sql", map[string]string{"sql": "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT x FROM n"})
+	if result.IsError {
+		t.Fatalf("run_sql failed: %s", result.Content[0].Text)
+	}
+	rows = rowsResult{}
+	if err := json.Unmarshal([]byte(result.Content[0].Text), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Rows) != maxRows || !rows.Truncated {
+		t.Errorf("got %d rows, truncated = %t, want %d truncated", len(rows.Rows), rows.Truncated, maxRows)
+	}
+
+	for _, sql := range []string{
+		"",
+		"DELETE FROM service_requests",
+		"SELECT * FROM user_queries",
+		"SELECT nope FROM service_requests",
+	} {
+		if result := callTool(t, s, "run_sql", map[string]string{"sql": sql}); !result.IsError {
+			t.Errorf("run_sql %q succeeded: %s", sql, result.Content[0].Text)
+		}
+	}
+}
+
+func TestDescribeDataset(t *testing.T) {
+	s := testServer(t)
+	result := callTool(t, s, "describe_dataset", map[string]string{"name": "service_requests"})
+	if result.IsError || !strings.Contains(result.Content[0].Text, "CREATE TABLE IF NOT EXISTS service_requests") {
+		t.Errorf("describe_dataset = %s", result.Content[0].Text)
+	}
+	if result := callTool(t, s, "describe_dataset", map[string]string{"name": "user_queries"}); !result.IsError {
+		t.Errorf("describing a table that isn't a dataset succeeded: %s", result.Content[0].Text)
+	}
+	var list struct{}
+	if err := call(t, s, "tools/call", map[string]interface{}{"name": "drop_tables"}, &list); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("unknown tool got error %+v, want invalid params", err)
+	}
+}
+
+func TestAskError(t *testing.T) {
+	s := testServer(t)
+	// Nothing is recorded for this question, so answering it fails with an internal error.
+	result := callTool(t, s, "ask", map[string]string{"question": "Which ward has the most parking tickets on Tuesdays?"})
+	if text := result.Content[0].Text; !result.IsError || strings.Contains(text, "cassette") {
+		t.Errorf("ask = %q, want a generic error", text)
+	}
+}
+
+func TestReadResource(t *testing.T) {
+	s := testServer(t)
+	var list struct {
+		Resources []*resource `json:"resources"`
+	}
+	if err := call(t, s, "resources/list", nil, &list); err != nil {
+		t.Fatal(err)
+	}
+	uri := datasetURIPrefix + "service_requests"
+	var found bool
+	for _, r := range list.Resources {
+		found = found || r.URI == uri
+	}
+	if !found {
+		t.Fatalf("resources/list doesn't include %s", uri)
+	}
+
+	var read struct {
+		Contents []map[string]string `json:"contents"`
+	}
+	if err := call(t, s, "resources/read", map[string]string{"uri": uri}, &read); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Contents) != 1 || read.Contents[0]["uri"] != uri || !strings.Contains(read.Contents[0]["text"], "CREATE TABLE IF NOT EXISTS service_requests") {
+		t.Errorf("resources/read = %+v", read.Contents)
+	}
+
+	for _, uri := range []string{datasetURIPrefix + "user_queries", "file:///etc/passwd", "service_requests"} {
+		if err := call(t, s, "resources/read", map[string]string{"uri": uri}, &read); err == nil || err.Code != -32002 {
+			t.Errorf("reading %s got error %+v, want resource not found", uri, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// maxMessageSize is the largest message accepted from a client.
+const maxMessageSize = 1 << 20
+
+// ServeStdio serves a single client over the stdio transport: newline-delimited JSON-RPC messages
+// read from r, with responses written to w. It returns when r is closed. Nothing else may write to w.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		// The local user isn't rate limited.
+		if resp := s.handleMessage(ctx, "", line); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return fmt.Errorf("writing response: %v", err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Handler serves the streamable HTTP transport, without server-initiated streams: each POST carries
+// one JSON-RPC message, answered in the response body. user returns the rate limiting key of the
+// client making a request.
+func (s *Server) Handler(user func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		// Guard against DNS rebinding by browsers on the same machine.
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+		if err != nil {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		resp := s.handleMessage(r.Context(), user(r), msg)
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Println("Error writing response:", err)
+		}
+	})
+}
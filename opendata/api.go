@@ -1,20 +1,33 @@
 package opendata
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"context"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// APIResponse is the response of the package_show action, as returned by Get.
+//
+// Deprecated: Use Client.PackageShow, which returns the package itself.
 type APIResponse struct {
 	Help    string `json:"help"`
 	Success bool   `json:"success"`
 	Result  Result `json:"result"`
 }
 
+// Get returns a package of the City of Toronto's portal, by ID or name. CKAN's errors are returned
+// as an *Error, so Success is always true.
+//
+// Deprecated: Use NewClient().PackageShow, which takes a context and retries failed requests.
+func Get(id string) (*APIResponse, error) {
+	pkg, err := NewClient().PackageShow(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &APIResponse{Success: true, Result: *pkg}, nil
+}
+
 type Result struct {
 	Author                 string         `json:"author"`
 	AuthorEmail            string         `json:"author_email"`
@@ -105,11 +118,20 @@ type Tag struct {
 }
 
 type Group struct {
-	// this struct can be filled based on the data returned in the "groups" field
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Title           string `json:"title"`
+	DisplayName     string `json:"display_name"`
+	Description     string `json:"description"`
+	ImageDisplayURL string `json:"image_display_url"`
 }
 
+// Relationship links two packages, e.g. one that "depends_on" or is a "child_of" another.
 type Relationship struct {
-	// this struct can be filled based on the data returned in the "relationships_as_subject" and "relationships_as_object" fields
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Type    string `json:"type"`
+	Comment string `json:"comment"`
 }
 
 type BoolString bool
@@ -154,22 +176,3 @@ func (ct *CustomTime) UnmarshalJSON(b []byte) (err error) {
 	*ct = CustomTime(nt)
 	return
 }
-
-func Get(id string) (*APIResponse, error) {
-	resp, err := http.Get("https://ckan0.cf.opendata.inter.prod-toronto.ca/api/3/action/package_show?id=" + id)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var apiResponse APIResponse
-	if err = json.Unmarshal(body, &apiResponse); err != nil {
-		return nil, err
-	}
-	return &apiResponse, nil
-}
@@ -0,0 +1,354 @@
+// Package opendata is a client for the API of CKAN open data portals, such as the City of Toronto's.
+package opendata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the City of Toronto's CKAN open data portal.
+const DefaultBaseURL = "https://ckan0.cf.opendata.inter.prod-toronto.ca"
+
+const (
+	defaultRetries = 3
+	defaultBackoff = time.Second
+)
+
+// Client calls the actions of a CKAN portal's API.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sends requests to baseURL rather than DefaultBaseURL, e.g. to test against a local
+// server standing in for CKAN.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sends requests with httpClient rather than http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+// WithRetries retries requests which fail with a network error, a rate limit or a server error up
+// to retries times, waiting backoff before the first retry and doubling it before each one after.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// NewClient returns a client for the City of Toronto's portal, unless configured otherwise.
+func NewClient(options ...Option) *Client {
+	c := &Client{
+		baseURL: DefaultBaseURL,
+		http:    http.DefaultClient,
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+var (
+	// ErrNotFound matches an Error for a package, resource or other object which doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAuthorization matches an Error for an action the client isn't authorized to take.
+	ErrAuthorization = errors.New("not authorized")
+	// ErrValidation matches an Error for invalid parameters.
+	ErrValidation = errors.New("invalid parameters")
+)
+
+// Error is an error returned by a CKAN action with "success": false.
+type Error struct {
+	Action string
+	// Type is CKAN's type of error, e.g. "Not Found Error".
+	Type    string
+	Message string
+	// Fields are the problems with each invalid parameter of a validation error.
+	Fields map[string][]string
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		var problems []string
+		for field, errs := range e.Fields {
+			problems = append(problems, fmt.Sprintf("%s: %s", field, strings.Join(errs, ", ")))
+		}
+		msg = strings.Join(problems, "; ")
+	}
+	return fmt.Sprintf("ckan %s: %s: %s", e.Action, e.Type, msg)
+}
+
+// Is matches ErrNotFound, ErrAuthorization and ErrValidation by the type of error.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Type == "Not Found Error"
+	case ErrAuthorization:
+		return e.Type == "Authorization Error"
+	case ErrValidation:
+		return e.Type == "Validation Error"
+	}
+	return false
+}
+
+func (e *Error) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		switch k {
+		case "__type":
+			json.Unmarshal(v, &e.Type)
+		case "message":
+			json.Unmarshal(v, &e.Message)
+		default:
+			var errs []string
+			if json.Unmarshal(v, &errs) != nil {
+				errs = []string{strings.Trim(string(v), `"`)}
+			}
+			if e.Fields == nil {
+				e.Fields = map[string][]string{}
+			}
+			e.Fields[k] = errs
+		}
+	}
+	return nil
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// call calls a CKAN action with the given parameters, decoding its result into result.
+func (c *Client) call(ctx context.Context, action string, params url.Values, result interface{}) error {
+	u := c.baseURL + "/api/3/action/" + action
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.do(ctx, action, u, result)
+		if !retry || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do makes one request for an action, reporting whether it failed in a way worth retrying.
+func (c *Client) do(ctx context.Context, action, u string, result interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("calling ckan %s: %v", action, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("reading ckan %s response: %v", action, err)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("ckan %s: HTTP %d", action, resp.StatusCode)
+	}
+
+	// CKAN reports errors such as "Not Found Error" with 4xx statuses and a JSON body.
+	var apiResp apiResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("ckan %s: HTTP %d", action, resp.StatusCode)
+		}
+		return false, fmt.Errorf("decoding ckan %s response: %v", action, err)
+	}
+	if !apiResp.Success {
+		if apiResp.Error == nil {
+			apiResp.Error = &Error{Type: "Unknown Error", Message: fmt.Sprintf("HTTP %d", resp.StatusCode)}
+		}
+		apiResp.Error.Action = action
+		return false, apiResp.Error
+	}
+	if err := json.Unmarshal(apiResp.Result, result); err != nil {
+		return false, fmt.Errorf("decoding ckan %s result: %v", action, err)
+	}
+	return false, nil
+}
+
+// PackageShow returns a package, by ID or name.
+func (c *Client) PackageShow(ctx context.Context, id string) (*Result, error) {
+	var pkg Result
+	if err := c.call(ctx, "package_show", url.Values{"id": {id}}, &pkg); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// PackageList returns the names of the portal's packages, limit at a time starting at offset. A
+// limit of 0 returns every package.
+func (c *Client) PackageList(ctx context.Context, limit, offset int) ([]string, error) {
+	params := url.Values{}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	var names []string
+	if err := c.call(ctx, "package_list", params, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ResourceShow returns a resource by ID.
+func (c *Client) ResourceShow(ctx context.Context, id string) (*Resource, error) {
+	var res Resource
+	if err := c.call(ctx, "resource_show", url.Values{"id": {id}}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SearchRequest are the parameters of package_search.
+type SearchRequest struct {
+	// Query is a Solr query, e.g. "budget".
+	Query string
+	// FilterQuery is a Solr filter query, e.g. "tags:transportation".
+	FilterQuery string
+	// Sort is e.g. "metadata_modified desc".
+	Sort string
+	// Rows is how many packages to return, and Start the offset of the first.
+	Rows, Start int
+}
+
+// SearchResult is a page of packages matching a search.
+type SearchResult struct {
+	// Count is how many packages match in all.
+	Count   int      `json:"count"`
+	Results []Result `json:"results"`
+}
+
+// PackageSearch returns a page of packages matching a search.
+func (c *Client) PackageSearch(ctx context.Context, req *SearchRequest) (*SearchResult, error) {
+	params := url.Values{}
+	setParam(params, "q", req.Query)
+	setParam(params, "fq", req.FilterQuery)
+	setParam(params, "sort", req.Sort)
+	if req.Rows > 0 {
+		params.Set("rows", strconv.Itoa(req.Rows))
+	}
+	if req.Start > 0 {
+		params.Set("start", strconv.Itoa(req.Start))
+	}
+	var result SearchResult
+	if err := c.call(ctx, "package_search", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DatastoreSearchRequest are the parameters of datastore_search.
+type DatastoreSearchRequest struct {
+	ResourceID string
+	// Query is a full text search of the records.
+	Query string
+	// Filters match records with the given value of each field.
+	Filters map[string]interface{}
+	// Fields limits the fields returned, which are all returned if it's empty.
+	Fields []string
+	// Sort is e.g. "_id desc".
+	Sort string
+	// Limit is how many records to return, and Offset the offset of the first.
+	Limit, Offset int
+}
+
+// DatastoreField is a field of the records in a resource's datastore.
+type DatastoreField struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// DatastoreResult is a page of records from a resource's datastore.
+type DatastoreResult struct {
+	ResourceID string                   `json:"resource_id"`
+	Fields     []DatastoreField         `json:"fields"`
+	Records    []map[string]interface{} `json:"records"`
+	// Total is how many records match in all. It isn't set by datastore_search_sql.
+	Total int `json:"total"`
+}
+
+// DatastoreSearch returns a page of records from a resource's datastore.
+func (c *Client) DatastoreSearch(ctx context.Context, req *DatastoreSearchRequest) (*DatastoreResult, error) {
+	params := url.Values{"resource_id": {req.ResourceID}}
+	setParam(params, "q", req.Query)
+	setParam(params, "sort", req.Sort)
+	if len(req.Filters) > 0 {
+		filters, err := json.Marshal(req.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("encoding filters: %v", err)
+		}
+		params.Set("filters", string(filters))
+	}
+	if len(req.Fields) > 0 {
+		params.Set("fields", strings.Join(req.Fields, ","))
+	}
+	if req.Limit > 0 {
+		params.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if req.Offset > 0 {
+		params.Set("offset", strconv.Itoa(req.Offset))
+	}
+	var result DatastoreResult
+	if err := c.call(ctx, "datastore_search", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DatastoreSearchSQL runs a read-only SQL query against the datastore, in which each resource is a
+// table named by its ID, e.g. `SELECT * FROM "<resource-id>" LIMIT 5`.
+func (c *Client) DatastoreSearchSQL(ctx context.Context, sql string) (*DatastoreResult, error) {
+	var result DatastoreResult
+	if err := c.call(ctx, "datastore_search_sql", url.Values{"sql": {sql}}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
@@ -0,0 +1,286 @@
+package opendata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCKAN serves a CKAN action with handle, counting the requests made to it.
+func fakeCKAN(t *testing.T, action string, handle func(w http.ResponseWriter, r *http.Request)) (*Client, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/api/3/action/"+action {
+			t.Errorf("request for %s, want %s", r.URL.Path, action)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		handle(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return NewClient(WithBaseURL(srv.URL+"/"), WithRetries(2, time.Millisecond)), &requests
+}
+
+func TestRetry(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// statuses are the HTTP statuses of the first responses, after which the request succeeds.
+		statuses []int
+		requests int32
+		wantErr  bool
+	}{
+		{"success", nil, 1, false},
+		{"server error", []int{http.StatusInternalServerError}, 2, false},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusBadGateway}, 3, false},
+		{"retries exhausted", []int{503, 503, 503, 503}, 3, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var n int32
+			c, requests := fakeCKAN(t, "package_show", func(w http.ResponseWriter, r *http.Request) {
+				if i := atomic.AddInt32(&n, 1) - 1; int(i) < len(test.statuses) {
+					w.WriteHeader(test.statuses[i])
+					return
+				}
+				fmt.Fprintf(w, `{"success": true, "result": {"name": %q}}`, r.URL.Query().Get("id"))
+			})
+			pkg, err := c.PackageShow(context.Background(), "budget")
+			if test.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+			} else if err != nil || pkg.Name != "budget" {
+				t.Errorf("PackageShow = %+v, %v", pkg, err)
+			}
+			if got := atomic.LoadInt32(requests); got != test.requests {
+				t.Errorf("made %d requests, want %d", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := NewClient(WithBaseURL(srv.URL), WithRetries(2, 20*time.Millisecond))
+	if _, err := c.PackageList(context.Background(), 0, 0); err == nil {
+		t.Fatal("got no error")
+	}
+	if len(times) != 3 {
+		t.Fatalf("made %d requests, want 3", len(times))
+	}
+	if gap := times[1].Sub(times[0]); gap < 20*time.Millisecond {
+		t.Errorf("first retry after %v, want at least 20ms", gap)
+	}
+	if gap := times[2].Sub(times[1]); gap < 40*time.Millisecond {
+		t.Errorf("second retry after %v, want the backoff doubled to at least 40ms", gap)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		body   string
+		is     error
+		want   string
+	}{
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			body:   `{"success": false, "error": {"__type": "Not Found Error", "message": "Not found"}}`,
+			is:     ErrNotFound,
+			want:   "ckan package_show: Not Found Error: Not found",
+		},
+		{
+			name:   "authorization",
+			status: http.StatusForbidden,
+			body:   `{"success": false, "error": {"__type": "Authorization Error", "message": "Access denied"}}`,
+			is:     ErrAuthorization,
+			want:   "ckan package_show: Authorization Error: Access denied",
+		},
+		{
+			name:   "validation",
+			status: http.StatusConflict,
+			body:   `{"success": false, "error": {"__type": "Validation Error", "id": ["Missing value"]}}`,
+			is:     ErrValidation,
+			want:   "ckan package_show: Validation Error: id: Missing value",
+		},
+		{
+			name:   "no error details",
+			status: http.StatusOK,
+			body:   `{"success": false}`,
+			want:   "ckan package_show: Unknown Error: HTTP 200",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, requests := fakeCKAN(t, "package_show", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+			_, err := c.PackageShow(context.Background(), "budget")
+			var ckanErr *Error
+			if !errors.As(err, &ckanErr) {
+				t.Fatalf("PackageShow returned %v, want an *Error", err)
+			}
+			if err.Error() != test.want {
+				t.Errorf("error = %q, want %q", err, test.want)
+			}
+			for _, target := range []error{ErrNotFound, ErrAuthorization, ErrValidation} {
+				if got := errors.Is(err, target); got != (target == test.is) {
+					t.Errorf("errors.Is(err, %v) = %t", target, got)
+				}
+			}
+			// CKAN's errors aren't retried.
+			if n := atomic.LoadInt32(requests); n != 1 {
+				t.Errorf("made %d requests, want 1", n)
+			}
+		})
+	}
+
+	c, _ := fakeCKAN(t, "package_show", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<html>Bad Request</html>")
+	})
+	if _, err := c.PackageShow(context.Background(), "budget"); err == nil || !strings.Contains(err.Error(), "HTTP 400") {
+		t.Errorf("non-JSON error response returned %v, want the HTTP status", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	c, requests := fakeCKAN(t, "package_show", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	// A cancellation while waiting to retry stops the retries.
+	c.backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := c.PackageShow(ctx, "budget"); !errors.Is(err, context.Canceled) {
+		t.Errorf("PackageShow returned %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("PackageShow returned after %v", elapsed)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+
+	// So does one while a request is in flight, which isn't retried.
+	release := make(chan struct{})
+	defer close(release)
+	c, requests = fakeCKAN(t, "package_show", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.PackageShow(ctx, "budget"); err == nil {
+		t.Error("PackageShow succeeded after its deadline")
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	c, requests := fakeCKAN(t, "package_search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("q") != "budget" {
+			t.Errorf("q = %q, want budget", q.Get("q"))
+		}
+		start, _ := strconv.Atoi(q.Get("start"))
+		rows, _ := strconv.Atoi(q.Get("rows"))
+		var results []string
+		for i := start; i < start+rows && i < 5; i++ {
+			results = append(results, fmt.Sprintf(`{"name": "package-%d"}`, i))
+		}
+		fmt.Fprintf(w, `{"success": true, "result": {"count": 5, "results": [%s]}}`, strings.Join(results, ","))
+	})
+
+	it := c.Packages(&SearchRequest{Query: "budget", Rows: 2})
+	var names []string
+	for it.Next(context.Background()) {
+		names = append(names, it.Package().Name)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); got != "package-0,package-1,package-2,package-3,package-4" {
+		t.Errorf("packages = %s", got)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+	if it.Next(context.Background()) {
+		t.Error("Next returned true after the last package")
+	}
+}
+
+func TestRecords(t *testing.T) {
+	var failAt int32 = -1
+	c, requests := fakeCKAN(t, "datastore_search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("resource_id") != "res" || q.Get("filters") != `{"ward":"Davenport"}` {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if offset == int(atomic.LoadInt32(&failAt)) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"success": false, "error": {"__type": "Not Found Error", "message": "Resource gone"}}`)
+			return
+		}
+		var records []string
+		for i := offset; i < offset+limit && i < 4; i++ {
+			records = append(records, fmt.Sprintf(`{"_id": %d}`, i+1))
+		}
+		fmt.Fprintf(w, `{"success": true, "result": {"fields": [{"id": "_id", "type": "int"}], "records": [%s], "total": 4}}`,
+			strings.Join(records, ","))
+	})
+
+	req := &DatastoreSearchRequest{ResourceID: "res", Filters: map[string]interface{}{"ward": "Davenport"}, Limit: 3}
+	it := c.Records(req)
+	var ids []string
+	for it.Next(context.Background()) {
+		ids = append(ids, fmt.Sprint(it.Record()["_id"]))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, ","); got != "1,2,3,4" {
+		t.Errorf("records = %s", got)
+	}
+	if fields := it.Fields(); len(fields) != 1 || fields[0].ID != "_id" {
+		t.Errorf("fields = %+v", fields)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("made %d requests, want 2", n)
+	}
+
+	// An error ends iteration after the records already fetched.
+	atomic.StoreInt32(&failAt, 3)
+	it = c.Records(req)
+	ids = nil
+	for it.Next(context.Background()) {
+		ids = append(ids, fmt.Sprint(it.Record()["_id"]))
+	}
+	if got := strings.Join(ids, ","); got != "1,2,3" || !errors.Is(it.Err(), ErrNotFound) {
+		t.Errorf("records = %s, error = %v, want 1,2,3 then a not found error", got, it.Err())
+	}
+}
@@ -4,4 +4,6 @@ type Dataset string
 
 const (
 	ServiceRequestsDataset string = "311-service-requests-customer-initiated"
+	OperatingBudgetDataset string = "2c90a5d3-5598-4c02-abf2-169456c8f1f1"
+	ASEChargesDataset      string = "537923d1-a6c8-4b9c-9d55-fa47d9d7ddab"
 )
@@ -0,0 +1,132 @@
+package opendata
+
+import "context"
+
+// defaultPageSize is how many packages or records iterators request at a time, unless the request
+// sets its own.
+const defaultPageSize = 100
+
+// PackageIterator pages through the packages matching a search:
+//
+//	it := client.Packages(&opendata.SearchRequest{Query: "budget"})
+//	for it.Next(ctx) {
+//		pkg := it.Package()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type PackageIterator struct {
+	c    *Client
+	req  SearchRequest
+	page []Result
+	pkg  *Result
+	err  error
+	done bool
+}
+
+// Packages returns an iterator over every package matching a search, starting at req.Start and
+// requesting req.Rows packages at a time.
+func (c *Client) Packages(req *SearchRequest) *PackageIterator {
+	it := &PackageIterator{c: c, req: *req}
+	if it.req.Rows <= 0 {
+		it.req.Rows = defaultPageSize
+	}
+	return it
+}
+
+// Next advances to the next package, returning false when there are no more or an error occurred.
+func (it *PackageIterator) Next(ctx context.Context) bool {
+	if len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		result, err := it.c.PackageSearch(ctx, &it.req)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = result.Results
+		it.req.Start += len(result.Results)
+		it.done = len(result.Results) < it.req.Rows || it.req.Start >= result.Count
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.pkg = &it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Package returns the current package.
+func (it *PackageIterator) Package() *Result {
+	return it.pkg
+}
+
+// Err returns the error which stopped iteration, if any.
+func (it *PackageIterator) Err() error {
+	return it.err
+}
+
+// RecordIterator pages through the records of a resource's datastore, in the same way as
+// PackageIterator.
+type RecordIterator struct {
+	c      *Client
+	req    DatastoreSearchRequest
+	fields []DatastoreField
+	page   []map[string]interface{}
+	record map[string]interface{}
+	err    error
+	done   bool
+}
+
+// Records returns an iterator over every record matching a datastore search, starting at req.Offset
+// and requesting req.Limit records at a time.
+func (c *Client) Records(req *DatastoreSearchRequest) *RecordIterator {
+	it := &RecordIterator{c: c, req: *req}
+	if it.req.Limit <= 0 {
+		it.req.Limit = defaultPageSize
+	}
+	return it
+}
+
+// Next advances to the next record, returning false when there are no more or an error occurred.
+func (it *RecordIterator) Next(ctx context.Context) bool {
+	if len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		result, err := it.c.DatastoreSearch(ctx, &it.req)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if it.fields == nil {
+			it.fields = result.Fields
+		}
+		it.page = result.Records
+		it.req.Offset += len(result.Records)
+		it.done = len(result.Records) < it.req.Limit || it.req.Offset >= result.Total
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.record = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Fields returns the fields of the records, once Next has been called.
+func (it *RecordIterator) Fields() []DatastoreField {
+	return it.fields
+}
+
+// Record returns the current record.
+func (it *RecordIterator) Record() map[string]interface{} {
+	return it.record
+}
+
+// Err returns the error which stopped iteration, if any.
+func (it *RecordIterator) Err() error {
+	return it.err
+}
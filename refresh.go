@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/geomodulus/torontobot/schedule"
+)
+
+// runRefreshes runs the ingest program's refresh command on a schedule until ctx is done, so the data
+// stays current while the bots are live. Subscriptions to refreshed tables fire as they would after
+// any other ingest.
+func runRefreshes(ctx context.Context, sched *schedule.Schedule, ingestCmd, dbFile string) {
+	for {
+		next := sched.Next(time.Now().UTC())
+		if next.IsZero() {
+			log.Printf("Refresh schedule %q never runs\n", sched)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		log.Println("Refreshing datasets")
+		cmd := exec.CommandContext(ctx, ingestCmd, "--db-file", dbFile, "refresh")
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Println("Error refreshing datasets:", err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	_ "github.com/mattn/go-sqlite3"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+func main() {
+	dbFile := flag.String("db-file", "../db/toronto.db", "Database file for tabular city data")
+	tableName := flag.String("table", "", "Only include queries against this table")
+	minScore := flag.Int("min-score", 1, "Minimum net rating for few-shot candidates")
+	flag.Parse()
+
+	db, err := sql.Open("sqlite3", *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "accuracy":
+		results, err := uq.AccuracyByTable(db)
+		if err != nil {
+			log.Fatalf("Error loading accuracy: %v", err)
+		}
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"Table", "Rated queries", "👍", "👎", "Accuracy"})
+		for _, r := range results {
+			if *tableName != "" && r.TableName != *tableName {
+				continue
+			}
+			tw.AppendRow(table.Row{
+				r.TableName,
+				r.Queries,
+				r.ThumbsUp,
+				r.ThumbsDown,
+				fmt.Sprintf("%.1f%%", r.Accuracy()*100),
+			})
+		}
+		fmt.Println(tw.Render())
+
+	case "thumbs-down":
+		queries, err := uq.ThumbsDownQueries(db)
+		if err != nil {
+			log.Fatalf("Error loading thumbs-down queries: %v", err)
+		}
+		for _, q := range queries {
+			if *tableName != "" && q.TableName != *tableName {
+				continue
+			}
+			fmt.Printf("#%d [%s] score %d\n", q.ID, q.TableName, q.Score)
+			fmt.Printf("  Question: %s\n", q.Question)
+			fmt.Printf("  SQL: %s\n", q.SQLResponse.SQL)
+			for _, comment := range q.Comments {
+				fmt.Printf("  Comment: %s\n", strings.ReplaceAll(comment, "\n", " "))
+			}
+			fmt.Println()
+		}
+
+	case "few-shot":
+		queries, err := uq.TopRatedQueries(db, *minScore)
+		if err != nil {
+			log.Fatalf("Error loading top rated queries: %v", err)
+		}
+		// Emit examples in the same shape as few_shot_examples in bot/tables.json5.
+		examples := []interface{}{}
+		for _, q := range queries {
+			if *tableName != "" && q.TableName != *tableName {
+				continue
+			}
+			args, err := json.Marshal(map[string]interface{}{
+				"schema":             q.SQLResponse.Schema,
+				"applicability":      q.SQLResponse.Applicability,
+				"sql":                q.SQLResponse.SQL,
+				"result_is_currency": q.SQLResponse.IsCurrency,
+			})
+			if err != nil {
+				log.Fatalf("Error marshalling query %d: %v", q.ID, err)
+			}
+			examples = append(examples,
+				map[string]interface{}{
+					"role":    "user",
+					"content": q.Question,
+				},
+				map[string]interface{}{
+					"role": "assistant",
+					"function_call": map[string]string{
+						"name":      "sql_analysis",
+						"arguments": string(args),
+					},
+				})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(examples); err != nil {
+			log.Fatal(err)
+		}
+
+	case "publications":
+		pubs, err := uq.Publications(db, 100)
+		if err != nil {
+			log.Fatalf("Error loading publications: %v", err)
+		}
+		tw := table.NewWriter()
+		tw.AppendHeader(table.Row{"Published", "Module", "Query", "By", "Approved by", "URL"})
+		for _, p := range pubs {
+			tw.AppendRow(table.Row{
+				p.CreatedAt.Format("2006-01-02 15:04"),
+				p.ModuleID,
+				p.QueryID,
+				p.Username,
+				p.ApprovedBy,
+				p.URL,
+			})
+		}
+		fmt.Println(tw.Render())
+
+	default:
+		log.Fatal(`# TorontoBot Report
+
+Summarizes user feedback on TorontoBot answers. Available reports:
+  1. accuracy     share of thumbs up per table
+  2. thumbs-down  questions rated thumbs down, with any comments
+  3. few-shot     highly rated question/SQL pairs, formatted as few_shot_examples for tables.json5
+  4. publications audit log of the latest charts published to the web, and who approved them
+
+Pass the report name as an argument to this program. For example:
+  ./report accuracy
+
+You can scope any report to a single table by passing the --table flag. For example:
+  ./report --table operating_budget few-shot
+`)
+	}
+}
@@ -0,0 +1,181 @@
+// Package schedule parses cron-like schedule expressions.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	spec                                 string
+	minute, hour, dayOfMonth, month, dow uint64
+	// domStar and dowStar record whether the day fields started with "*", as in "*" or "*/2", which
+	// changes how they combine.
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	// Both 0 and 7 are Sunday.
+	{"day of week", 0, 7},
+}
+
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Parse parses a standard five field cron expression: minute, hour, day of month, month and day of
+// week. Fields may be "*", numbers, ranges like "1-5", lists like "1,15" and steps like "*/15". The
+// shortcuts @hourly, @daily, @weekly, @monthly and @yearly are also accepted.
+func Parse(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if shortcut, ok := shortcuts[strings.ToLower(expr)]; ok {
+		expr = shortcut
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("schedule %q must have %d fields: minute hour day-of-month month day-of-week", spec, len(fields))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", spec, err)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &Schedule{
+		spec:       strings.TrimSpace(spec),
+		minute:     bits[0],
+		hour:       bits[1],
+		dayOfMonth: bits[2],
+		month:      bits[3],
+		dow:        bits[4],
+		domStar:    strings.HasPrefix(parts[2], "*"),
+		dowStar:    strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", f.name, item)
+			}
+			rng, step = item[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s field %q", f.name, item)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s field %q", f.name, item)
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5.
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", f.name, item, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// MinInterval returns the shortest time between two runs of the schedule. Days only ever add time
+// between runs, so it's the shortest gap between consecutive times of day, including from the last
+// time of one day to the first of the next.
+func (s *Schedule) MinInterval() time.Duration {
+	var times []int
+	for h := 0; h < 24; h++ {
+		for m := 0; m < 60; m++ {
+			if s.hour&(1<<uint(h)) != 0 && s.minute&(1<<uint(m)) != 0 {
+				times = append(times, h*60+m)
+			}
+		}
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	shortest := times[0] + 24*60 - times[len(times)-1]
+	for i := 1; i < len(times); i++ {
+		if gap := times[i] - times[i-1]; gap < shortest {
+			shortest = gap
+		}
+	}
+	return time.Duration(shortest) * time.Minute
+}
+
+// Next returns the first time matching the schedule strictly after t, to the minute. It returns the
+// zero time if nothing matches within five years, as with "0 0 31 2 *".
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay follows cron: when both day fields are restricted, a day matching either one matches.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
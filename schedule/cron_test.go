@@ -0,0 +1,110 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1- * * * *",
+		"@fortnightly",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday, October 18, 2023.
+	from := time.Date(2023, 10, 18, 9, 58, 30, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		want []string
+	}{
+		{"* * * * *", []string{"2023-10-18 09:59", "2023-10-18 10:00"}},
+		{"*/15 * * * *", []string{"2023-10-18 10:00", "2023-10-18 10:15"}},
+		{"5/20 * * * *", []string{"2023-10-18 10:05", "2023-10-18 10:25", "2023-10-18 10:45", "2023-10-18 11:05"}},
+		{"0 */2 * * *", []string{"2023-10-18 10:00", "2023-10-18 12:00"}},
+		{"0 9-11 * * *", []string{"2023-10-18 10:00", "2023-10-18 11:00", "2023-10-19 09:00"}},
+		{"30 8 1,15 * *", []string{"2023-11-01 08:30", "2023-11-15 08:30"}},
+		{"0 0 29 2 *", []string{"2024-02-29 00:00", "2028-02-29 00:00"}},
+		{"@weekly", []string{"2023-10-22 00:00", "2023-10-29 00:00"}},
+		{"@HOURLY", []string{"2023-10-18 10:00", "2023-10-18 11:00"}},
+		// Both 0 and 7 are Sunday.
+		{"0 12 * * 7", []string{"2023-10-22 12:00", "2023-10-29 12:00"}},
+		{"0 12 * * 5-7", []string{"2023-10-20 12:00", "2023-10-21 12:00", "2023-10-22 12:00", "2023-10-27 12:00"}},
+		// When both day fields are restricted, a day matching either runs: the 20th, or Mondays.
+		{"0 0 20 * 1", []string{"2023-10-20 00:00", "2023-10-23 00:00", "2023-10-30 00:00"}},
+		// A day field starting with "*" isn't a restriction, so the other one must match as well:
+		// Mondays on odd days of the month.
+		{"0 0 */2 * 1", []string{"2023-10-23 00:00", "2023-11-13 00:00"}},
+		// "*/7" is 0 and 7, both Sunday, so this is Sundays falling on the 13th.
+		{"0 0 13 * */7", []string{"2024-10-13 00:00", "2025-04-13 00:00"}},
+	} {
+		sched, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		next := from
+		for i, want := range test.want {
+			next = sched.Next(next)
+			if got := next.Format("2006-01-02 15:04"); got != want {
+				t.Errorf("%q run %d = %s, want %s", test.spec, i+1, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	sched, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := sched.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Next = %v, want the zero time", next)
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want time.Duration
+	}{
+		{"* * * * *", time.Minute},
+		// Runs an hour apart today, but every minute during 9am tomorrow.
+		{"* 9 * * *", time.Minute},
+		{"*/30 * * * *", 30 * time.Minute},
+		{"@hourly", time.Hour},
+		{"0 */2 * * *", 2 * time.Hour},
+		{"0 9,17 * * *", 8 * time.Hour},
+		// From 11pm to midnight the next day.
+		{"0 0,23 * * *", time.Hour},
+		{"30 8 * * 1", 24 * time.Hour},
+	} {
+		sched, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		if got := sched.MinInterval(); got != test.want {
+			t.Errorf("%q MinInterval = %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
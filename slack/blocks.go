@@ -0,0 +1,128 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const (
+	// Slack limits on block text.
+	maxSectionTextLen = 3000
+	maxHeaderLen      = 150
+	// maxFallbackTextLen keeps the notification text of messages with blocks short.
+	maxFallbackTextLen = 150
+)
+
+// message is a message sent with chat.postMessage, chat.update or a response URL.
+type message struct {
+	Channel  string   `json:"channel,omitempty"`
+	TS       string   `json:"ts,omitempty"`
+	ThreadTS string   `json:"thread_ts,omitempty"`
+	Text     string   `json:"text"`
+	Blocks   []*block `json:"blocks,omitempty"`
+
+	// ResponseType only applies to slash command responses and response URLs.
+	ResponseType string `json:"response_type,omitempty"`
+}
+
+// block is a Block Kit layout block.
+type block struct {
+	Type     string        `json:"type"`
+	Text     *text         `json:"text,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+// text is a Block Kit text object, either "mrkdwn" or "plain_text".
+type text struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+// button is a Block Kit button element. Clicking it sends an interaction with its ActionID and Value.
+type button struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text"`
+	ActionID string `json:"action_id"`
+	Value    string `json:"value"`
+	Style    string `json:"style,omitempty"`
+}
+
+func mrkdwn(s string) *text {
+	return &text{Type: "mrkdwn", Text: s}
+}
+
+func plainText(s string) *text {
+	return &text{Type: "plain_text", Text: s, Emoji: true}
+}
+
+func section(s string) *block {
+	return &block{Type: "section", Text: mrkdwn(truncate(s, maxSectionTextLen))}
+}
+
+// newButton returns a button whose value is an ID, such as a stored query's.
+func newButton(label, actionID string, id int64) *button {
+	return &button{
+		Type:     "button",
+		Text:     plainText(label),
+		ActionID: actionID,
+		Value:    strconv.FormatInt(id, 10),
+	}
+}
+
+// queryBlocks renders the question of a stored answer, its results and analysis, and its SQL.
+func queryBlocks(query *uq.UserQuery) []*block {
+	blocks := []*block{
+		{Type: "header", Text: plainText(truncate(query.Question, maxHeaderLen))},
+		// Code blocks keep the rendered table aligned.
+		section("```" + truncate(escape(query.Results), maxSectionTextLen-6) + "```"),
+	}
+	if query.SQLResponse.Applicability != "" {
+		blocks = append(blocks, section("*Analysis*\n"+escape(query.SQLResponse.Applicability)))
+	}
+	return append(blocks, section("*SQL*\n`"+truncate(escape(query.SQLResponse.SQL), maxSectionTextLen-20)+"`"))
+}
+
+// answerBlocks renders a stored answer: the question, its results and analysis, and buttons to chart
+// and export it. Export is only offered in channels, not DMs, and only once it's been configured.
+func (s *BotServer) answerBlocks(query *uq.UserQuery, canExport bool) []*block {
+	blocks := queryBlocks(query)
+
+	footer := []string{fmt.Sprintf("Query #%d", query.ID)}
+	if table, ok := s.bot.Table(query.TableName); ok {
+		footer = append(footer, "Dataset: "+table.Name)
+		if table.Source != "" {
+			footer = append(footer, fmt.Sprintf("<%s|Source>", table.Source))
+		}
+		if refreshed := s.refreshedText(table.Name); refreshed != "" {
+			footer = append(footer, refreshed)
+		}
+	}
+	blocks = append(blocks, &block{
+		Type:     "context",
+		Elements: []interface{}{mrkdwn(strings.Join(footer, " • "))},
+	})
+
+	buttons := []interface{}{newButton("📊 Generate chart", "chart", query.ID)}
+	if canExport && s.exportEnabled() && s.publisher.HasGraphStore() {
+		buttons = append(buttons, newButton("🌐 Export to Web", "export", query.ID))
+	}
+	return append(blocks, &block{Type: "actions", Elements: buttons})
+}
+
+// escape escapes the characters Slack treats as control characters in message text.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// truncate shortens s to at most maxLen characters, which is how Slack measures its limits.
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen-3]) + "..."
+}
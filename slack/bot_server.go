@@ -0,0 +1,140 @@
+// Package slack runs TorontoBot as a Slack app, answering the /torontobot slash command, mentions
+// and direct messages.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+)
+
+const (
+	// maxRequestAge is how old a signed request from Slack may be before it's rejected as a replay.
+	maxRequestAge = 5 * time.Minute
+	// maxRequestSize is the largest request body accepted from Slack.
+	maxRequestSize = 1 << 20
+)
+
+// BotServer handles requests from Slack and answers through the Web API. Slack delivers slash
+// commands, events and button clicks as HTTP requests, so it's served from Handler rather than
+// holding a connection open like Discord.
+type BotServer struct {
+	bot *bot.TorontoBot
+	// publisher publishes answers to the web. It's the bot, except in tests.
+	publisher     publisher
+	db            *sql.DB
+	client        *client
+	signingSecret string
+	// userID is the bot's own Slack user ID, which is stripped from mentions.
+	userID string
+
+	publishers, moderators map[string]bool
+	moderationChannel      string
+}
+
+type Option func(*BotServer)
+
+// WithAPIURL sends Web API requests to baseURL rather than DefaultAPIURL, e.g. to test against a local
+// server emulating Slack.
+func WithAPIURL(baseURL string) Option {
+	return func(s *BotServer) {
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		s.client.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient makes Web API requests with the given HTTP client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(s *BotServer) {
+		s.client.http = httpClient
+	}
+}
+
+// OpenBotServer checks the bot token with Slack and returns a server for the app's requests, which
+// are verified with its signing secret.
+func OpenBotServer(ctx context.Context, db *sql.DB, token, signingSecret string, tb *bot.TorontoBot, options ...Option) (*BotServer, error) {
+	s := &BotServer{
+		bot:       tb,
+		publisher: tb,
+		db:        db,
+		client: &client{
+			baseURL: DefaultAPIURL,
+			token:   token,
+			http:    &http.Client{Timeout: 30 * time.Second},
+		},
+		signingSecret: signingSecret,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	userID, err := s.client.authTest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating with Slack: %v", err)
+	}
+	s.userID = userID
+	return s, nil
+}
+
+// Handler returns the routes to configure in the Slack app: /slack/commands as the slash command's
+// request URL, /slack/events for event subscriptions and /slack/interactions for interactivity.
+func (s *BotServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/commands", s.verified(s.handleCommand))
+	mux.HandleFunc("/slack/events", s.verified(s.handleEvent))
+	mux.HandleFunc("/slack/interactions", s.verified(s.handleInteraction))
+	return mux
+}
+
+// verified rejects requests without a valid Slack signature. See
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func (s *BotServer) verified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !s.validSignature(r.Header, body, time.Now()) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+func (s *BotServer) validSignature(header http.Header, body []byte, now time.Time) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return false
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header.Get("X-Slack-Signature"), "v0="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.signingSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
@@ -0,0 +1,203 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultAPIURL is the base URL of Slack's Web API.
+const DefaultAPIURL = "https://slack.com/api/"
+
+// client calls the methods of Slack's Web API used by the bot.
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// apiError is an error returned by a Web API method, such as "not_in_channel".
+type apiError struct {
+	method string
+	code   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.method, e.code)
+}
+
+type apiResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// call POSTs a JSON request to a Web API method, decoding the response into resp if it's not nil.
+func (c *client) call(ctx context.Context, method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("encoding %s request: %v", method, err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	return c.do(httpReq, method, resp)
+}
+
+// callForm POSTs a form-encoded request to a Web API method. Some methods, such as
+// files.getUploadURLExternal, don't accept JSON.
+func (c *client) callForm(ctx context.Context, method string, form url.Values, resp interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+method, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	return c.do(httpReq, method, resp)
+}
+
+func (c *client) do(httpReq *http.Request, method string, resp interface{}) error {
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("calling slack %s: %v", method, err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("reading slack %s response: %v", method, err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack %s: HTTP %d", method, httpResp.StatusCode)
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("decoding slack %s response: %v", method, err)
+	}
+	if !result.OK {
+		return &apiError{method: method, code: result.Error}
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("decoding slack %s response: %v", method, err)
+	}
+	return nil
+}
+
+// authTest returns the user ID of the bot the token belongs to.
+func (c *client) authTest(ctx context.Context) (string, error) {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.call(ctx, "auth.test", struct{}{}, &resp); err != nil {
+		return "", err
+	}
+	return resp.UserID, nil
+}
+
+// postMessage sends a message, returning its timestamp, which identifies it within the channel.
+func (c *client) postMessage(ctx context.Context, msg *message) (string, error) {
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	if err := c.call(ctx, "chat.postMessage", msg, &resp); err != nil {
+		return "", err
+	}
+	return resp.TS, nil
+}
+
+// updateMessage replaces the content of the message with msg.TS.
+func (c *client) updateMessage(ctx context.Context, msg *message) error {
+	return c.call(ctx, "chat.update", msg, nil)
+}
+
+// uploadFile shares a file in a channel, in reply to the message with threadTS if it's set, using
+// Slack's two step external upload.
+func (c *client) uploadFile(ctx context.Context, channel, threadTS, filename, title, comment string, data []byte) error {
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := c.callForm(ctx, "files.getUploadURLExternal", url.Values{
+		"filename": {filename},
+		"length":   {strconv.Itoa(len(data))},
+	}, &upload); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.UploadURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading file: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("uploading file: HTTP %d", resp.StatusCode)
+	}
+
+	type fileRef struct {
+		ID    string `json:"id"`
+		Title string `json:"title,omitempty"`
+	}
+	return c.call(ctx, "files.completeUploadExternal", &struct {
+		Files          []fileRef `json:"files"`
+		ChannelID      string    `json:"channel_id"`
+		ThreadTS       string    `json:"thread_ts,omitempty"`
+		InitialComment string    `json:"initial_comment,omitempty"`
+	}{
+		Files:          []fileRef{{ID: upload.FileID, Title: title}},
+		ChannelID:      channel,
+		ThreadTS:       threadTS,
+		InitialComment: comment,
+	}, nil)
+}
+
+// respond sends a message to the response URL of a slash command or interaction. Unlike the Web
+// API, it doesn't require the bot to be in the channel.
+func (c *client) respond(ctx context.Context, responseURL string, msg *message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("responding: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("responding: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
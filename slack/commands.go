@@ -0,0 +1,201 @@
+package slack
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+const usage = "Ask me a question about City of Toronto open data, e.g. `/torontobot What are the 8 most expensive programs?`, " +
+	"or see what I know about with `/torontobot datasets`. You can also mention me in a channel or send me a direct message."
+
+// question is a question asked by a Slack user, along with where to answer it.
+type question struct {
+	text    string
+	userID  string
+	teamID  string
+	channel string
+	// threadTS is the thread to answer in, if any.
+	threadTS string
+	// responseURL is where to tell the user about errors when the bot can't post in the channel.
+	responseURL string
+}
+
+// isDM reports whether the question was asked in a direct message.
+func (q *question) isDM() bool {
+	return strings.HasPrefix(q.channel, "D")
+}
+
+// handleCommand answers the /torontobot slash command. Slack expects a response within three
+// seconds, so questions are acknowledged straight away and answered in the channel once ready.
+func (s *BotServer) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(r.PostForm.Get("text"))
+	switch strings.ToLower(text) {
+	case "", "help":
+		writeEphemeral(w, usage)
+		return
+	case "datasets":
+		writeEphemeral(w, s.datasetsText())
+		return
+	}
+
+	log.Printf("Received Slack question: %s\n", text)
+	go s.answer(context.Background(), &question{
+		text:        text,
+		userID:      r.PostForm.Get("user_id"),
+		teamID:      r.PostForm.Get("team_id"),
+		channel:     r.PostForm.Get("channel_id"),
+		responseURL: r.PostForm.Get("response_url"),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeEphemeral responds to a slash command with a message only the user who sent it can see.
+func writeEphemeral(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&message{
+		ResponseType: "ephemeral",
+		Text:         content,
+	}); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// datasetsText lists the datasets TorontoBot can answer questions about.
+func (s *BotServer) datasetsText() string {
+	lines := []string{"Here's what I can answer questions about:"}
+	for _, table := range s.bot.Tables() {
+		line := fmt.Sprintf("• *%s*: %s", table.Name, escape(table.Desc))
+		if len(table.Examples) > 0 {
+			line += fmt.Sprintf(" _Try asking: %s_", escape(table.Examples[0]))
+		}
+		if refreshed := s.refreshedText(table.Name); refreshed != "" {
+			line += " (" + refreshed + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// refreshedText describes when a table's data was last refreshed, or returns "" if it's not known.
+func (s *BotServer) refreshedText(tableName string) string {
+	refreshed, err := s.bot.LastRefreshed(tableName)
+	if err != nil {
+		log.Println("Error getting last refresh:", err)
+	}
+	return bot.RefreshedText(refreshed)
+}
+
+// answer posts a question to its channel, edits in progress as it's answered, and replaces it with
+// the answer.
+func (s *BotServer) answer(ctx context.Context, q *question) {
+	out := fmt.Sprintf("Question: *%s*", escape(q.text))
+	ts, err := s.client.postMessage(ctx, &message{
+		Channel:  q.channel,
+		ThreadTS: q.threadTS,
+		Text:     out,
+	})
+	if err != nil {
+		log.Println("Error posting question:", err)
+		msg := "Sorry, something went wrong. Please try again."
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.code == "not_in_channel" {
+			msg = "I can't post in this channel yet. Invite me with `/invite @TorontoBot` and ask again."
+		}
+		s.respondEphemeral(ctx, q.responseURL, msg)
+		return
+	}
+	edit := func(content string) {
+		if err := s.client.updateMessage(ctx, &message{
+			Channel: q.channel,
+			TS:      ts,
+			Text:    content,
+		}); err != nil {
+			log.Println("Error editing message:", err)
+		}
+	}
+
+	answer, err := s.bot.Ask(ctx, &bot.AskRequest{
+		Question: q.text,
+		User:     "slack:" + q.userID,
+		OnAnalysis: func(_ *bot.DataTable, sqlAnalysis *bot.SQLResponse) {
+			out = fmt.Sprintf(
+				"%s\n\n%s\n\nExecuting query `%s`",
+				out,
+				escape(sqlAnalysis.Applicability),
+				escape(sqlAnalysis.SQL))
+			edit(out)
+		},
+	})
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		edit(fmt.Sprintf("%s\n\nSorry, %v.", out, err))
+		return
+	case answer == nil:
+		log.Println("Error answering question:", err)
+		edit(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(err)))
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		edit(fmt.Sprintf("%s\n\n*No results found for that query.* Try again?", out))
+		return
+	case err != nil:
+		log.Println("Error answering question:", err)
+		edit(fmt.Sprintf("%s\n\n⚠️ %s", out, userMessage(err)))
+		return
+	case answer.SQLResponse.MissingData != "":
+		edit(fmt.Sprintf("%s\n%s", out, escape(answer.SQLResponse.MissingData)))
+		return
+	}
+
+	// Store query for subsequent charting and export,
+	query := &uq.UserQuery{
+		UserID:      q.userID,
+		GuildID:     q.teamID,
+		ChannelID:   q.channel,
+		Question:    q.text,
+		TableName:   answer.Table.Name,
+		SQLResponse: answer.SQLResponse,
+		Results:     answer.Results,
+	}
+	id, err := uq.StoreUserQuery(s.db, query)
+	if err != nil {
+		log.Println("Error storing query:", err)
+		edit(fmt.Sprintf("%s\n\n```Error: could not save query.```", out))
+		return
+	}
+	query.ID = id
+
+	if err := s.client.updateMessage(ctx, &message{
+		Channel: q.channel,
+		TS:      ts,
+		Text:    truncate(q.text, maxFallbackTextLen),
+		Blocks:  s.answerBlocks(query, !q.isDM()),
+	}); err != nil {
+		log.Println("Error posting answer:", err)
+	}
+}
+
+// respondEphemeral replies to a slash command or interaction with a message only the user can see.
+func (s *BotServer) respondEphemeral(ctx context.Context, responseURL, content string) {
+	if responseURL == "" {
+		return
+	}
+	if err := s.client.respond(ctx, responseURL, &message{
+		ResponseType: "ephemeral",
+		Text:         content,
+	}); err != nil {
+		log.Println("Error responding:", err)
+	}
+}
@@ -0,0 +1,36 @@
+package slack
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/geomodulus/torontobot/bot"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+func TestAnswerError(t *testing.T) {
+	db := testutil.DB(t)
+	ctx := context.Background()
+	// Nothing is recorded for this question, so answering it fails with an internal error.
+	tb, err := bot.New(ctx, db, testutil.AI(t), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFakeSlack(t)
+	s, err := OpenBotServer(ctx, db, "xoxb-test", testSigningSecret, tb, WithAPIURL(f.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := "Which ward has the most parking tickets on Tuesdays?"
+	go s.answer(ctx, &question{text: text, userID: "UASKER", teamID: "T1", channel: "CASK"})
+	f.expect(t, "chat.postMessage", text)
+	call := f.expect(t, "chat.update", "Sorry, something went wrong")
+	if !strings.Contains(call.msg.Text, text) {
+		t.Errorf("error reply %q doesn't include the question", call.msg.Text)
+	}
+	if strings.Contains(call.msg.Text, "cassette") {
+		t.Errorf("error reply %q shows the internal error", call.msg.Text)
+	}
+}
@@ -0,0 +1,87 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// eventCallback is a request from the Events API. See https://api.slack.com/apis/connections/events-api
+type eventCallback struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	TeamID    string `json:"team_id"`
+	Event     struct {
+		Type        string `json:"type"`
+		Subtype     string `json:"subtype"`
+		User        string `json:"user"`
+		BotID       string `json:"bot_id"`
+		Text        string `json:"text"`
+		Channel     string `json:"channel"`
+		ChannelType string `json:"channel_type"`
+		TS          string `json:"ts"`
+		ThreadTS    string `json:"thread_ts"`
+	} `json:"event"`
+}
+
+// handleEvent answers mentions of the bot in channels, in a thread on the mention, and direct
+// messages to it.
+func (s *BotServer) handleEvent(w http.ResponseWriter, r *http.Request) {
+	var cb eventCallback
+	if err := json.NewDecoder(r.Body).Decode(&cb); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if cb.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(cb.Challenge))
+		return
+	}
+	// Slack retries events it thinks weren't acknowledged in time, but we acknowledge before
+	// answering, so a retry would answer twice.
+	w.WriteHeader(http.StatusOK)
+	if cb.Type != "event_callback" || r.Header.Get("X-Slack-Retry-Num") != "" {
+		return
+	}
+
+	ev := cb.Event
+	q := &question{
+		userID:  ev.User,
+		teamID:  cb.TeamID,
+		channel: ev.Channel,
+	}
+	switch {
+	case ev.Type == "app_mention":
+		q.threadTS = ev.ThreadTS
+		if q.threadTS == "" {
+			q.threadTS = ev.TS
+		}
+	case ev.Type == "message" && ev.ChannelType == "im":
+		// Ignore our own messages, edits and other message subtypes.
+		if ev.BotID != "" || ev.Subtype != "" || ev.User == s.userID {
+			return
+		}
+	default:
+		// Not the event we are looking for.
+		return
+	}
+
+	q.text = strings.TrimSpace(strings.ReplaceAll(ev.Text, "<@"+s.userID+">", ""))
+	ctx := context.Background()
+	if q.text == "" {
+		go func() {
+			if _, err := s.client.postMessage(ctx, &message{
+				Channel:  q.channel,
+				ThreadTS: q.threadTS,
+				Text:     usage,
+			}); err != nil {
+				log.Println("Error posting usage:", err)
+			}
+		}()
+		return
+	}
+	log.Printf("Received Slack question: %s\n", q.text)
+	go s.answer(ctx, q)
+}
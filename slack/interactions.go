@@ -0,0 +1,186 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/viz"
+)
+
+// interaction is a button click on one of the bot's messages. See
+// https://api.slack.com/reference/interaction-payloads/block-actions
+type interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Message struct {
+		TS       string `json:"ts"`
+		ThreadTS string `json:"thread_ts"`
+		Text     string `json:"text"`
+	} `json:"message"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// threadTS returns the thread to reply to the clicked message in.
+func (in *interaction) threadTS() string {
+	if in.Message.ThreadTS != "" {
+		return in.Message.ThreadTS
+	}
+	return in.Message.TS
+}
+
+// userError is an error with a message meant for the user, wrapping the underlying cause.
+type userError struct {
+	msg string
+	err error
+}
+
+func (e *userError) Error() string {
+	if e.err == nil {
+		return e.msg
+	}
+	return fmt.Sprintf("%s: %v", e.msg, e.err)
+}
+
+func (e *userError) Unwrap() error {
+	return e.err
+}
+
+// userMessage returns the message to show a user for an error: the message of a userError, or a
+// generic apology for anything else, so internal errors aren't shown.
+func userMessage(err error) string {
+	var uerr *userError
+	if errors.As(err, &uerr) {
+		return uerr.msg
+	}
+	return "Sorry, something went wrong. Please try again."
+}
+
+// interactionAction handles a click on a button, whose value is passed in.
+type interactionAction func(ctx context.Context, in *interaction, value string) error
+
+// handleInteraction handles clicks on the chart and export buttons of answers, and on the approve
+// and reject buttons of exports held for moderation. Like slash commands, they're acknowledged
+// straight away and handled in the background.
+func (s *BotServer) handleInteraction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	var in interaction
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &in); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if in.Type != "block_actions" || len(in.Actions) == 0 {
+		return
+	}
+
+	action := in.Actions[0]
+	var handle interactionAction
+	switch action.ActionID {
+	case "chart":
+		handle = s.queryAction(s.chartAction)
+	case "export":
+		handle = s.queryAction(s.exportAction)
+	case "publish-approve":
+		handle = s.reviewAction(true)
+	case "publish-reject":
+		handle = s.reviewAction(false)
+	default:
+		log.Println("Unknown Slack action:", action.ActionID)
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		if err := handle(ctx, &in, action.Value); err != nil {
+			log.Printf("Error handling %s: %v\n", action.ActionID, err)
+			s.respondEphemeral(ctx, in.ResponseURL, "⚠️ "+userMessage(err))
+		}
+	}()
+}
+
+// queryAction handles a click on a button of an answer, whose value is the ID of its stored query.
+func (s *BotServer) queryAction(handle func(ctx context.Context, in *interaction, query *uq.UserQuery) error) interactionAction {
+	return func(ctx context.Context, in *interaction, id string) error {
+		query, err := s.query(id)
+		if err != nil {
+			return err
+		}
+		return handle(ctx, in, query)
+	}
+}
+
+// query loads the stored query with the given ID.
+func (s *BotServer) query(id string) (*uq.UserQuery, error) {
+	query, err := uq.GetUserQuery(s.db, id)
+	if err != nil {
+		return nil, &userError{msg: "Sorry, I couldn't load that answer. Please try again.", err: err}
+	}
+	if query == nil {
+		return nil, &userError{msg: "Sorry, I couldn't find that answer anymore."}
+	}
+	return query, nil
+}
+
+// chartAction uploads a chart of the answer whose "Generate chart" button was clicked, in a thread on
+// the answer.
+func (s *BotServer) chartAction(ctx context.Context, in *interaction, query *uq.UserQuery) error {
+	chartSelected, err := s.bot.SelectChart(ctx, query.Question, query.Results)
+	if err != nil {
+		return &userError{msg: "Sorry, I couldn't draw a chart for that answer.", err: err}
+	}
+	chartHTML, err := chartSelected.HTML(
+		false, // not dark mode
+		viz.WithFixedWidth(675),
+		viz.WithFixedHeight(750),
+	)
+	if err != nil {
+		var unsupported *bot.UnsupportedChartError
+		if errors.As(err, &unsupported) {
+			return &userError{msg: fmt.Sprintf("Ah you need a %s chart, but I can't make those yet. Soon 😈", unsupported.Chart)}
+		}
+		return &userError{msg: "Sorry, I couldn't draw a chart for that answer.", err: err}
+	}
+	pngBytes, err := viz.ScreenshotHTML(
+		ctx,
+		chartHTML,
+		viz.WithWidth(675),
+		viz.WithHeight(750),
+	)
+	if err != nil {
+		return &userError{msg: "Sorry, I couldn't draw a chart for that answer.", err: fmt.Errorf("generating PNG: %v", err)}
+	}
+
+	if err := s.client.uploadFile(
+		ctx,
+		in.Channel.ID,
+		in.threadTS(),
+		"chart.png",
+		chartSelected.Title,
+		"Here's my attempt at a chart! 📊",
+		pngBytes,
+	); err != nil {
+		return fmt.Errorf("uploading chart: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+	"github.com/geomodulus/torontobot/internal/testutil"
+)
+
+const testSigningSecret = "secret"
+
+// slackCall is a request the bot made to the stand-in Slack: a Web API method, or "respond" for a
+// response URL.
+type slackCall struct {
+	method string
+	msg    message
+	// blocks are the message's blocks, decoded generically.
+	blocks []map[string]interface{}
+}
+
+// fakeSlack is a stand-in for Slack's Web API and response URLs, which reports the calls it gets.
+type fakeSlack struct {
+	*httptest.Server
+	calls chan *slackCall
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	t.Helper()
+	f := &fakeSlack{calls: make(chan *slackCall, 100)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		w.Header().Set("Content-Type", "application/json")
+		if method == "auth.test" {
+			w.Write([]byte(`{"ok": true, "user_id": "UBOT"}`))
+			return
+		}
+		call := &slackCall{method: method}
+		var raw struct {
+			Blocks []map[string]interface{} `json:"blocks"`
+		}
+		if err := json.Unmarshal(body, &call.msg); err != nil {
+			t.Errorf("decoding %s request: %v", method, err)
+		}
+		json.Unmarshal(body, &raw)
+		call.blocks = raw.Blocks
+		f.calls <- call
+		w.Write([]byte(`{"ok": true, "ts": "1700000000.000200"}`))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// next returns the next call the bot makes.
+func (f *fakeSlack) next(t *testing.T) *slackCall {
+	t.Helper()
+	select {
+	case call := <-f.calls:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a call to Slack")
+		return nil
+	}
+}
+
+// fakePublisher publishes answers without a graph, counting them.
+type fakePublisher struct {
+	published int32
+}
+
+func (p *fakePublisher) HasGraphStore() bool { return true }
+
+func (p *fakePublisher) Publish(ctx context.Context, question string, sqlResponse *bot.SQLResponse, results, author string) (*bot.Publication, error) {
+	n := atomic.AddInt32(&p.published, 1)
+	return &bot.Publication{ModuleID: fmt.Sprint("module-", n), URL: fmt.Sprint("https://torontoverse.com/module-", n)}, nil
+}
+
+// testServer returns a Slack bot server talking to a stand-in Slack, with a stored answer.
+func testServer(t *testing.T, options ...Option) (*BotServer, *fakeSlack, *fakePublisher, int64) {
+	t.Helper()
+	db := testutil.DB(t)
+	f := newFakeSlack(t)
+	s, err := OpenBotServer(context.Background(), db, "xoxb-test", testSigningSecret, nil, append(options, WithAPIURL(f.URL))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &fakePublisher{}
+	s.publisher = pub
+	id, err := uq.StoreUserQuery(db, &uq.UserQuery{
+		UserID:      "UASKER",
+		GuildID:     "T1",
+		ChannelID:   "CASK",
+		Question:    "What are the 8 most expensive programs?",
+		TableName:   "operating_budget",
+		SQLResponse: &bot.SQLResponse{SQL: "SELECT program FROM operating_budget LIMIT 8"},
+		Results:     "| program |",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, f, pub, id
+}
+
+// click sends the bot a signed click of a button by user, on the message with ts.
+func (f *fakeSlack) click(t *testing.T, s *BotServer, user, actionID string, value int64, ts string) int {
+	t.Helper()
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"user":         map[string]string{"id": user, "username": strings.ToLower(user)},
+		"team":         map[string]string{"id": "T1"},
+		"channel":      map[string]string{"id": "CASK"},
+		"message":      map[string]string{"ts": ts, "text": "the clicked message"},
+		"response_url": f.URL + "/respond",
+		"actions":      []map[string]string{{"action_id": actionID, "value": strconv.FormatInt(value, 10)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	req := httptest.NewRequest(http.MethodPost, "/slack/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	sign(req.Header, body, time.Now())
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	return w.Code
+}
+
+// sign signs a request body as Slack would.
+func sign(header http.Header, body string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSigningSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// expect waits for a call to method whose text contains want.
+func (f *fakeSlack) expect(t *testing.T, method, want string) *slackCall {
+	t.Helper()
+	call := f.next(t)
+	if call.method != method || !strings.Contains(call.msg.Text, want) {
+		t.Fatalf("got %s %q, want %s containing %q", call.method, call.msg.Text, method, want)
+	}
+	return call
+}
+
+func TestExportOff(t *testing.T) {
+	s, f, pub, id := testServer(t)
+	if code := f.click(t, s, "UASKER", "export", id, "1.1"); code != http.StatusOK {
+		t.Fatalf("click = %d", code)
+	}
+	f.expect(t, "respond", "isn't available")
+	if n := atomic.LoadInt32(&pub.published); n != 0 {
+		t.Errorf("published %d charts with exporting off", n)
+	}
+}
+
+func TestExportPublisher(t *testing.T) {
+	s, f, pub, id := testServer(t, WithPublishers("UPUB"))
+	f.click(t, s, "UPUB", "export", id, "1.1")
+	call := f.expect(t, "chat.postMessage", "published this chart at https://torontoverse.com/module-1")
+	if call.msg.Channel != "CASK" || call.msg.ThreadTS != "1.1" {
+		t.Errorf("published URL posted to %s in thread %s, want CASK in 1.1", call.msg.Channel, call.msg.ThreadTS)
+	}
+	pubs, err := uq.Publications(s.db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubs) != 1 || pubs[0].UserID != "UPUB" || pubs[0].ApprovedBy != "" {
+		t.Errorf("publications = %+v, want one by UPUB without approval", pubs)
+	}
+
+	// Without moderators, anyone else is refused.
+	f.click(t, s, "UOTHER", "export", id, "1.1")
+	f.expect(t, "respond", "only publishers")
+	if n := atomic.LoadInt32(&pub.published); n != 1 {
+		t.Errorf("published %d charts, want 1", n)
+	}
+}
+
+func TestExportApproval(t *testing.T) {
+	s, f, pub, id := testServer(t, WithPublishers("UPUB"), WithModerators("UMOD"), WithModerationChannel("CMOD"))
+	f.click(t, s, "UOTHER", "export", id, "1.1")
+	call := f.expect(t, "chat.postMessage", "<@UOTHER> would like to publish")
+	if call.msg.Channel != "CMOD" {
+		t.Errorf("approval request posted to %s, want CMOD", call.msg.Channel)
+	}
+	actions := call.blocks[len(call.blocks)-1]
+	buttons, _ := actions["elements"].([]interface{})
+	if len(buttons) != 2 {
+		t.Fatalf("approval request has actions %v, want approve and reject buttons", actions)
+	}
+	reqID, err := strconv.ParseInt(buttons[0].(map[string]interface{})["value"].(string), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.expect(t, "respond", "sent to the moderators")
+	if n := atomic.LoadInt32(&pub.published); n != 0 {
+		t.Fatalf("published %d charts before approval", n)
+	}
+
+	// Only moderators can approve.
+	for _, user := range []string{"UOTHER", "UPUB"} {
+		f.click(t, s, user, "publish-approve", reqID, "2.2")
+		f.expect(t, "respond", "Only moderators")
+	}
+	if req, err := uq.GetPublishRequest(s.db, reqID); err != nil || req.Status != uq.PublishPending {
+		t.Fatalf("request after non-moderators clicked = %+v, %v, want pending", req, err)
+	}
+
+	f.click(t, s, "UMOD", "publish-approve", reqID, "2.2")
+	update := f.expect(t, "chat.update", "Approved by <@UMOD> and published at https://torontoverse.com/module-1")
+	if update.msg.TS != "2.2" || len(update.blocks) != 0 {
+		t.Errorf("updated message %s with blocks %v, want 2.2 without buttons", update.msg.TS, update.blocks)
+	}
+	if notice := f.expect(t, "chat.postMessage", "<@UOTHER> your chart was approved"); notice.msg.Channel != "CASK" {
+		t.Errorf("requester notified in %s, want CASK", notice.msg.Channel)
+	}
+	pubs, err := uq.Publications(s.db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubs) != 1 || pubs[0].UserID != "UOTHER" || pubs[0].ApprovedBy != "UMOD" {
+		t.Errorf("publications = %+v, want one by UOTHER approved by UMOD", pubs)
+	}
+
+	// A request is only reviewed once.
+	f.click(t, s, "UMOD", "publish-reject", reqID, "2.2")
+	f.expect(t, "respond", "already reviewed")
+	if n := atomic.LoadInt32(&pub.published); n != 1 {
+		t.Errorf("published %d charts, want 1", n)
+	}
+}
+
+func TestExportRejected(t *testing.T) {
+	s, f, pub, id := testServer(t, WithModerators("UMOD"))
+	f.click(t, s, "UOTHER", "export", id, "1.1")
+	call := f.expect(t, "chat.postMessage", "would like to publish")
+	if call.msg.Channel != "CASK" {
+		t.Errorf("approval request posted to %s, want the channel it came from", call.msg.Channel)
+	}
+	f.expect(t, "respond", "sent to the moderators")
+
+	reqs, err := s.db.Query("SELECT id FROM publish_requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqID int64
+	for reqs.Next() {
+		reqs.Scan(&reqID)
+	}
+	reqs.Close()
+	f.click(t, s, "UMOD", "publish-reject", reqID, "2.2")
+	f.expect(t, "chat.update", "Rejected by <@UMOD>")
+	f.expect(t, "chat.postMessage", "decided not to publish")
+	if n := atomic.LoadInt32(&pub.published); n != 0 {
+		t.Errorf("published %d charts after rejection", n)
+	}
+}
+
+func TestInteractionMissingQuery(t *testing.T) {
+	s, f, _, id := testServer(t, WithPublishers("UPUB"))
+	f.click(t, s, "UPUB", "export", id+1, "1.1")
+	f.expect(t, "respond", "couldn't find that answer")
+}
+
+func TestInteractionSignature(t *testing.T) {
+	s, _, _, _ := testServer(t)
+	body := url.Values{"payload": {`{"type": "block_actions"}`}}.Encode()
+	for name, sign := range map[string]func(http.Header){
+		"unsigned": func(http.Header) {},
+		"wrong secret": func(h http.Header) {
+			h.Set("X-Slack-Request-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+			h.Set("X-Slack-Signature", "v0=00")
+		},
+		"replayed": func(h http.Header) {
+			sign(h, body, time.Now().Add(-time.Hour))
+		},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/slack/interactions", strings.NewReader(body))
+		sign(req.Header)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s request = %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/geomodulus/torontobot/bot"
+	uq "github.com/geomodulus/torontobot/db"
+)
+
+// publisher publishes answers to the web.
+type publisher interface {
+	HasGraphStore() bool
+	Publish(ctx context.Context, question string, sqlResponse *bot.SQLResponse, results, author string) (*bot.Publication, error)
+}
+
+// WithPublishers allows the users with the given Slack user IDs to publish to the web. Exports by
+// anyone else are held for approval by a moderator, or refused if there are no moderators. Without
+// publishers or moderators, exporting is turned off.
+func WithPublishers(userIDs ...string) Option {
+	return func(s *BotServer) {
+		s.publishers = userSet(userIDs)
+	}
+}
+
+// WithModerators allows the users with the given Slack user IDs to publish to the web, and to
+// approve or reject exports held for moderation.
+func WithModerators(userIDs ...string) Option {
+	return func(s *BotServer) {
+		s.moderators = userSet(userIDs)
+	}
+}
+
+// WithModerationChannel posts exports held for approval to the given channel, rather than the
+// channel they were requested in.
+func WithModerationChannel(channelID string) Option {
+	return func(s *BotServer) {
+		s.moderationChannel = channelID
+	}
+}
+
+func userSet(userIDs []string) map[string]bool {
+	set := map[string]bool{}
+	for _, id := range userIDs {
+		set[id] = true
+	}
+	return set
+}
+
+// exportEnabled reports whether anyone may publish to the web.
+func (s *BotServer) exportEnabled() bool {
+	return len(s.publishers) > 0 || len(s.moderators) > 0
+}
+
+// canPublish reports whether a user may publish to the web without approval.
+func (s *BotServer) canPublish(userID string) bool {
+	return s.publishers[userID] || s.canModerate(userID)
+}
+
+// canModerate reports whether a user may approve or reject exports held for moderation.
+func (s *BotServer) canModerate(userID string) bool {
+	return s.moderators[userID]
+}
+
+// exportAction publishes the answer whose "Export to Web" button was clicked as an interactive chart
+// on the web, or queues it for a moderator if the user isn't allowed to publish.
+func (s *BotServer) exportAction(ctx context.Context, in *interaction, query *uq.UserQuery) error {
+	if !s.exportEnabled() || !s.publisher.HasGraphStore() {
+		return &userError{msg: "Sorry, exporting to the web isn't available here."}
+	}
+	if !s.canPublish(in.User.ID) {
+		if len(s.moderators) == 0 {
+			return &userError{msg: "Sorry, only publishers can export answers to the web."}
+		}
+		return s.requestApproval(ctx, in, query)
+	}
+
+	url, err := s.publish(ctx, query, in.User.ID, in.User.Username, in.Team.ID, "")
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.postMessage(ctx, &message{
+		Channel:  in.Channel.ID,
+		ThreadTS: in.threadTS(),
+		Text:     fmt.Sprintf("<@%s> published this chart at %s", in.User.ID, url),
+	}); err != nil {
+		return fmt.Errorf("posting published URL: %v", err)
+	}
+	return nil
+}
+
+// requestApproval queues an export and asks moderators to approve or reject it.
+func (s *BotServer) requestApproval(ctx context.Context, in *interaction, query *uq.UserQuery) error {
+	id, err := uq.StorePublishRequest(s.db, &uq.PublishRequest{
+		QueryID:   query.ID,
+		UserID:    in.User.ID,
+		Username:  in.User.Username,
+		GuildID:   in.Team.ID,
+		ChannelID: in.Channel.ID,
+	})
+	if err != nil {
+		return &userError{msg: "Sorry, I couldn't send your chart for approval.", err: err}
+	}
+
+	channelID := s.moderationChannel
+	if channelID == "" {
+		channelID = in.Channel.ID
+	}
+	prompt := fmt.Sprintf("📝 <@%s> would like to publish this answer to the web. Moderators, approve?", in.User.ID)
+	blocks := append([]*block{section(prompt)}, queryBlocks(query)...)
+	approve, reject := newButton("Approve", "publish-approve", id), newButton("Reject", "publish-reject", id)
+	approve.Style, reject.Style = "primary", "danger"
+	blocks = append(blocks, &block{Type: "actions", Elements: []interface{}{approve, reject}})
+	if _, err := s.client.postMessage(ctx, &message{
+		Channel: channelID,
+		Text:    prompt,
+		Blocks:  blocks,
+	}); err != nil {
+		return &userError{msg: "Sorry, I couldn't send your chart for approval.", err: err}
+	}
+
+	s.respondEphemeral(ctx, in.ResponseURL, "Thanks! Your chart has been sent to the moderators for approval before it's published.")
+	return nil
+}
+
+// reviewAction approves or rejects an export held for moderation, whose request ID is the value of
+// the clicked button.
+func (s *BotServer) reviewAction(approve bool) interactionAction {
+	return func(ctx context.Context, in *interaction, value string) error {
+		if !s.canModerate(in.User.ID) {
+			return &userError{msg: "Only moderators can approve or reject exports."}
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid publish request ID %q", value)
+		}
+		req, err := uq.GetPublishRequest(s.db, id)
+		if err != nil || req == nil {
+			return &userError{msg: "Sorry, I couldn't find that request anymore.", err: err}
+		}
+		status := uq.PublishRejected
+		if approve {
+			status = uq.PublishApproved
+		}
+		ok, err := uq.ReviewPublishRequest(s.db, id, status, in.User.ID)
+		if err != nil {
+			return &userError{msg: "Sorry, I couldn't update that request.", err: err}
+		}
+		if !ok {
+			return &userError{msg: "Another moderator has already reviewed this request."}
+		}
+
+		var outcome, notice string
+		if approve {
+			query, err := uq.GetUserQuery(s.db, strconv.FormatInt(req.QueryID, 10))
+			if err == nil && query == nil {
+				err = fmt.Errorf("query %d not found", req.QueryID)
+			}
+			var url string
+			if err == nil {
+				url, err = s.publish(ctx, query, req.UserID, req.Username, req.GuildID, in.User.ID)
+			}
+			if err != nil {
+				if err := uq.ReopenPublishRequest(s.db, id); err != nil {
+					log.Println("Error reopening publish request:", err)
+				}
+				return err
+			}
+			outcome = fmt.Sprintf("✅ Approved by <@%s> and published at %s", in.User.ID, url)
+			notice = fmt.Sprintf("<@%s> your chart was approved and published at %s", req.UserID, url)
+		} else {
+			outcome = fmt.Sprintf("❌ Rejected by <@%s>", in.User.ID)
+			notice = fmt.Sprintf("<@%s> sorry, a moderator decided not to publish your chart.", req.UserID)
+		}
+
+		// Replace the request with its outcome, which removes the buttons.
+		if err := s.client.updateMessage(ctx, &message{
+			Channel: in.Channel.ID,
+			TS:      in.Message.TS,
+			Text:    in.Message.Text + "\n" + outcome,
+		}); err != nil {
+			log.Println("Error updating moderation message:", err)
+		}
+		if _, err := s.client.postMessage(ctx, &message{
+			Channel: req.ChannelID,
+			Text:    notice,
+		}); err != nil {
+			log.Println("Error notifying requester:", err)
+		}
+		return nil
+	}
+}
+
+// publish charts a stored answer and saves it to the graph as a module on the web, recording it in
+// the audit log. approvedBy is the moderator who approved it, if it needed approval. It returns the
+// URL of the published module.
+func (s *BotServer) publish(ctx context.Context, query *uq.UserQuery, userID, username, teamID, approvedBy string) (string, error) {
+	pub, err := s.publisher.Publish(ctx, query.Question, query.SQLResponse, query.Results, username)
+	if err != nil {
+		var unsupported *bot.UnsupportedChartError
+		if errors.As(err, &unsupported) {
+			return "", &userError{msg: fmt.Sprintf("Ah you need a %s chart, but I can't publish those yet. Soon 😈", unsupported.Chart)}
+		}
+		return "", &userError{msg: "Sorry, I couldn't publish the chart.", err: err}
+	}
+
+	if err := uq.StorePublication(s.db, &uq.Publication{
+		ModuleID:   pub.ModuleID,
+		URL:        pub.URL,
+		QueryID:    query.ID,
+		UserID:     userID,
+		Username:   username,
+		GuildID:    teamID,
+		ApprovedBy: approvedBy,
+	}); err != nil {
+		// The module is already live, so don't fail the export over the audit log.
+		log.Printf("Error recording publication of module %s: %v\n", pub.ModuleID, err)
+	}
+	return pub.URL, nil
+}
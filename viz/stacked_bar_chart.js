@@ -41,6 +41,9 @@ const margin = { top: 50, right: 210, bottom: 0, left: 0 },
     currency: "USD",
   });
 
+// Data entries have a single Value unless the input names the keys to stack.
+const keys = input.Keys || ["Value"];
+
 const width = baseWidth - margin.left - margin.right;
 const height = baseHeight - margin.top - margin.bottom;
 
@@ -52,7 +55,7 @@ const y = d3
 
 const x = d3
   .scaleLinear()
-  .domain([0, d3.max(input.Data, (d) => d.Value1 + d.Value2)])
+  .domain([0, d3.max(input.Data, (d) => d3.sum(keys, (k) => d[k]))])
   .range([0, width]);
 
 const yAxis = d3.axisLeft(y).tickSize(0);
@@ -64,7 +67,7 @@ const xAxis = d3
 const color = d3
   .scaleOrdinal()
   .range(["#6b486b", "#a05d56", "#d0743c", "#ff8c00"]);
-const stack = d3.stack().keys(["Value1", "Value2"])(input.Data);
+const stack = d3.stack().keys(keys)(input.Data);
 
 const svg = d3
   .select(input.Selector)
@@ -188,7 +191,7 @@ legend
   .attr("y", 9)
   .attr("dy", ".35em")
   .style("text-anchor", "end")
-  .text((d) => (d === "Value1" ? "Type 1" : "Type 2")); // replace "Type 1" and "Type 2" with your actual types
+  .text((d) => d);
 
 function splitAtWordBoundary(str, limit) {
   if (str.length <= limit) {
@@ -269,21 +269,24 @@ const htmlContent = `
 </html>
 `
 
-// GenerateBarChartHTML generates an bare HTML file containing only styles, fonts and.
-func GenerateBarChartHTML(title string, data []*DataEntry, isCurrency, darkMode bool, options ...ChartOption) (string, error) {
-	js, err := GenerateBarChartJS("body", title, data, isCurrency, options...)
-	if err != nil {
-		return "", fmt.Errorf("generating js: %v", err)
-	}
+// ChartHTML wraps a script which draws a chart into the page body as a bare HTML file containing only
+// styles, fonts and the chart.
+func ChartHTML(js string, darkMode bool) string {
 	var theme string
 	if darkMode {
 		theme = "dark"
 	}
-	themedHTML, err := strings.Replace(htmlContent, "REPLACE_ME_WITH_THEME", theme, 1), nil
+	themedHTML := strings.Replace(htmlContent, "REPLACE_ME_WITH_THEME", theme, 1)
+	return strings.Replace(themedHTML, "REPLACE_ME_WITH_CHART_JS", js, 1)
+}
+
+// GenerateBarChartHTML generates an bare HTML file containing only styles, fonts and.
+func GenerateBarChartHTML(title string, data []*DataEntry, isCurrency, darkMode bool, options ...ChartOption) (string, error) {
+	js, err := GenerateBarChartJS("body", title, data, isCurrency, options...)
 	if err != nil {
-		return "", fmt.Errorf("replacing theme: %v", err)
+		return "", fmt.Errorf("generating js: %v", err)
 	}
-	return strings.Replace(themedHTML, "REPLACE_ME_WITH_CHART_JS", js, 1), nil
+	return ChartHTML(js, darkMode), nil
 }
 
 // GenerateLineChartHTML generates an bare HTML file containing only styles, fonts and.
@@ -292,15 +295,7 @@ func GenerateLineChartHTML(title string, data []*DataEntry, isCurrency, darkMode
 	if err != nil {
 		return "", fmt.Errorf("generating js: %v", err)
 	}
-	var theme string
-	if darkMode {
-		theme = "dark"
-	}
-	themedHTML, err := strings.Replace(htmlContent, "REPLACE_ME_WITH_THEME", theme, 1), nil
-	if err != nil {
-		return "", fmt.Errorf("replacing theme: %v", err)
-	}
-	return strings.Replace(themedHTML, "REPLACE_ME_WITH_CHART_JS", js, 1), nil
+	return ChartHTML(js, darkMode), nil
 }
 
 // GeneratePieChartHTML generates an bare HTML file containing only styles, fonts and.
@@ -309,15 +304,7 @@ func GeneratePieChartHTML(title string, data []*DataEntry, isCurrency, darkMode
 	if err != nil {
 		return "", fmt.Errorf("generating js: %v", err)
 	}
-	var theme string
-	if darkMode {
-		theme = "dark"
-	}
-	themedHTML, err := strings.Replace(htmlContent, "REPLACE_ME_WITH_THEME", theme, 1), nil
-	if err != nil {
-		return "", fmt.Errorf("replacing theme: %v", err)
-	}
-	return strings.Replace(themedHTML, "REPLACE_ME_WITH_CHART_JS", js, 1), nil
+	return ChartHTML(js, darkMode), nil
 }
 
 type ScreenshotOptions struct {
@@ -374,6 +361,26 @@ func ScreenshotHTML(ctx context.Context, srcHTML string, options ...ScreenshotOp
 	return buf, nil
 }
 
+// RenderSVG renders chart HTML in a headless browser and returns the SVG element the chart drew.
+func RenderSVG(ctx context.Context, srcHTML string) (string, error) {
+	ctx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+
+	ctx, cancel = context.WithTimeout(ctx, 75*time.Second)
+	defer cancel()
+
+	dataURL := "data:text/html;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(srcHTML))
+	var svg string
+	if err := chromedp.Run(ctx,
+		chromedp.Navigate(dataURL),
+		chromedp.WaitVisible("svg", chromedp.ByQuery),
+		chromedp.OuterHTML("svg", &svg, chromedp.ByQuery),
+	); err != nil {
+		return "", fmt.Errorf("running chromedp: %v", err)
+	}
+	return svg, nil
+}
+
 func saveScreenshotPNG(htmlContent string, waitForSelectors []string, width, height, scale float64, buf *[]byte) chromedp.Tasks {
 	dataURL := "data:text/html;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(htmlContent))
 